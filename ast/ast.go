@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/rhwilr/lemur/token"
+	"math/big"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.TokenPosition
 }
 
 type Statement interface {
@@ -36,6 +38,13 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.TokenPosition {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.TokenPosition{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -54,9 +63,22 @@ type Identifier struct {
 	Value string
 }
 
-func (i *Identifier) expressionNode()      {}
-func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
-func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) expressionNode()          {}
+func (i *Identifier) TokenLiteral() string     { return i.Token.Literal }
+func (i *Identifier) Pos() token.TokenPosition { return i.Token.Position }
+func (i *Identifier) String() string           { return i.Value }
+
+/*
+** TypeAnnotation
+ */
+type TypeAnnotation struct {
+	Token token.Token // the name of the type
+	Name  string
+}
+
+func (ta *TypeAnnotation) TokenLiteral() string     { return ta.Token.Literal }
+func (ta *TypeAnnotation) Pos() token.TokenPosition { return ta.Token.Position }
+func (ta *TypeAnnotation) String() string           { return ta.Name }
 
 /*
 ** Boolean
@@ -66,9 +88,10 @@ type Boolean struct {
 	Value bool
 }
 
-func (b *Boolean) expressionNode()      {}
-func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
-func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) expressionNode()          {}
+func (b *Boolean) TokenLiteral() string     { return b.Token.Literal }
+func (b *Boolean) Pos() token.TokenPosition { return b.Token.Position }
+func (b *Boolean) String() string           { return b.Token.Literal }
 
 /*
 ** LetStatement
@@ -76,16 +99,21 @@ func (b *Boolean) String() string       { return b.Token.Literal }
 type LetStatement struct {
 	Token token.Token // token.LET
 	Name  *Identifier
+	Type  *TypeAnnotation // optional, written as `let x: int = ...`
 	Value Expression
 }
 
-func (ls *LetStatement) statementNode()       {}
-func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) statementNode()           {}
+func (ls *LetStatement) TokenLiteral() string     { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.TokenPosition { return ls.Token.Position }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -101,18 +129,27 @@ func (ls *LetStatement) String() string {
 ** ConstStatement
  */
 type ConstStatement struct {
-	Token token.Token // token.CONST
-	Name  *Identifier
-	Value Expression
+	Token  token.Token // token.CONST
+	Name   *Identifier
+	Type   *TypeAnnotation // optional, written as `const x: int = ...`
+	Value  Expression
+	Freeze bool // deep-freeze the value, written as `const freeze x = ...`
 }
 
-func (ls *ConstStatement) statementNode()       {}
-func (ls *ConstStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *ConstStatement) statementNode()           {}
+func (ls *ConstStatement) TokenLiteral() string     { return ls.Token.Literal }
+func (ls *ConstStatement) Pos() token.TokenPosition { return ls.Token.Position }
 func (ls *ConstStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
+	if ls.Freeze {
+		out.WriteString("freeze ")
+	}
 	out.WriteString(ls.Name.TokenLiteral())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -132,8 +169,9 @@ type ReturnStatement struct {
 	ReturnValue Expression
 }
 
-func (rs *ReturnStatement) statementNode()       {}
-func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) statementNode()           {}
+func (rs *ReturnStatement) TokenLiteral() string     { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.TokenPosition { return rs.Token.Position }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -154,10 +192,12 @@ func (rs *ReturnStatement) String() string {
 type BlockStatement struct {
 	Token      token.Token // token.LBRACE
 	Statements []Statement
+	Rbrace     token.TokenPosition // position of the closing '}'
 }
 
-func (bs *BlockStatement) statementNode()       {}
-func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) statementNode()           {}
+func (bs *BlockStatement) TokenLiteral() string     { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.TokenPosition { return bs.Token.Position }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -176,8 +216,9 @@ type ExpressionStatement struct {
 	Expression Expression
 }
 
-func (es *ExpressionStatement) statementNode()       {}
-func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) statementNode()           {}
+func (es *ExpressionStatement) TokenLiteral() string     { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.TokenPosition { return es.Token.Position }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -194,9 +235,23 @@ type IntegerLiteral struct {
 	Value int64
 }
 
-func (il *IntegerLiteral) expressionNode()      {}
-func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
-func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) expressionNode()          {}
+func (il *IntegerLiteral) TokenLiteral() string     { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.TokenPosition { return il.Token.Position }
+func (il *IntegerLiteral) String() string           { return il.Token.Literal }
+
+/*
+** BigIntegerLiteral
+ */
+type BigIntegerLiteral struct {
+	Token token.Token // token.INT
+	Value *big.Int
+}
+
+func (bl *BigIntegerLiteral) expressionNode()          {}
+func (bl *BigIntegerLiteral) TokenLiteral() string     { return bl.Token.Literal }
+func (bl *BigIntegerLiteral) Pos() token.TokenPosition { return bl.Token.Position }
+func (bl *BigIntegerLiteral) String() string           { return bl.Token.Literal }
 
 /*
 ** StringLiteral
@@ -206,9 +261,23 @@ type StringLiteral struct {
 	Value string
 }
 
-func (sl *StringLiteral) expressionNode()      {}
-func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
-func (sl *StringLiteral) String() string       { return `"` + sl.Token.Literal + `"` }
+func (sl *StringLiteral) expressionNode()          {}
+func (sl *StringLiteral) TokenLiteral() string     { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.TokenPosition { return sl.Token.Position }
+func (sl *StringLiteral) String() string           { return `"` + sl.Token.Literal + `"` }
+
+/*
+** CharLiteral
+ */
+type CharLiteral struct {
+	Token token.Token // token.CHAR
+	Value rune
+}
+
+func (cl *CharLiteral) expressionNode()          {}
+func (cl *CharLiteral) TokenLiteral() string     { return cl.Token.Literal }
+func (cl *CharLiteral) Pos() token.TokenPosition { return cl.Token.Position }
+func (cl *CharLiteral) String() string           { return "'" + cl.Token.Literal + "'" }
 
 /*
 ** ArrayLiteral
@@ -218,8 +287,9 @@ type ArrayLiteral struct {
 	Elements []Expression
 }
 
-func (al *ArrayLiteral) expressionNode()      {}
-func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) expressionNode()          {}
+func (al *ArrayLiteral) TokenLiteral() string     { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.TokenPosition { return al.Token.Position }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -235,22 +305,49 @@ func (al *ArrayLiteral) String() string {
 	return out.String()
 }
 
+/*
+** SetLiteral
+ */
+type SetLiteral struct {
+	Token    token.Token // the '#{' token
+	Elements []Expression
+}
+
+func (sl *SetLiteral) expressionNode()          {}
+func (sl *SetLiteral) TokenLiteral() string     { return sl.Token.Literal }
+func (sl *SetLiteral) Pos() token.TokenPosition { return sl.Token.Position }
+func (sl *SetLiteral) String() string {
+	elements := []string{}
+	for _, el := range sl.Elements {
+		elements = append(elements, el.String())
+	}
+
+	return "#{" + strings.Join(elements, ", ") + "}"
+}
+
 /*
 ** HashLiteral
  */
 type HashLiteral struct {
 	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Pairs []*HashLiteralPair
 }
 
-func (hl *HashLiteral) expressionNode()      {}
-func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+// HashLiteralPair is a key and its value, kept in the order of the source.
+type HashLiteralPair struct {
+	Key   Expression
+	Value Expression
+}
+
+func (hl *HashLiteral) expressionNode()          {}
+func (hl *HashLiteral) TokenLiteral() string     { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.TokenPosition { return hl.Token.Position }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
 	}
 
 	out.WriteString("{")
@@ -269,8 +366,9 @@ type IndexExpression struct {
 	Index Expression
 }
 
-func (ie *IndexExpression) expressionNode()      {}
-func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) expressionNode()          {}
+func (ie *IndexExpression) TokenLiteral() string     { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.TokenPosition { return ie.Token.Position }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -292,8 +390,9 @@ type PrefixExpression struct {
 	Right    Expression
 }
 
-func (pe *PrefixExpression) expressionNode()      {}
-func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) expressionNode()          {}
+func (pe *PrefixExpression) TokenLiteral() string     { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.TokenPosition { return pe.Token.Position }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -315,8 +414,9 @@ type InfixExpression struct {
 	Right    Expression
 }
 
-func (ie *InfixExpression) expressionNode()      {}
-func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) expressionNode()          {}
+func (ie *InfixExpression) TokenLiteral() string     { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.TokenPosition { return ie.Token.Position }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -339,8 +439,9 @@ type IfExpression struct {
 	Alternative *BlockStatement
 }
 
-func (ie *IfExpression) expressionNode()      {}
-func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) expressionNode()          {}
+func (ie *IfExpression) TokenLiteral() string     { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.TokenPosition { return ie.Token.Position }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -367,16 +468,27 @@ type FunctionLiteral struct {
 	Body       *BlockStatement
 	Name       string
 	Define     bool
+	Generator  bool // set when the body contains a yield expression
+	Arrow      bool // written as `(x) => ...`
+
+	// Optional type annotations, written as `function(a: int): int`.
+	ParameterTypes map[string]*TypeAnnotation
+	ReturnType     *TypeAnnotation
 }
 
-func (fl *FunctionLiteral) expressionNode()      {}
-func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) expressionNode()          {}
+func (fl *FunctionLiteral) TokenLiteral() string     { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.TokenPosition { return fl.Token.Position }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
 	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+		if t, ok := fl.ParameterTypes[p.Value]; ok {
+			params = append(params, p.String()+": "+t.String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 
 	out.WriteString(fl.TokenLiteral())
@@ -386,12 +498,78 @@ func (fl *FunctionLiteral) String() string {
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(")")
+	if fl.ReturnType != nil {
+		out.WriteString(": " + fl.ReturnType.String())
+	}
 
 	out.WriteString(fl.Body.String())
 
 	return out.String()
 }
 
+/*
+** MacroLiteral
+ */
+type MacroLiteral struct {
+	Token      token.Token // The 'macro' token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ml *MacroLiteral) expressionNode()          {}
+func (ml *MacroLiteral) TokenLiteral() string     { return ml.Token.Literal }
+func (ml *MacroLiteral) Pos() token.TokenPosition { return ml.Token.Position }
+func (ml *MacroLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range ml.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+	out.WriteString(ml.Body.String())
+
+	return out.String()
+}
+
+/*
+** YieldExpression
+ */
+type YieldExpression struct {
+	Token token.Token // The 'yield' token
+	Value Expression
+}
+
+func (ye *YieldExpression) expressionNode()          {}
+func (ye *YieldExpression) TokenLiteral() string     { return ye.Token.Literal }
+func (ye *YieldExpression) Pos() token.TokenPosition { return ye.Token.Position }
+func (ye *YieldExpression) String() string {
+	if ye.Value == nil {
+		return ye.TokenLiteral()
+	}
+
+	return ye.TokenLiteral() + " " + ye.Value.String()
+}
+
+/*
+** SpawnExpression
+ */
+type SpawnExpression struct {
+	Token token.Token // The 'spawn' token
+	Call  *CallExpression
+}
+
+func (se *SpawnExpression) expressionNode()          {}
+func (se *SpawnExpression) TokenLiteral() string     { return se.Token.Literal }
+func (se *SpawnExpression) Pos() token.TokenPosition { return se.Token.Position }
+func (se *SpawnExpression) String() string {
+	return se.TokenLiteral() + " " + se.Call.String()
+}
+
 /*
 ** CallExpression
  */
@@ -399,10 +577,14 @@ type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression
 	Arguments []Expression
+	Pipeline  bool // written as `left |> f(args)`
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+
+// Pos returns the position of the called function rather than the parenthesis.
+func (ce *CallExpression) Pos() token.TokenPosition { return ce.Function.Pos() }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -429,8 +611,9 @@ type AssignStatement struct {
 	Value    Expression
 }
 
-func (as *AssignStatement) expressionNode()      {}
-func (as *AssignStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AssignStatement) expressionNode()          {}
+func (as *AssignStatement) TokenLiteral() string     { return as.Token.Literal }
+func (as *AssignStatement) Pos() token.TokenPosition { return as.Token.Position }
 func (as *AssignStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(as.Name.String())
@@ -448,8 +631,9 @@ type PostfixExpression struct {
 	Name     *Identifier
 }
 
-func (pe *PostfixExpression) expressionNode()      {}
-func (pe *PostfixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PostfixExpression) expressionNode()          {}
+func (pe *PostfixExpression) TokenLiteral() string     { return pe.Token.Literal }
+func (pe *PostfixExpression) Pos() token.TokenPosition { return pe.Token.Position }
 func (pe *PostfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString(pe.Name.String())
@@ -466,8 +650,9 @@ type WhileLoopExpression struct {
 	Consequence *BlockStatement
 }
 
-func (ie *WhileLoopExpression) expressionNode()      {}
-func (ie *WhileLoopExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *WhileLoopExpression) expressionNode()          {}
+func (ie *WhileLoopExpression) TokenLiteral() string     { return ie.Token.Literal }
+func (ie *WhileLoopExpression) Pos() token.TokenPosition { return ie.Token.Position }
 func (ie *WhileLoopExpression) String() string {
 	var out bytes.Buffer
 
@@ -479,3 +664,79 @@ func (ie *WhileLoopExpression) String() string {
 
 	return out.String()
 }
+
+/*
+** ArrayComprehension
+ */
+type ArrayComprehension struct {
+	Token     token.Token // The '[' token
+	Element   Expression
+	Variables []*Identifier
+	Iterable  Expression
+	Condition Expression
+}
+
+func (ac *ArrayComprehension) expressionNode()          {}
+func (ac *ArrayComprehension) TokenLiteral() string     { return ac.Token.Literal }
+func (ac *ArrayComprehension) Pos() token.TokenPosition { return ac.Token.Position }
+func (ac *ArrayComprehension) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("[")
+	out.WriteString(ac.Element.String())
+	out.WriteString(comprehensionClause(ac.Variables, ac.Iterable, ac.Condition))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+/*
+** HashComprehension
+ */
+type HashComprehension struct {
+	Token     token.Token // The '{' token
+	Key       Expression
+	Value     Expression
+	Variables []*Identifier
+	Iterable  Expression
+	Condition Expression
+}
+
+func (hc *HashComprehension) expressionNode()          {}
+func (hc *HashComprehension) TokenLiteral() string     { return hc.Token.Literal }
+func (hc *HashComprehension) Pos() token.TokenPosition { return hc.Token.Position }
+func (hc *HashComprehension) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("{")
+	out.WriteString(hc.Key.String())
+	out.WriteString(": ")
+	out.WriteString(hc.Value.String())
+	out.WriteString(comprehensionClause(hc.Variables, hc.Iterable, hc.Condition))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// comprehensionClause prints the `for ... in ... if ...` part shared by
+// array and hash comprehensions.
+func comprehensionClause(variables []*Identifier, iterable Expression, condition Expression) string {
+	var out bytes.Buffer
+
+	names := []string{}
+	for _, v := range variables {
+		names = append(names, v.String())
+	}
+
+	out.WriteString(" for ")
+	out.WriteString(strings.Join(names, ", "))
+	out.WriteString(" in ")
+	out.WriteString(iterable.String())
+
+	if condition != nil {
+		out.WriteString(" if ")
+		out.WriteString(condition.String())
+	}
+
+	return out.String()
+}
@@ -26,3 +26,104 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestModify(t *testing.T) {
+	one := func() Expression { return &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1} }
+	two := func() Expression { return &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "2"}, Value: 2} }
+
+	turnOneIntoTwo := func(node Node) Node {
+		integer, ok := node.(*IntegerLiteral)
+		if !ok {
+			return node
+		}
+
+		if integer.Value != 1 {
+			return node
+		}
+
+		return two()
+	}
+
+	tests := []struct {
+		input    Node
+		expected string
+	}{
+		{one(), "2"},
+		{&Program{Statements: []Statement{&ExpressionStatement{Expression: one()}}}, "2"},
+		{&InfixExpression{Left: one(), Operator: "+", Right: two()}, "(2 + 2)"},
+		{&PrefixExpression{Operator: "-", Right: one()}, "(-2)"},
+		{&IndexExpression{Left: one(), Index: one()}, "(2[2])"},
+		{
+			&IfExpression{
+				Condition:   one(),
+				Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			},
+			"if2 2else2",
+		},
+		{&ReturnStatement{Token: token.Token{Literal: "return"}, ReturnValue: one()}, "return 2;"},
+		{&ArrayLiteral{Elements: []Expression{one(), one()}}, "[2, 2]"},
+		{&HashLiteral{Pairs: []*HashLiteralPair{{Key: one(), Value: one()}}}, "{2:2}"},
+		{&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{one()}}, "f(2)"},
+		{
+			&ArrayComprehension{
+				Element:   one(),
+				Variables: []*Identifier{{Value: "x"}},
+				Iterable:  one(),
+				Condition: one(),
+			},
+			"[2 for x in 2 if 2]",
+		},
+	}
+
+	for _, tt := range tests {
+		modified := Modify(tt.input, turnOneIntoTwo)
+
+		if modified.String() != tt.expected {
+			t.Errorf("not modified as expected. want=%q, got=%q", tt.expected, modified.String())
+		}
+	}
+}
+
+func TestModifyVisitsBindings(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let"},
+				Name:  &Identifier{Value: "a"},
+				Value: &Identifier{Value: "a"},
+			},
+		},
+	}
+
+	Modify(program, func(node Node) Node {
+		if ident, ok := node.(*Identifier); ok {
+			ident.Value = "b"
+		}
+		return node
+	})
+
+	if program.String() != "let b = b;" {
+		t.Errorf("bindings not modified. got=%q", program.String())
+	}
+}
+
+func TestCopy(t *testing.T) {
+	original := &InfixExpression{
+		Left:     &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1},
+		Operator: "+",
+		Right:    &CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{&Identifier{Value: "x"}}},
+	}
+
+	copied := Copy(original).(*InfixExpression)
+	copied.Left.(*IntegerLiteral).Token.Literal = "5"
+	copied.Right.(*CallExpression).Arguments[0].(*Identifier).Value = "y"
+
+	if original.String() != "(1 + f(x))" {
+		t.Errorf("original was modified. got=%q", original.String())
+	}
+
+	if copied.String() != "(5 + f(y))" {
+		t.Errorf("copy not modified. got=%q", copied.String())
+	}
+}
@@ -0,0 +1,133 @@
+package ast
+
+// ModifierFunc is called for every node by Modify. The returned node replaces
+// the visited node in the tree.
+type ModifierFunc func(Node) Node
+
+// Modify walks the tree depth first and replaces every node with the result of
+// the modifier. Children are modified before their parents.
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch node := node.(type) {
+
+	case *Program:
+		for i, statement := range node.Statements {
+			node.Statements[i], _ = Modify(statement, modifier).(Statement)
+		}
+
+	case *BlockStatement:
+		for i, statement := range node.Statements {
+			node.Statements[i], _ = Modify(statement, modifier).(Statement)
+		}
+
+	case *ExpressionStatement:
+		node.Expression, _ = Modify(node.Expression, modifier).(Expression)
+
+	case *LetStatement:
+		node.Name, _ = Modify(node.Name, modifier).(*Identifier)
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
+
+	case *ConstStatement:
+		node.Name, _ = Modify(node.Name, modifier).(*Identifier)
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
+
+	case *ReturnStatement:
+		node.ReturnValue, _ = Modify(node.ReturnValue, modifier).(Expression)
+
+	case *AssignStatement:
+		node.Name, _ = Modify(node.Name, modifier).(*Identifier)
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
+
+	case *PostfixExpression:
+		node.Name, _ = Modify(node.Name, modifier).(*Identifier)
+
+	case *PrefixExpression:
+		node.Right, _ = Modify(node.Right, modifier).(Expression)
+
+	case *InfixExpression:
+		node.Left, _ = Modify(node.Left, modifier).(Expression)
+		node.Right, _ = Modify(node.Right, modifier).(Expression)
+
+	case *IndexExpression:
+		node.Left, _ = Modify(node.Left, modifier).(Expression)
+		node.Index, _ = Modify(node.Index, modifier).(Expression)
+
+	case *IfExpression:
+		node.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		node.Consequence, _ = Modify(node.Consequence, modifier).(*BlockStatement)
+		if node.Alternative != nil {
+			node.Alternative, _ = Modify(node.Alternative, modifier).(*BlockStatement)
+		}
+
+	case *WhileLoopExpression:
+		node.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		node.Consequence, _ = Modify(node.Consequence, modifier).(*BlockStatement)
+
+	case *FunctionLiteral:
+		for i := range node.Parameters {
+			node.Parameters[i], _ = Modify(node.Parameters[i], modifier).(*Identifier)
+		}
+		for name, value := range node.Defaults {
+			node.Defaults[name], _ = Modify(value, modifier).(Expression)
+		}
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+
+	case *MacroLiteral:
+		for i := range node.Parameters {
+			node.Parameters[i], _ = Modify(node.Parameters[i], modifier).(*Identifier)
+		}
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+
+	case *CallExpression:
+		node.Function, _ = Modify(node.Function, modifier).(Expression)
+		for i, argument := range node.Arguments {
+			node.Arguments[i], _ = Modify(argument, modifier).(Expression)
+		}
+
+	case *SpawnExpression:
+		node.Call, _ = Modify(node.Call, modifier).(*CallExpression)
+
+	case *YieldExpression:
+		if node.Value != nil {
+			node.Value, _ = Modify(node.Value, modifier).(Expression)
+		}
+
+	case *ArrayLiteral:
+		for i, element := range node.Elements {
+			node.Elements[i], _ = Modify(element, modifier).(Expression)
+		}
+
+	case *SetLiteral:
+		for i, element := range node.Elements {
+			node.Elements[i], _ = Modify(element, modifier).(Expression)
+		}
+
+	case *HashLiteral:
+		for _, pair := range node.Pairs {
+			pair.Key, _ = Modify(pair.Key, modifier).(Expression)
+			pair.Value, _ = Modify(pair.Value, modifier).(Expression)
+		}
+
+	case *ArrayComprehension:
+		for i := range node.Variables {
+			node.Variables[i], _ = Modify(node.Variables[i], modifier).(*Identifier)
+		}
+		node.Iterable, _ = Modify(node.Iterable, modifier).(Expression)
+		if node.Condition != nil {
+			node.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		}
+		node.Element, _ = Modify(node.Element, modifier).(Expression)
+
+	case *HashComprehension:
+		for i := range node.Variables {
+			node.Variables[i], _ = Modify(node.Variables[i], modifier).(*Identifier)
+		}
+		node.Iterable, _ = Modify(node.Iterable, modifier).(Expression)
+		if node.Condition != nil {
+			node.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		}
+		node.Key, _ = Modify(node.Key, modifier).(Expression)
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
+	}
+
+	return modifier(node)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around a change.
+const context = 3
+
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	text string
+
+	// The lines of the old and new text before this edit.
+	a, b int
+}
+
+// diff returns the changes from a to b in the unified format.
+func diff(name string, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	edits := lineEdits(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+
+	for start := 0; start < len(edits); {
+		first := start
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+
+		// Changes that are close together share a hunk.
+		last := first
+		for k := first; k < len(edits) && k-last <= 2*context; k++ {
+			if edits[k].op != ' ' {
+				last = k
+			}
+		}
+
+		from := first - context
+		if from < start {
+			from = start
+		}
+		to := last + context + 1
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		hunk := edits[from:to]
+		end := edits[to-1]
+		aLines, bLines := end.a-hunk[0].a, end.b-hunk[0].b
+		if end.op != '+' {
+			aLines++
+		}
+		if end.op != '-' {
+			bLines++
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunk[0].a+1, aLines, hunk[0].b+1, bLines)
+		for _, e := range hunk {
+			fmt.Fprintf(&out, "%c%s\n", e.op, e.text)
+		}
+
+		start = to
+	}
+
+	return out.String()
+}
+
+// lineEdits returns the edits that turn x into y, based on their longest
+// common subsequence.
+func lineEdits(x, y []string) []edit {
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{op: ' ', text: x[i], a: i, b: j})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{op: '-', text: x[i], a: i, b: j})
+			i++
+		default:
+			edits = append(edits, edit{op: '+', text: y[j], a: i, b: j})
+			j++
+		}
+	}
+
+	return edits
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
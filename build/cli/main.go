@@ -12,16 +12,25 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/rhwilr/lemur/build"
+	"github.com/rhwilr/lemur/checker"
 	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/dap"
+	"github.com/rhwilr/lemur/debugger"
+	"github.com/rhwilr/lemur/diagnostics"
 	"github.com/rhwilr/lemur/evaluator"
+	"github.com/rhwilr/lemur/eventloop"
+	"github.com/rhwilr/lemur/formatter"
 	"github.com/rhwilr/lemur/lexer"
 	"github.com/rhwilr/lemur/optimizer"
 	"github.com/rhwilr/lemur/object"
 	"github.com/rhwilr/lemur/parser"
 	"github.com/rhwilr/lemur/repl"
+	"github.com/rhwilr/lemur/token"
+	"github.com/rhwilr/lemur/types"
 	"github.com/rhwilr/lemur/vm"
 )
 
@@ -33,11 +42,17 @@ var (
 	compile     bool
 	execute     bool
 	version     bool
+	format      string
+	typecheck   bool
 )
 
 func init() {
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [<filename>]\n", path.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s check [-format=json] <filename>...\n", path.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s fmt [-w] [-d] <filename>...\n", path.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s debug <filename>\n", path.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s dap\n", path.Base(os.Args[0]))
 		flag.PrintDefaults()
 		os.Exit(0)
 	}
@@ -50,6 +65,8 @@ func init() {
 	flag.BoolVar(&execute, "b", false, "execute a compiled file using the lemur-vm")
 	flag.StringVar(&engine, "e", "vm", "engine to use (eval or vm), only supported with scripts")
 	flag.StringVar(&output, "o", "a.out", "name of the output file")
+	flag.StringVar(&format, "format", "text", "format of error diagnostics (text or json)")
+	flag.BoolVar(&typecheck, "t", false, "check type annotations before running a script")
 }
 
 func main() {
@@ -71,6 +88,26 @@ func main() {
 		os.Exit(0)
 	}
 
+	if len(args) > 0 && args[0] == "check" {
+		runChecker(args[1:])
+		os.Exit(0)
+	}
+
+	if len(args) > 0 && args[0] == "fmt" {
+		runFormatter(args[1:])
+		os.Exit(0)
+	}
+
+	if len(args) > 0 && args[0] == "debug" {
+		runDebugger(args[1:])
+		os.Exit(0)
+	}
+
+	if len(args) > 0 && args[0] == "dap" {
+		runDebugAdapter()
+		os.Exit(0)
+	}
+
 	if interactive || len(args) == 0 {
 		runRepl()
 		os.Exit(0)
@@ -114,11 +151,22 @@ func runEvaluator() {
 	var duration time.Duration
 	var result object.Object
 
-	l := lexer.New(string(input))
+	l := lexer.NewWithFile(string(input), args[0])
 	p := parser.New(l)
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		log.Fatalf("parse error: %s", p.Errors())
+		reportSyntaxErrors(string(input), p.Diagnostics())
+	}
+
+	program, err = evaluator.ExpandProgram(program, object.NewEnvironment())
+	if err != nil {
+		log.Fatalf("macro error: %s", err)
+	}
+
+	if typecheck {
+		if found := types.Check(program); len(found) > 0 {
+			reportDiagnostics(string(input), "type", found)
+		}
 	}
 
 	if engine == "vm" {
@@ -127,7 +175,7 @@ func runEvaluator() {
 		comp := compiler.New()
 		err := comp.Compile(program)
 		if err != nil {
-			log.Fatalf("compiler error: %s", err)
+			reportCompileError(string(input), err)
 		}
 
 		machine := vm.New(comp.Bytecode())
@@ -135,7 +183,7 @@ func runEvaluator() {
 
 		err = machine.Run()
 		if err != nil {
-			log.Fatalf("vm error: %s", err)
+			reportRuntimeError(err)
 		}
 
 		duration = time.Since(start)
@@ -144,9 +192,14 @@ func runEvaluator() {
 		os.Setenv("LEMUR_RUNTIME", "EVAL")
 
 		env := object.NewEnvironment()
+		env.SetEventLoop(eventloop.New(nil))
 		start := time.Now()
 		result = evaluator.Eval(program, env)
 		duration = time.Since(start)
+
+		if err, ok := result.(*object.Error); ok && err.Raised {
+			reportError(string(input), "runtime error", err.Position, err.Message)
+		}
 	}
 
 	fmt.Printf(
@@ -176,12 +229,17 @@ func runAssembler() {
 		log.Fatal(err)
 	}
 
-	l := lexer.New(string(b))
+	l := lexer.NewWithFile(string(b), args[0])
 	p := parser.New(l)
 
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		log.Fatalf("parse error: %s", p.Errors())
+		reportSyntaxErrors(string(b), p.Diagnostics())
+	}
+
+	program, err = evaluator.ExpandProgram(program, object.NewEnvironment())
+	if err != nil {
+		log.Fatalf("macro error: %s", err)
 	}
 
 	optimized, err := optimizer.New(program).Optimize()
@@ -193,7 +251,7 @@ func runAssembler() {
 	c := compiler.New()
 	err = c.Compile(optimized)
 	if err != nil {
-		log.Fatalf("compiler error: %s", err)
+		reportCompileError(string(b), err)
 	}
 
 	assembly := c.Bytecode().Instructions.String()
@@ -230,12 +288,17 @@ func runCompiler() {
 		log.Fatal(err)
 	}
 
-	l := lexer.New(string(b))
+	l := lexer.NewWithFile(string(b), args[0])
 	p := parser.New(l)
 
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		log.Fatalf("parse error: %s", p.Errors())
+		reportSyntaxErrors(string(b), p.Diagnostics())
+	}
+
+	program, err = evaluator.ExpandProgram(program, object.NewEnvironment())
+	if err != nil {
+		log.Fatalf("macro error: %s", err)
 	}
 
 	optimized, err := optimizer.New(program).Optimize()
@@ -247,7 +310,7 @@ func runCompiler() {
 	c := compiler.New()
 	err = c.Compile(optimized)
 	if err != nil {
-		log.Fatalf("compiler error: %s", err)
+		reportCompileError(string(b), err)
 	}
 
 	code := c.Bytecode()
@@ -290,6 +353,238 @@ func runVM() {
 
 	err = machine.Run()
 	if err != nil {
-		log.Fatalf("vm error: %s\n", err)
+		reportRuntimeError(err)
+	}
+}
+
+func runDebugger(args []string) {
+	os.Setenv("LEMUR_RUNTIME", "VM")
+
+	if len(args) < 1 {
+		log.Fatal("no source file given to debug")
+	}
+
+	input, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	l := lexer.NewWithFile(string(input), args[0])
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		reportSyntaxErrors(string(input), p.Diagnostics())
+	}
+
+	program, err = evaluator.ExpandProgram(program, object.NewEnvironment())
+	if err != nil {
+		log.Fatalf("macro error: %s", err)
+	}
+
+	comp := compiler.New()
+	err = comp.Compile(program)
+	if err != nil {
+		reportCompileError(string(input), err)
+	}
+
+	bytecode := comp.Bytecode()
+	machine := vm.New(bytecode)
+	d := debugger.New(machine, bytecode, debugger.Console(os.Stdin, os.Stdout, string(input)))
+
+	err = d.Run(true)
+	if err != nil {
+		reportRuntimeError(err)
+	}
+}
+
+// runDebugAdapter serves the Debug Adapter Protocol over stdin and stdout.
+func runDebugAdapter() {
+	os.Setenv("LEMUR_RUNTIME", "VM")
+
+	// stdout belongs to the protocol, log messages go to stderr.
+	log.SetOutput(os.Stderr)
+
+	if err := dap.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runChecker(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.StringVar(&format, "format", format, "format of error diagnostics (text or json)")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		log.Fatal("no source file given to check")
+	}
+
+	failed := false
+	for _, file := range flags.Args() {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		p := parser.New(lexer.NewWithFile(string(input), file))
+		program := p.ParseProgram()
+
+		found := p.Diagnostics()
+		if !diagnostics.HasErrors(found) {
+			found = append(found, checker.Check(program)...)
+			found = append(found, types.Check(program)...)
+			diagnostics.Sort(found)
+		}
+
+		if diagnostics.HasErrors(found) {
+			failed = true
+		}
+
+		if format == "json" {
+			diagnostics.WriteJSON(os.Stdout, found)
+			continue
+		}
+
+		for _, d := range found {
+			printError(string(input), d.Severity.String(), d.Pos(), fmt.Sprintf("%s [%s]", d.Message, d.Code))
+			if d.Hint != "" {
+				fmt.Fprintf(os.Stderr, "    hint: %s\n", d.Hint)
+			}
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func runFormatter(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the source file instead of stdout")
+	showDiff := flags.Bool("d", false, "display a diff instead of the formatted source")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		log.Fatal("no source file given to format")
+	}
+
+	failed := false
+	for _, file := range flags.Args() {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		formatted, found := formatter.Source(string(input), file)
+		if found != nil {
+			failed = true
+			for _, d := range found {
+				printError(string(input), "parse "+d.Severity.String(), d.Pos(), d.Message)
+			}
+			continue
+		}
+
+		if *showDiff {
+			fmt.Print(diff(file, string(input), formatted))
+		}
+
+		if *write && formatted != string(input) {
+			if err := ioutil.WriteFile(file, []byte(formatted), 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if !*showDiff && !*write {
+			fmt.Print(formatted)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+/*
+** Error reporting
+ */
+func reportSyntaxErrors(source string, found []diagnostics.Diagnostic) {
+	reportDiagnostics(source, "parse", found)
+}
+
+// reportDiagnostics prints the diagnostics found in a phase and exits.
+func reportDiagnostics(source string, phase string, found []diagnostics.Diagnostic) {
+	if format == "json" {
+		diagnostics.WriteJSON(os.Stdout, found)
+		os.Exit(1)
+	}
+
+	for _, d := range found {
+		printError(source, phase+" "+d.Severity.String(), d.Pos(), d.Message)
+		if d.Hint != "" {
+			fmt.Fprintf(os.Stderr, "    hint: %s\n", d.Hint)
+		}
+	}
+
+	os.Exit(1)
+}
+
+func reportCompileError(source string, err error) {
+	compileErr, ok := err.(*compiler.Error)
+	if !ok {
+		compileErr = &compiler.Error{Code: diagnostics.CompileError, Message: err.Error()}
+	}
+
+	if format == "json" {
+		diagnostics.WriteJSON(os.Stdout, []diagnostics.Diagnostic{compileErr.Diagnostic()})
+		os.Exit(1)
+	}
+
+	if !ok {
+		log.Fatalf("compiler error: %s", err)
+	}
+
+	printError(source, "compiler error", compileErr.Position, compileErr.Message)
+	if hint := compileErr.Diagnostic().Hint; hint != "" {
+		fmt.Fprintf(os.Stderr, "    hint: %s\n", hint)
+	}
+
+	os.Exit(1)
+}
+
+func reportRuntimeError(err error) {
+	fmt.Fprintf(os.Stderr, "vm error: %s\n", err)
+	if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+		fmt.Fprintln(os.Stderr, runtimeErr.StackTrace())
+	}
+
+	os.Exit(1)
+}
+
+func reportError(source string, kind string, position token.TokenPosition, message string) {
+	printError(source, kind, position, message)
+	os.Exit(1)
+}
+
+// printError prints the message followed by the offending source line and a
+// caret pointing at the column.
+func printError(source string, kind string, position token.TokenPosition, message string) {
+	fmt.Fprintf(os.Stderr, "%s: %s: %s\n", kind, position, message)
+
+	lines := strings.Split(source, "\n")
+	if position.Line < 1 || position.Line > len(lines) {
+		return
+	}
+
+	line := []rune(strings.TrimRight(lines[position.Line-1], "\r"))
+	fmt.Fprintf(os.Stderr, "    %s\n", string(line))
+
+	// Keep tabs, so the caret lines up with the source line.
+	indent := []rune{}
+	for i := 0; i < position.Column-1 && i < len(line); i++ {
+		if line[i] == '\t' {
+			indent = append(indent, '\t')
+		} else {
+			indent = append(indent, ' ')
+		}
 	}
+	fmt.Fprintf(os.Stderr, "    %s^\n", string(indent))
 }
@@ -11,7 +11,10 @@ import (
 
 	"github.com/rhwilr/lemur/build"
 	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/diagnostics"
+	"github.com/rhwilr/lemur/evaluator"
 	"github.com/rhwilr/lemur/lexer"
+	"github.com/rhwilr/lemur/object"
 	"github.com/rhwilr/lemur/optimizer"
 	"github.com/rhwilr/lemur/parser"
 )
@@ -19,6 +22,7 @@ import (
 var (
 	output  string
 	version bool
+	format  string
 )
 
 func init() {
@@ -30,6 +34,7 @@ func init() {
 
 	flag.BoolVar(&version, "v", false, "display version information")
 	flag.StringVar(&output, "o", "a.out", "name of the output file")
+	flag.StringVar(&format, "format", "text", "format of error diagnostics (text or json)")
 }
 
 func main() {
@@ -64,12 +69,17 @@ func runCompiler() {
 		log.Fatal(err)
 	}
 
-	l := lexer.New(string(b))
+	l := lexer.NewWithFile(string(b), args[0])
 	p := parser.New(l)
 
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		log.Fatal(p.Errors())
+		reportDiagnostics(p.Diagnostics())
+	}
+
+	program, err = evaluator.ExpandProgram(program, object.NewEnvironment())
+	if err != nil {
+		log.Fatalf("macro error: %s", err)
 	}
 
 	optimized, err := optimizer.New(program).Optimize()
@@ -81,7 +91,11 @@ func runCompiler() {
 	c := compiler.New()
 	err = c.Compile(optimized)
 	if err != nil {
-		log.Fatal(err)
+		compileErr, ok := err.(*compiler.Error)
+		if !ok {
+			log.Fatal(err)
+		}
+		reportDiagnostics([]diagnostics.Diagnostic{compileErr.Diagnostic()})
 	}
 
 	code := c.Bytecode()
@@ -98,3 +112,15 @@ func runCompiler() {
 	writer, _ := f2.Write(bytecode)
 	fmt.Printf("wrote %d bytes in %s\n", writer, duration)
 }
+
+func reportDiagnostics(found []diagnostics.Diagnostic) {
+	if format == "json" {
+		diagnostics.WriteJSON(os.Stdout, found)
+	} else {
+		for _, d := range found {
+			fmt.Fprintln(os.Stderr, d)
+		}
+	}
+
+	os.Exit(1)
+}
@@ -0,0 +1,37 @@
+package main
+
+// Package main starts the Lemur language server. Editors run it and talk to
+// it using the Language Server Protocol over stdin and stdout.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/rhwilr/lemur/build"
+	"github.com/rhwilr/lemur/lsp"
+)
+
+var version bool
+
+func init() {
+	flag.BoolVar(&version, "v", false, "display version information")
+}
+
+func main() {
+	flag.Parse()
+
+	if version {
+		fmt.Printf("%s %s\n", path.Base(os.Args[0]), build.FullVersion())
+		os.Exit(0)
+	}
+
+	// stdout belongs to the protocol, log messages go to stderr.
+	log.SetOutput(os.Stderr)
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -5,7 +5,7 @@ import (
 )
 
 var (
-	BinaryVersion byte = 1
+	BinaryVersion byte = 6
 
 	// GitCommit will be overwritten automatically by the build system
 	GitCommit = "HEAD"
@@ -69,6 +69,9 @@ func runVM() {
 	err = machine.Run()
 	if err != nil {
 		fmt.Printf("vm error: %s\n", err)
+		if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+			fmt.Println(runtimeErr.StackTrace())
+		}
 		return
 	}
 }
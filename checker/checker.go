@@ -0,0 +1,446 @@
+// Package checker finds problems in a program without running it. Names are
+// resolved with the symbol table of the compiler, so the checker accepts the
+// same programs as the compiler does.
+package checker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/diagnostics"
+	"github.com/rhwilr/lemur/object"
+)
+
+type declarationKind int
+
+const (
+	variableDeclaration declarationKind = iota
+	constantDeclaration
+	parameterDeclaration
+	functionDeclaration
+)
+
+// declaration is a name introduced by a let, const, parameter or function
+// definition.
+type declaration struct {
+	name       *ast.Identifier
+	kind       declarationKind
+	used       bool
+	reassigned bool
+
+	// The function the name is bound to, if it is known.
+	function *ast.FunctionLiteral
+}
+
+type scope struct {
+	symbols      *compiler.SymbolTable
+	declarations map[string]*declaration
+	outer        *scope
+}
+
+type call struct {
+	node        *ast.CallExpression
+	declaration *declaration
+}
+
+type Checker struct {
+	scope       *scope
+	calls       []call
+	diagnostics []diagnostics.Diagnostic
+}
+
+// Check returns the problems found in the program ordered by position.
+func Check(program *ast.Program) []diagnostics.Diagnostic {
+	c := &Checker{
+		scope: &scope{
+			symbols:      compiler.NewBuiltinSymbolTable(),
+			declarations: map[string]*declaration{},
+		},
+	}
+
+	c.checkStatements(program.Statements)
+	c.reportUnused(c.scope)
+	c.checkCalls()
+
+	diagnostics.Sort(c.diagnostics)
+	return c.diagnostics
+}
+
+func (c *Checker) checkStatements(statements []ast.Statement) {
+	returned := false
+
+	for _, stmt := range statements {
+		if returned {
+			c.report(stmt, diagnostics.UnreachableCode, diagnostics.Warning, "remove the code after the return", "unreachable code")
+			returned = false
+		}
+
+		c.checkStatement(stmt)
+
+		if _, ok := stmt.(*ast.ReturnStatement); ok {
+			returned = true
+		}
+	}
+}
+
+func (c *Checker) checkStatement(node ast.Statement) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		d := c.define(node.Name, variableDeclaration)
+		c.checkExpression(node.Value)
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok && d != nil {
+			d.function = fn
+		}
+
+	case *ast.ConstStatement:
+		d := c.define(node.Name, constantDeclaration)
+		c.checkExpression(node.Value)
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok && d != nil {
+			d.function = fn
+		}
+
+	case *ast.ReturnStatement:
+		c.checkExpression(node.ReturnValue)
+
+	case *ast.ExpressionStatement:
+		c.checkExpression(node.Expression)
+
+	case *ast.BlockStatement:
+		if node != nil {
+			c.checkStatements(node.Statements)
+		}
+	}
+}
+
+func (c *Checker) checkExpression(node ast.Expression) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		c.resolve(node)
+
+	case *ast.PrefixExpression:
+		c.checkExpression(node.Right)
+
+	case *ast.InfixExpression:
+		c.checkExpression(node.Left)
+		c.checkExpression(node.Right)
+
+	case *ast.IfExpression:
+		c.checkExpression(node.Condition)
+		c.checkStatement(node.Consequence)
+		if node.Alternative != nil {
+			c.checkStatement(node.Alternative)
+		}
+
+	case *ast.WhileLoopExpression:
+		c.checkExpression(node.Condition)
+		c.checkStatement(node.Consequence)
+
+	case *ast.AssignStatement:
+		c.checkAssignment(node.Name)
+		c.checkExpression(node.Value)
+
+	case *ast.PostfixExpression:
+		c.checkAssignment(node.Name)
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			c.checkExpression(el)
+		}
+
+	case *ast.SetLiteral:
+		for _, el := range node.Elements {
+			c.checkExpression(el)
+		}
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			c.checkExpression(pair.Key)
+			c.checkExpression(pair.Value)
+		}
+
+	case *ast.IndexExpression:
+		c.checkExpression(node.Left)
+		c.checkExpression(node.Index)
+
+	case *ast.ArrayComprehension:
+		c.enterScope()
+		c.checkExpression(node.Iterable)
+		for _, v := range node.Variables {
+			c.define(v, variableDeclaration)
+		}
+		c.checkExpression(node.Condition)
+		c.checkExpression(node.Element)
+		c.leaveScope()
+
+	case *ast.HashComprehension:
+		c.enterScope()
+		c.checkExpression(node.Iterable)
+		for _, v := range node.Variables {
+			c.define(v, variableDeclaration)
+		}
+		c.checkExpression(node.Condition)
+		c.checkExpression(node.Key)
+		c.checkExpression(node.Value)
+		c.leaveScope()
+
+	case *ast.FunctionLiteral:
+		c.checkFunction(node)
+
+	case *ast.YieldExpression:
+		c.checkExpression(node.Value)
+
+	case *ast.SpawnExpression:
+		if node.Call != nil {
+			c.checkExpression(node.Call)
+		}
+
+	case *ast.CallExpression:
+		c.checkExpression(node.Function)
+		if ident, ok := node.Function.(*ast.Identifier); ok {
+			if d := c.lookup(ident.Value); d != nil {
+				c.calls = append(c.calls, call{node: node, declaration: d})
+			}
+		}
+
+		for _, arg := range node.Arguments {
+			c.checkExpression(arg)
+		}
+	}
+}
+
+func (c *Checker) checkFunction(node *ast.FunctionLiteral) {
+	c.enterScope()
+
+	if node.Name != "" {
+		c.scope.symbols.DefineFunctionName(node.Name)
+	}
+
+	for _, p := range node.Parameters {
+		c.define(p, parameterDeclaration)
+		if value, ok := node.Defaults[p.Value]; ok {
+			c.checkExpression(value)
+		}
+	}
+
+	c.checkStatement(node.Body)
+	c.leaveScope()
+
+	if node.Define {
+		name := &ast.Identifier{Token: node.Token, Value: node.Name}
+		if d := c.define(name, functionDeclaration); d != nil {
+			d.function = node
+		}
+	}
+}
+
+// checkAssignment checks the target of an assignment. Writing to a variable
+// does not count as using it.
+func (c *Checker) checkAssignment(name *ast.Identifier) {
+	if name == nil {
+		return
+	}
+
+	if _, ok := c.scope.symbols.Resolve(name.Value); !ok {
+		c.reportUndefined(name)
+		return
+	}
+
+	d := c.lookup(name.Value)
+	if d == nil {
+		return
+	}
+
+	d.reassigned = true
+	if d.kind == constantDeclaration {
+		c.report(name, diagnostics.ConstantAssignment, diagnostics.Error, "declare the variable with let to allow reassignment", "assignment to constant variable: %s", name.Value)
+	}
+}
+
+// checkCalls compares the number of arguments of each call with the
+// parameters of the function, if the function is known and never reassigned.
+func (c *Checker) checkCalls() {
+	for _, call := range c.calls {
+		fn := call.declaration.function
+		if fn == nil || call.declaration.reassigned {
+			continue
+		}
+
+		max := len(fn.Parameters)
+		min := max - len(fn.Defaults)
+		got := len(call.node.Arguments)
+
+		if got >= min && got <= max {
+			continue
+		}
+
+		want := fmt.Sprintf("%d", max)
+		if min != max {
+			want = fmt.Sprintf("%d-%d", min, max)
+		}
+
+		c.report(call.node, diagnostics.WrongArity, diagnostics.Error, "", "wrong number of arguments for %s: want=%s, got=%d", call.declaration.name.Value, want, got)
+	}
+}
+
+/*
+** Scopes
+ */
+func (c *Checker) enterScope() {
+	c.scope = &scope{
+		symbols:      compiler.NewEnclosedSymbolTable(c.scope.symbols),
+		declarations: map[string]*declaration{},
+		outer:        c.scope,
+	}
+}
+
+func (c *Checker) leaveScope() {
+	c.reportUnused(c.scope)
+	c.scope = c.scope.outer
+}
+
+func (c *Checker) define(name *ast.Identifier, kind declarationKind) *declaration {
+	symbolType := compiler.VariableType
+	if kind == constantDeclaration {
+		symbolType = compiler.ConstantType
+	}
+
+	if _, err := c.scope.symbols.Define(name.Value, symbolType); err != nil {
+		c.report(name, diagnostics.Redeclaration, diagnostics.Error, "choose a different name or assign without let", "%s", err)
+		return nil
+	}
+
+	if object.GetBuiltinByName(name.Value) != nil {
+		c.report(name, diagnostics.ShadowedBuiltin, diagnostics.Warning, "rename it to keep the builtin accessible", "%s shadows the builtin function %s", name.Value, name.Value)
+	}
+
+	d := &declaration{name: name, kind: kind}
+	c.scope.declarations[name.Value] = d
+
+	return d
+}
+
+// resolve marks the declaration of the identifier as used.
+func (c *Checker) resolve(ident *ast.Identifier) {
+	if _, ok := c.scope.symbols.Resolve(ident.Value); !ok {
+		c.reportUndefined(ident)
+		return
+	}
+
+	if d := c.lookup(ident.Value); d != nil {
+		d.used = true
+	}
+}
+
+// lookup returns the declaration of name, or nil for builtins and undefined
+// names.
+func (c *Checker) lookup(name string) *declaration {
+	for s := c.scope; s != nil; s = s.outer {
+		if d, ok := s.declarations[name]; ok {
+			return d
+		}
+	}
+
+	return nil
+}
+
+/*
+** Reporting
+ */
+func (c *Checker) report(node ast.Node, code diagnostics.Code, severity diagnostics.Severity, hint string, format string, a ...interface{}) {
+	span := diagnostics.Span{Start: node.Pos(), End: node.Pos()}
+	if ident, ok := node.(*ast.Identifier); ok {
+		span = diagnostics.TokenSpan(ident.Token)
+	}
+
+	c.diagnostics = append(c.diagnostics, diagnostics.Diagnostic{
+		Code:     code,
+		Severity: severity,
+		Span:     span,
+		Message:  fmt.Sprintf(format, a...),
+		Hint:     hint,
+	})
+}
+
+func (c *Checker) reportUndefined(ident *ast.Identifier) {
+	hint := "declare the variable with let before using it"
+	if similar := c.similarName(ident.Value); similar != "" {
+		hint = fmt.Sprintf("did you mean %s?", similar)
+	}
+
+	c.report(ident, diagnostics.UndefinedVariable, diagnostics.Error, hint, "identifier not found: %s", ident.Value)
+}
+
+func (c *Checker) reportUnused(s *scope) {
+	for name, d := range s.declarations {
+		if d.used || strings.HasPrefix(name, "_") {
+			continue
+		}
+
+		switch d.kind {
+		case variableDeclaration:
+			c.report(d.name, diagnostics.UnusedVariable, diagnostics.Warning, "remove it or prefix the name with '_'", "%s is declared but never used", name)
+		case parameterDeclaration:
+			c.report(d.name, diagnostics.UnusedParameter, diagnostics.Warning, "remove it or prefix the name with '_'", "parameter %s is never used", name)
+		}
+	}
+}
+
+// similarName returns a visible name that differs from name by at most two
+// edits, to suggest it for a typo.
+func (c *Checker) similarName(name string) string {
+	best, bestDistance := "", 3
+
+	consider := func(candidate string) {
+		distance := editDistance(name, candidate)
+		if distance < bestDistance || distance == bestDistance && candidate < best {
+			best, bestDistance = candidate, distance
+		}
+	}
+
+	for s := c.scope; s != nil; s = s.outer {
+		for candidate := range s.declarations {
+			consider(candidate)
+		}
+	}
+	for _, b := range object.Builtins {
+		consider(b.Name)
+	}
+
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current := make([]int, len(rb)+1)
+		current[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+
+		previous = current
+	}
+
+	return previous[len(rb)]
+}
@@ -0,0 +1,120 @@
+package checker
+
+import (
+	"testing"
+
+	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/diagnostics"
+	"github.com/rhwilr/lemur/lexer"
+	"github.com/rhwilr/lemur/parser"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			`let a = 1; println(a);`,
+			[]string{},
+		},
+		{
+			`println(countr);`,
+			[]string{"1:9: error[C001]: identifier not found: countr"},
+		},
+		{
+			`let a = 1; let b = 2; println(a);`,
+			[]string{"1:16: warning[K001]: b is declared but never used"},
+		},
+		{
+			`let _b = 2;`,
+			[]string{},
+		},
+		{
+			`let f = function(a, b) { a }; f(1, 2);`,
+			[]string{"1:21: warning[K002]: parameter b is never used"},
+		},
+		{
+			`let len = 1; println(len);`,
+			[]string{"1:5: warning[K003]: len shadows the builtin function len"},
+		},
+		{
+			`const a = 1; a = 2; a++;`,
+			[]string{
+				"1:14: error[C002]: assignment to constant variable: a",
+				"1:21: error[C002]: assignment to constant variable: a",
+			},
+		},
+		{
+			`let f = function() { return 1; println(2); println(3); }; f();`,
+			[]string{"1:32: warning[K004]: unreachable code"},
+		},
+		{
+			`let f = function(a, b = 1) { a + b }; f(); f(1); f(1, 2, 3);`,
+			[]string{
+				"1:39: error[K005]: wrong number of arguments for f: want=1-2, got=0",
+				"1:50: error[K005]: wrong number of arguments for f: want=1-2, got=3",
+			},
+		},
+		{
+			`let f = function(a) { a }; f = function(a, b) { a + b }; f(1, 2);`,
+			[]string{},
+		},
+		{
+			`function f(n) { if (n > 0) { f(n - 1) } }; f(1, 2);`,
+			[]string{"1:44: error[K005]: wrong number of arguments for f: want=1, got=2"},
+		},
+		{
+			`let xs = [x * 2 for x in 1..3]; println(xs);`,
+			[]string{},
+		},
+		{
+			`let f = function() { g() }; let g = function() { 1 }; f(); g();`,
+			[]string{"1:22: error[C001]: identifier not found: g"},
+		},
+		{
+			`let a = 1; let a = 2; println(a);`,
+			[]string{"1:16: error[C003]: identifier 'a' has already been declared"},
+		},
+	}
+
+	for _, tt := range tests {
+		found := Check(parse(t, tt.input))
+
+		if len(found) != len(tt.expected) {
+			t.Errorf("input %q: wrong number of diagnostics. want=%d, got=%d: %v", tt.input, len(tt.expected), len(found), found)
+			continue
+		}
+
+		for i, expected := range tt.expected {
+			if found[i].String() != expected {
+				t.Errorf("input %q: wrong diagnostic. want=%q, got=%q", tt.input, expected, found[i].String())
+			}
+		}
+	}
+}
+
+func TestUndefinedHint(t *testing.T) {
+	found := Check(parse(t, `let counter = 0; println(counter, countr);`))
+
+	if len(found) != 1 || found[0].Code != diagnostics.UndefinedVariable {
+		t.Fatalf("expected an undefined variable, got=%v", found)
+	}
+
+	if found[0].Hint != "did you mean counter?" {
+		t.Errorf("wrong hint. got=%q", found[0].Hint)
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	parsed := p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) > 0 {
+		t.Fatalf("parse error: %s", errors)
+	}
+
+	return parsed
+}
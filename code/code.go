@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 type Instructions []byte
@@ -49,6 +50,14 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpRange
+	OpIterator
+	OpIterNext
+	OpAppend
+	OpSetIndex
+	OpYield
+	OpSpawn
+	OpSet
 )
 
 // The NOP opcode will consume 1 cpu cycle, but do nothing
@@ -90,6 +99,14 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:        {"OpClosure", []int{2, 1}},
 	OpGetFree:        {"OpGetFree", []int{1}},
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpRange:          {"OpRange", []int{1}},
+	OpIterator:       {"OpIterator", []int{}},
+	OpIterNext:       {"OpIterNext", []int{2, 1}},
+	OpAppend:         {"OpAppend", []int{}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
+	OpYield:          {"OpYield", []int{}},
+	OpSpawn:          {"OpSpawn", []int{1}},
+	OpSet:            {"OpSet", []int{2}},
 	OpNop:            {"OpNop", []int{}},
 }
 
@@ -192,3 +209,24 @@ func (ins Instructions) fmtInstruction(opcode byte, def *Definition, operands []
 
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
 }
+
+// LineEntry maps the instructions starting at Offset to a source position.
+type LineEntry struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// LineTable maps instruction offsets to source positions. The entries are
+// sorted by offset.
+type LineTable []LineEntry
+
+// Lookup returns the entry of the instruction at the given offset.
+func (t LineTable) Lookup(offset int) (LineEntry, bool) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return LineEntry{}, false
+	}
+
+	return t[i-1], true
+}
@@ -12,6 +12,7 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpIterNext, []int{65534, 2}, []byte{byte(OpIterNext), 255, 254, 2}},
 	}
 
 	for _, tt := range tests {
@@ -85,3 +86,37 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestLineTableLookup(t *testing.T) {
+	lines := LineTable{
+		{Offset: 0, Line: 1, Column: 1},
+		{Offset: 4, Line: 2, Column: 5},
+		{Offset: 9, Line: 3, Column: 1},
+	}
+
+	tests := []struct {
+		offset       int
+		expectedLine int
+		expectedOk   bool
+	}{
+		{-1, 0, false},
+		{0, 1, true},
+		{3, 1, true},
+		{4, 2, true},
+		{8, 2, true},
+		{9, 3, true},
+		{100, 3, true},
+	}
+
+	for _, tt := range tests {
+		entry, ok := lines.Lookup(tt.offset)
+		if ok != tt.expectedOk || entry.Line != tt.expectedLine {
+			t.Errorf("wrong entry for offset %d. want=%d (%t), got=%d (%t)",
+				tt.offset, tt.expectedLine, tt.expectedOk, entry.Line, ok)
+		}
+	}
+
+	if _, ok := (LineTable{}).Lookup(0); ok {
+		t.Errorf("empty table returned an entry")
+	}
+}
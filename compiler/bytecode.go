@@ -2,7 +2,9 @@ package compiler
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math/big"
 
 	"github.com/rhwilr/lemur/build"
 	"github.com/rhwilr/lemur/code"
@@ -11,9 +13,22 @@ import (
 
 const Signature = "rhwilr/lemur"
 
+var errTruncated = errors.New("binary file is truncated")
+
+// Bits of the flags byte stored with each compiled function
+const functionFlagGenerator byte = 1 << 0
+
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+
+	// Debug information, used for stack traces
+	File  string
+	Lines code.LineTable
+
+	// Globals holds the names of the global variables by index. It is only
+	// kept for debuggers and not written to compiled files.
+	Globals []string
 }
 
 type ConstantDefinition struct {
@@ -29,6 +44,9 @@ func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Constants:    c.constants,
 		Instructions: c.currentInstructions(),
+		File:         c.file,
+		Lines:        c.currentLines(),
+		Globals:      c.symbolTable.Names(),
 	}
 }
 
@@ -40,22 +58,37 @@ func (b *Bytecode) Write() []byte {
 
 	out := append(header, constants...)
 	out = append(out, instructions...)
+	out = append(out, writeDebug(b)...)
 
 	return out
 }
 
 func Read(bytecode []byte) (*Bytecode, error) {
-	lenConstants, _, offset, err := readHeader(bytecode)
+	lenConstants, lenInstructions, offset, err := readHeader(bytecode)
 	if err != nil {
 		return nil, err
 	}
 
 	constants, offset := readConstants(bytecode, offset, lenConstants)
 
-	return &Bytecode{
+	end := offset + int(lenInstructions)
+	if end > len(bytecode) {
+		return nil, errTruncated
+	}
+
+	b := &Bytecode{
 		Constants:    constants,
-		Instructions: bytecode[offset:],
-	}, nil
+		Instructions: bytecode[offset:end],
+	}
+
+	// The debug section is optional
+	if end < len(bytecode) {
+		if err := readDebug(b, bytecode, end); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
 }
 
 /*
@@ -138,8 +171,43 @@ func writeConstants(consts []object.Object) []byte {
 			binary.BigEndian.PutUint32(value[8:], uint32(cnst.NumParameters))
 			binary.BigEndian.PutUint32(value[12:], uint32(cnst.NumDefaults))
 
+			var flags byte
+			if cnst.Generator {
+				flags |= functionFlagGenerator
+			}
+			value = append(value, flags)
+
+			value = append(value, writeString(cnst.Name)...)
+			params := make([]byte, 4)
+			binary.BigEndian.PutUint32(params[:], uint32(len(cnst.Parameters)))
+			value = append(value, params...)
+			for _, param := range cnst.Parameters {
+				value = append(value, writeString(param)...)
+			}
+
 			value = append(value, cnst.Instructions...)
 			out.write(byte(2), value)
+
+		case object.BIG_INTEGER_OBJ:
+			var cnst *object.BigInteger = c.(*object.BigInteger)
+			magnitude := cnst.Value.Bytes()
+
+			value := make([]byte, 5)
+			binary.BigEndian.PutUint32(value[:], uint32(len(magnitude)))
+			if cnst.Value.Sign() < 0 {
+				value[4] = 1
+			}
+
+			value = append(value, magnitude...)
+			out.write(byte(3), value)
+
+		case object.CHAR_OBJ:
+			var cnst *object.Char = c.(*object.Char)
+
+			value := make([]byte, 4)
+			binary.BigEndian.PutUint32(value[:], uint32(cnst.Value))
+
+			out.write(byte(4), value)
 		}
 	}
 
@@ -186,24 +254,187 @@ func readConstants(bytecode []byte, offset int, lenConstants uint16) ([]object.O
 			numDefaults := int(binary.BigEndian.Uint32(bytecode[offset : offset+4]))
 			offset += 4
 
+			flags := bytecode[offset]
+			offset += 1
+
+			var name string
+			name, offset = readString(bytecode, offset)
+
+			params := make([]string, binary.BigEndian.Uint32(bytecode[offset:offset+4]))
+			offset += 4
+			for i := range params {
+				params[i], offset = readString(bytecode, offset)
+			}
+
 			instructions := bytecode[offset : offset+length]
 
 			compiledFunctionObject := &object.CompiledFunction{
 				NumLocals:     numLocals,
 				NumParameters: numParameters,
 				NumDefaults:   numDefaults,
+				Generator:     flags&functionFlagGenerator != 0,
+				Name:          name,
+				Parameters:    params,
 				Instructions:  instructions,
 			}
 
 			constants = append(constants, compiledFunctionObject)
 
 			offset += length
+
+		case 3:
+			length := int(binary.BigEndian.Uint32(bytecode[offset : offset+4]))
+			offset += 4
+
+			negative := bytecode[offset] == 1
+			offset += 1
+
+			value := new(big.Int).SetBytes(bytecode[offset : offset+length])
+			if negative {
+				value.Neg(value)
+			}
+
+			constants = append(constants, &object.BigInteger{Value: value})
+
+			offset += length
+
+		case 4:
+			value := rune(binary.BigEndian.Uint32(bytecode[offset : offset+4]))
+			constants = append(constants, &object.Char{Value: value})
+
+			offset += 4
 		}
 	}
 
 	return constants, offset
 }
 
+/*
+** Debug Section
+ */
+// writeDebug writes the file name and the line tables of the program and of
+// all functions. Nothing is written if there are no line tables.
+func writeDebug(b *Bytecode) []byte {
+	functions := []byte{}
+	count := 0
+	for i, c := range b.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok || len(fn.Lines) == 0 {
+			continue
+		}
+
+		index := make([]byte, 2)
+		binary.BigEndian.PutUint16(index, uint16(i))
+		functions = append(functions, index...)
+		functions = append(functions, writeLineTable(fn.Lines)...)
+		count++
+	}
+
+	if count == 0 && len(b.Lines) == 0 {
+		return []byte{}
+	}
+
+	out := writeString(b.File)
+	out = append(out, writeLineTable(b.Lines)...)
+
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(count))
+	out = append(out, length...)
+
+	return append(out, functions...)
+}
+
+// readDebug reads the debug section written by writeDebug. The lengths it
+// contains are checked, a truncated or corrupted section is an error.
+func readDebug(b *Bytecode, bytecode []byte, offset int) error {
+	if !available(bytecode, offset, 4) {
+		return errTruncated
+	}
+	if length := int(binary.BigEndian.Uint32(bytecode[offset:])); !available(bytecode, offset+4, length) {
+		return errTruncated
+	}
+	b.File, offset = readString(bytecode, offset)
+
+	var err error
+	b.Lines, offset, err = readLineTable(bytecode, offset)
+	if err != nil {
+		return err
+	}
+
+	if !available(bytecode, offset, 2) {
+		return errTruncated
+	}
+	count := int(binary.BigEndian.Uint16(bytecode[offset : offset+2]))
+	offset += 2
+
+	for i := 0; i < count; i++ {
+		if !available(bytecode, offset, 2) {
+			return errTruncated
+		}
+		index := int(binary.BigEndian.Uint16(bytecode[offset : offset+2]))
+		offset += 2
+
+		var lines code.LineTable
+		lines, offset, err = readLineTable(bytecode, offset)
+		if err != nil {
+			return err
+		}
+
+		if index >= len(b.Constants) {
+			return fmt.Errorf("debug section refers to unknown constant %d", index)
+		}
+		if fn, ok := b.Constants[index].(*object.CompiledFunction); ok {
+			fn.Lines = lines
+		}
+	}
+
+	if offset != len(bytecode) {
+		return fmt.Errorf("unexpected %d bytes after the debug section", len(bytecode)-offset)
+	}
+
+	return nil
+}
+
+// A line table is its length (uint32) followed by the offset, line and column
+// (uint32 each) of every entry.
+func writeLineTable(lines code.LineTable) []byte {
+	out := make([]byte, 4+len(lines)*12)
+	binary.BigEndian.PutUint32(out, uint32(len(lines)))
+
+	for i, entry := range lines {
+		at := 4 + i*12
+		binary.BigEndian.PutUint32(out[at:], uint32(entry.Offset))
+		binary.BigEndian.PutUint32(out[at+4:], uint32(entry.Line))
+		binary.BigEndian.PutUint32(out[at+8:], uint32(entry.Column))
+	}
+
+	return out
+}
+
+func readLineTable(bytecode []byte, offset int) (code.LineTable, int, error) {
+	if !available(bytecode, offset, 4) {
+		return nil, offset, errTruncated
+	}
+	length := int(binary.BigEndian.Uint32(bytecode[offset : offset+4]))
+	offset += 4
+
+	if !available(bytecode, offset, length*12) {
+		return nil, offset, errTruncated
+	}
+
+	lines := make(code.LineTable, length)
+	for i := range lines {
+		lines[i] = code.LineEntry{
+			Offset: int(binary.BigEndian.Uint32(bytecode[offset:])),
+			Line:   int(binary.BigEndian.Uint32(bytecode[offset+4:])),
+			Column: int(binary.BigEndian.Uint32(bytecode[offset+8:])),
+		}
+		offset += 12
+	}
+
+	return lines, offset, nil
+}
+
 /*
 ** Helpers
  */
@@ -218,3 +449,23 @@ func (out *Output) write(op byte, operands []byte) {
 	out.Output = append(out.Output, op)
 	out.Output = append(out.Output, operands...)
 }
+
+// Strings are stored as their length (uint32) followed by the UTF-8 bytes.
+func writeString(s string) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length[:], uint32(len(s)))
+
+	return append(length, s...)
+}
+
+func readString(bytecode []byte, offset int) (string, int) {
+	length := int(binary.BigEndian.Uint32(bytecode[offset : offset+4]))
+	offset += 4
+
+	return string(bytecode[offset : offset+length]), offset + length
+}
+
+// available reports whether n bytes can be read at the offset.
+func available(bytecode []byte, offset int, n int) bool {
+	return n >= 0 && offset+n <= len(bytecode)
+}
@@ -2,11 +2,12 @@ package compiler
 
 import (
 	"fmt"
-	"sort"
 
 	"github.com/rhwilr/lemur/ast"
 	"github.com/rhwilr/lemur/code"
+	"github.com/rhwilr/lemur/diagnostics"
 	"github.com/rhwilr/lemur/object"
+	"github.com/rhwilr/lemur/token"
 )
 
 type EmittedInstruction struct {
@@ -18,6 +19,7 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	lines               code.LineTable
 }
 
 type Compiler struct {
@@ -27,6 +29,11 @@ type Compiler struct {
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	// position of the innermost node being compiled, recorded in the line
+	// table of every emitted instruction
+	position token.TokenPosition
+	file     string
 }
 
 func New() *Compiler {
@@ -36,15 +43,9 @@ func New() *Compiler {
 		previousInstruction: EmittedInstruction{},
 	}
 
-	// Add builtins to the symbol table
-	symbolTable := NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
-
 	return &Compiler{
 		constants:   []object.Object{},
-		symbolTable: symbolTable,
+		symbolTable: NewBuiltinSymbolTable(),
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
 	}
@@ -58,7 +59,63 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	return compiler
 }
 
+// Error is a compile error at a position in the source.
+type Error struct {
+	Position token.TokenPosition
+	Code     diagnostics.Code
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Position, e.Message)
+}
+
+var hints = map[diagnostics.Code]string{
+	diagnostics.UndefinedVariable:  "declare the variable with let before using it",
+	diagnostics.ConstantAssignment: "declare the variable with let to allow reassignment",
+	diagnostics.Redeclaration:      "choose a different name or assign without let",
+}
+
+// Diagnostic returns the error as a diagnostic.
+func (e *Error) Diagnostic() diagnostics.Diagnostic {
+	return diagnostics.Diagnostic{
+		Code:     e.Code,
+		Severity: diagnostics.Error,
+		Span:     diagnostics.Span{Start: e.Position, End: e.Position},
+		Message:  e.Message,
+		Hint:     hints[e.Code],
+	}
+}
+
+// Compile compiles the node. Errors are reported at the position of the
+// innermost node that has one.
 func (c *Compiler) Compile(node ast.Node) error {
+	previous := c.position
+	if position := node.Pos(); position.Line > 0 {
+		c.position = position
+		if position.File != "" {
+			c.file = position.File
+		}
+	}
+
+	err := c.compile(node)
+	c.position = previous
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(*Error); !ok && node.Pos().Line > 0 {
+		return &Error{Position: node.Pos(), Code: diagnostics.CompileError, Message: err.Error()}
+	}
+
+	return err
+}
+
+func errorAt(node ast.Node, code diagnostics.Code, format string, a ...interface{}) error {
+	return &Error{Position: node.Pos(), Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+func (c *Compiler) compile(node ast.Node) error {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
@@ -177,7 +234,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return errorAt(node, diagnostics.UnknownOperator, "unknown operator %s", node.Operator)
 		}
 
 	case *ast.PostfixExpression:
@@ -188,10 +245,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
 		if !ok {
-			return fmt.Errorf("identifier not found: %s", node.Name.Value)
+			return errorAt(node.Name, diagnostics.UndefinedVariable, "identifier not found: %s", node.Name.Value)
 		}
 		if symbol.Type == ConstantType {
-			return fmt.Errorf("assignment to constant variable: %s", node.Name.Value)
+			return errorAt(node.Name, diagnostics.ConstantAssignment, "assignment to constant variable: %s", node.Name.Value)
 		}
 
 		c.loadSymbol(symbol)
@@ -269,22 +326,34 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpGreaterOrEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
+		case "..":
+			c.emit(code.OpRange, 1)
+		case "..<":
+			c.emit(code.OpRange, 0)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return errorAt(node, diagnostics.UnknownOperator, "unknown operator %s", node.Operator)
 		}
 
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
 
+	case *ast.BigIntegerLiteral:
+		integer := &object.BigInteger{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
 
+	case *ast.CharLiteral:
+		char := &object.Char{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(char))
+
 	case *ast.LetStatement:
 		symbol, err := c.symbolTable.Define(node.Name.Value, VariableType)
 		if err != nil {
-			return fmt.Errorf(err.Error())
+			return errorAt(node.Name, diagnostics.Redeclaration, "%s", err)
 		}
 
 		err = c.Compile(node.Value)
@@ -301,7 +370,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.ConstStatement:
 		symbol, err := c.symbolTable.Define(node.Name.Value, ConstantType)
 		if err != nil {
-			return fmt.Errorf(err.Error())
+			return errorAt(node.Name, diagnostics.Redeclaration, "%s", err)
+		}
+
+		// The builtin is loaded by index, so it works even if `freeze` is
+		// shadowed.
+		if node.Freeze {
+			c.emit(code.OpGetBuiltin, builtinIndex("freeze"))
 		}
 
 		err = c.Compile(node.Value)
@@ -309,6 +384,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		if node.Freeze {
+			c.emit(code.OpCall, 1)
+		}
+
 		if symbol.Scope == GlobalScope {
 			c.emit(code.OpSetGlobal, symbol.Index)
 		} else {
@@ -319,10 +398,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.AssignStatement:
 		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
 		if !ok {
-			return fmt.Errorf("identifier not found: %s", node.Name.Value)
+			return errorAt(node.Name, diagnostics.UndefinedVariable, "identifier not found: %s", node.Name.Value)
 		}
 		if symbol.Type == ConstantType {
-			return fmt.Errorf("assignment to constant variable: %s", node.Name.Value)
+			return errorAt(node.Name, diagnostics.ConstantAssignment, "assignment to constant variable: %s", node.Name.Value)
 		}
 
 		if node.Operator != "=" {
@@ -354,7 +433,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("identifier not found: %s", node.Value)
+			return errorAt(node, diagnostics.UndefinedVariable, "identifier not found: %s", node.Value)
 		}
 
 		c.loadSymbol(symbol)
@@ -369,23 +448,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpArray, len(node.Elements))
 
-	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
+	case *ast.SetLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
 		}
 
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
+		c.emit(code.OpSet, len(node.Elements))
 
-		for _, k := range keys {
-			err := c.Compile(k)
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			err := c.Compile(pair.Key)
 			if err != nil {
 				return err
 			}
 
-			err = c.Compile(node.Pairs[k])
+			err = c.Compile(pair.Value)
 			if err != nil {
 				return err
 			}
@@ -393,6 +473,37 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpHash, len(node.Pairs)*2)
 
+	case *ast.ArrayComprehension:
+		return c.compileComprehension(node.Variables, node.Iterable, node.Condition, code.OpArray, func(accumulator Symbol) error {
+			c.loadSymbol(accumulator)
+
+			err := c.Compile(node.Element)
+			if err != nil {
+				return err
+			}
+
+			c.emit(code.OpAppend)
+			return nil
+		})
+
+	case *ast.HashComprehension:
+		return c.compileComprehension(node.Variables, node.Iterable, node.Condition, code.OpHash, func(accumulator Symbol) error {
+			c.loadSymbol(accumulator)
+
+			err := c.Compile(node.Key)
+			if err != nil {
+				return err
+			}
+
+			err = c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+
+			c.emit(code.OpSetIndex)
+			return nil
+		})
+
 	case *ast.IndexExpression:
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -414,6 +525,36 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpReturn)
 
+	case *ast.YieldExpression:
+		if node.Value != nil {
+			err := c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+		} else {
+			c.emit(code.OpNull)
+		}
+
+		c.emit(code.OpYield)
+
+	case *ast.MacroLiteral:
+		return errorAt(node, diagnostics.MisplacedMacro, "macros can only be defined with a let statement at the top level")
+
+	case *ast.SpawnExpression:
+		err := c.Compile(node.Call.Function)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range node.Call.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpSpawn, len(node.Call.Arguments))
+
 	case *ast.FunctionLiteral:
 		c.enterScope()
 
@@ -426,7 +567,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 			if val, ok := node.Defaults[p.Value]; ok {
 				if err != nil {
-					return fmt.Errorf(err.Error())
+					return errorAt(p, diagnostics.Redeclaration, "%s", err)
 				}
 
 				currentInstructions := len(c.currentInstructions())
@@ -465,6 +606,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		localNames := c.symbolTable.Names()
+		freeNames := c.symbolTable.FreeNames()
+		lines := c.currentLines()
 		instructions := c.leaveScope()
 
 		for _, s := range freeSymbols {
@@ -476,6 +620,15 @@ func (c *Compiler) Compile(node ast.Node) error {
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
 			NumDefaults: len(node.Defaults),
+			Generator:     node.Generator,
+			Name:          node.Name,
+			Parameters:    make([]string, len(node.Parameters)),
+			Lines:         lines,
+			LocalNames:    localNames,
+			FreeNames:     freeNames,
+		}
+		for i, param := range node.Parameters {
+			compiledFn.Parameters[i] = param.Value
 		}
 
 		fnIndex := c.addConstant(compiledFn)
@@ -484,7 +637,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if node.Define {
 			symbol, err := c.symbolTable.Define(node.Name, VariableType)
 			if err != nil {
-				return fmt.Errorf(err.Error())
+				return errorAt(node, diagnostics.Redeclaration, "%s", err)
 			}
 
 			if symbol.Scope == GlobalScope {
@@ -557,9 +710,100 @@ func (c *Compiler) compileLogicalInfixExpression(node *ast.InfixExpression) erro
 	return nil
 }
 
+// compileComprehension lowers a comprehension into a loop. The loop is wrapped
+// in a closure that is called immediately, this way the comprehension variables
+// live in their own scope and the VM can treat it like any other function. The
+// accumulator is created with the `initial` opcode and `compileElement` emits
+// the instructions that add one element to it.
+func (c *Compiler) compileComprehension(variables []*ast.Identifier, iterable ast.Expression, condition ast.Expression, initial code.Opcode, compileElement func(accumulator Symbol) error) error {
+	c.enterScope()
+
+	// The '$' prefix makes sure the name can not clash with an identifier.
+	accumulator, err := c.symbolTable.Define("$accumulator", VariableType)
+	if err != nil {
+		return err
+	}
+
+	c.emit(initial, 0)
+	c.emit(code.OpSetLocal, accumulator.Index)
+
+	err = c.Compile(iterable)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpIterator)
+
+	// Emit an `OpIterNext` with a bogus jump target
+	loopPos := c.emit(code.OpIterNext, 9999, len(variables))
+
+	symbols := []Symbol{}
+	for _, v := range variables {
+		symbol, err := c.symbolTable.Define(v.Value, VariableType)
+		if err != nil {
+			return err
+		}
+
+		symbols = append(symbols, symbol)
+	}
+
+	// The values are pushed in order, so they have to be popped in reverse.
+	for i := len(symbols) - 1; i >= 0; i-- {
+		c.emit(code.OpSetLocal, symbols[i].Index)
+	}
+
+	if condition != nil {
+		err := c.Compile(condition)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpJumpNotTruthy, loopPos)
+	}
+
+	err = compileElement(accumulator)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpJump, loopPos)
+
+	afterLoopPos := len(c.currentInstructions())
+	c.replaceInstruction(loopPos, code.Make(code.OpIterNext, afterLoopPos, len(variables)))
+
+	c.loadSymbol(accumulator)
+	c.emit(code.OpReturn)
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.Names()
+	freeNames := c.symbolTable.FreeNames()
+	lines := c.currentLines()
+	instructions := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		c.loadSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions: instructions,
+		NumLocals:    numLocals,
+		Lines:        lines,
+		LocalNames:   localNames,
+		FreeNames:    freeNames,
+	}
+
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+	c.emit(code.OpCall, 0)
+
+	return nil
+}
+
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
+	c.addLine(pos)
 
 	c.setLastInstruction(op, pos)
 
@@ -607,6 +851,26 @@ func (c *Compiler) addConstant(obj object.Object) int {
 			}
 		}
 
+	case *object.BigInteger:
+		for i, node := range c.constants {
+			switch node := node.(type) {
+			case *object.BigInteger:
+				if obj.Value.Cmp(node.Value) == 0 {
+					return i
+				}
+			}
+		}
+
+	case *object.Char:
+		for i, node := range c.constants {
+			switch node := node.(type) {
+			case *object.Char:
+				if obj.Value == node.Value {
+					return i
+				}
+			}
+		}
+
 	case *object.String:
 		for i, node := range c.constants {
 			switch node := node.(type) {
@@ -626,6 +890,10 @@ func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
 
+func (c *Compiler) currentLines() code.LineTable {
+	return c.scopes[c.scopeIndex].lines
+}
+
 func (c *Compiler) addInstruction(ins []byte) int {
 	posNewInstruction := len(c.currentInstructions())
 	updatedInstructions := append(c.currentInstructions(), ins...)
@@ -660,6 +928,34 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	lines := c.currentLines()
+	for len(lines) > 0 && lines[len(lines)-1].Offset >= len(new) {
+		lines = lines[:len(lines)-1]
+	}
+	c.scopes[c.scopeIndex].lines = lines
+}
+
+// addLine records the current source position for the instruction at pos.
+// Consecutive instructions from the same position share one entry.
+func (c *Compiler) addLine(pos int) {
+	if c.position.Line == 0 {
+		return
+	}
+
+	lines := c.currentLines()
+	if n := len(lines); n > 0 {
+		last := lines[n-1]
+		if last.Line == c.position.Line && last.Column == c.position.Column {
+			return
+		}
+		if last.Offset == pos {
+			lines = lines[:n-1]
+		}
+	}
+
+	entry := code.LineEntry{Offset: pos, Line: c.position.Line, Column: c.position.Column}
+	c.scopes[c.scopeIndex].lines = append(lines, entry)
 }
 
 func (c *Compiler) replaceLastPopWithReturn() {
@@ -683,6 +979,16 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	}
 }
 
+func builtinIndex(name string) int {
+	for i, def := range object.Builtins {
+		if def.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
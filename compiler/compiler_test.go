@@ -2,10 +2,12 @@ package compiler
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/rhwilr/lemur/ast"
 	"github.com/rhwilr/lemur/code"
+	"github.com/rhwilr/lemur/diagnostics"
 	"github.com/rhwilr/lemur/lexer"
 	"github.com/rhwilr/lemur/object"
 	"github.com/rhwilr/lemur/parser"
@@ -84,42 +86,52 @@ func TestErrorHandling(t *testing.T) {
 	tests := []struct {
 		input           string
 		expectedMessage string
+		expectedCode    diagnostics.Code
 	}{
 		{
 			"let foobar = 5; let foobar = 6;",
-			"identifier 'foobar' has already been declared",
+			"1:21: identifier 'foobar' has already been declared",
+			diagnostics.Redeclaration,
 		},
 		{
 			"foobar = 5;",
-			"identifier not found: foobar",
+			"1:1: identifier not found: foobar",
+			diagnostics.UndefinedVariable,
 		},
 		{
 			"foobar += 5;",
-			"identifier not found: foobar",
+			"1:1: identifier not found: foobar",
+			diagnostics.UndefinedVariable,
 		},
 		{
 			"const foobar = 5; const foobar = 6;",
-			"identifier 'foobar' has already been declared",
+			"1:25: identifier 'foobar' has already been declared",
+			diagnostics.Redeclaration,
 		},
 		{
 			"const foobar = 5; foobar = 6;",
-			"assignment to constant variable: foobar",
+			"1:19: assignment to constant variable: foobar",
+			diagnostics.ConstantAssignment,
 		},
 		{
 			"const i = 5; i++;",
-			"assignment to constant variable: i",
+			"1:14: assignment to constant variable: i",
+			diagnostics.ConstantAssignment,
 		},
 		{
 			"const i = 5; --i;",
-			"assignment to constant variable: i",
+			"1:16: assignment to constant variable: i",
+			diagnostics.ConstantAssignment,
 		},
 		{
 			"i++;",
-			"identifier not found: i",
+			"1:1: identifier not found: i",
+			diagnostics.UndefinedVariable,
 		},
 		{
 			"++i;",
-			"identifier not found: i",
+			"1:3: identifier not found: i",
+			diagnostics.UndefinedVariable,
 		},
 	}
 
@@ -136,6 +148,99 @@ func TestErrorHandling(t *testing.T) {
 		if err.Error() != tt.expectedMessage {
 			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedMessage, err.Error())
 		}
+
+		if code := err.(*Error).Diagnostic().Code; code != tt.expectedCode {
+			t.Errorf("wrong error code. expected=%s, got=%s", tt.expectedCode, code)
+		}
+	}
+}
+
+func TestLineTables(t *testing.T) {
+	program := parse("1;\nlet x = 2 + 3;\nlet f = function() {\n  x\n};")
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	expected := code.LineTable{
+		{Offset: 0, Line: 1, Column: 1},
+		{Offset: 4, Line: 2, Column: 9},
+		{Offset: 7, Line: 2, Column: 13},
+		{Offset: 10, Line: 2, Column: 11},
+		{Offset: 11, Line: 2, Column: 1},
+		{Offset: 14, Line: 3, Column: 9},
+		{Offset: 18, Line: 3, Column: 1},
+	}
+
+	if !reflect.DeepEqual(bytecode.Lines, expected) {
+		t.Errorf("wrong line table.\nwant=%v\ngot =%v", expected, bytecode.Lines)
+	}
+
+	fn := bytecode.Constants[3].(*object.CompiledFunction)
+	expectedFn := code.LineTable{{Offset: 0, Line: 4, Column: 3}}
+	if !reflect.DeepEqual(fn.Lines, expectedFn) {
+		t.Errorf("wrong line table of function.\nwant=%v\ngot =%v", expectedFn, fn.Lines)
+	}
+
+	read, err := Read(bytecode.Write())
+	if err != nil {
+		t.Fatalf("bytecode error: %s", err)
+	}
+
+	if !reflect.DeepEqual(read.Lines, expected) {
+		t.Errorf("wrong line table after reading.\nwant=%v\ngot =%v", expected, read.Lines)
+	}
+
+	if !reflect.DeepEqual(read.Constants[3].(*object.CompiledFunction).Lines, expectedFn) {
+		t.Errorf("wrong line table of function after reading")
+	}
+}
+
+func TestReadDebugErrors(t *testing.T) {
+	compiler := New()
+	if err := compiler.Compile(parse("let f = function() {\n  1\n};\nf();")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	binary := bytecode.Write()
+	debug := len(binary) - len(writeDebug(bytecode))
+
+	// Cutting the debug section anywhere is an error, not a panic
+	for end := debug + 1; end < len(binary); end++ {
+		if _, err := Read(binary[:end]); err == nil {
+			t.Errorf("no error for a binary cut at %d of %d bytes", end, len(binary))
+		}
+	}
+
+	corrupt := func(at int, value ...byte) []byte {
+		out := append([]byte{}, binary...)
+		copy(out[at:], value)
+		return out
+	}
+
+	// The section starts with the length of the empty file name, followed by
+	// the main line table. It ends with the index and the line table of f,
+	// which has one entry of 12 bytes.
+	tests := []struct {
+		input           []byte
+		expectedMessage string
+	}{
+		{binary[:len(binary)-3], "binary file is truncated"},
+		{corrupt(debug, 0xFF, 0xFF, 0xFF, 0xFF), "binary file is truncated"},
+		{corrupt(debug+4, 0x7F, 0xFF, 0xFF, 0xFF), "binary file is truncated"},
+		{corrupt(len(binary)-18, 0x00, 0x63), "debug section refers to unknown constant 99"},
+		{append(append([]byte{}, binary...), 0), "unexpected 1 bytes after the debug section"},
+	}
+
+	for i, tt := range tests {
+		_, err := Read(tt.input)
+		if err == nil || err.Error() != tt.expectedMessage {
+			t.Errorf("test %d: wrong error. want=%q, got=%v", i, tt.expectedMessage, err)
+		}
 	}
 }
 
@@ -554,6 +659,19 @@ func TestGlobalLetStatements(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input: `
+			const freeze one = [1];
+			`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 30),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
 	}
 	runCompilerTests(t, tests)
 }
@@ -1564,6 +1682,142 @@ func TestPrefixAndPostfixStatements(t *testing.T) {
 /*
 ** Helpers
  */
+func TestRanges(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1..2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpRange, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1..<2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpRange, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSpawn(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "spawn len(1, 2)",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSpawn, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSetLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "#{}",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpSet, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "#{1, 2}",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSet, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestComprehensions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let xs = []; [x * 2 for x in xs if x]",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpIterator),
+					code.Make(code.OpIterNext, 32, 1),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpJumpNotTruthy, 9),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpMul),
+					code.Make(code.OpAppend),
+					code.Make(code.OpJump, 9),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "let h = {}; {k: v for k, v in h}",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpHash, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpIterator),
+					code.Make(code.OpIterNext, 27, 2),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpSetIndex),
+					code.Make(code.OpJump, 9),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
 
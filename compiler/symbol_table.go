@@ -1,6 +1,10 @@
 package compiler
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/rhwilr/lemur/object"
+)
 
 type SymbolScope string
 
@@ -42,6 +46,17 @@ func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{store: s, FreeSymbols: free}
 }
 
+// NewBuiltinSymbolTable returns a global symbol table with all builtins
+// defined.
+func NewBuiltinSymbolTable() *SymbolTable {
+	s := NewSymbolTable()
+	for i, v := range object.Builtins {
+		s.DefineBuiltin(i, v.Name)
+	}
+
+	return s
+}
+
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	s := NewSymbolTable()
 	s.Outer = outer
@@ -51,8 +66,11 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 func (s *SymbolTable) Define(name string, symbolType SymbolType) (Symbol, error) {
 	symbol := Symbol{Name: name, Index: s.numDefinitions, Type: symbolType}
 
+	// Builtins and the name of the current function may be shadowed. Builtins
+	// like add or name are common variable names, and the evaluator lets the
+	// environment shadow builtins as well.
 	found, ok := s.store[name]
-	if ok && found.Scope != FunctionScope {
+	if ok && found.Scope != FunctionScope && found.Scope != BuiltinScope {
 		return symbol, fmt.Errorf("identifier '%s' has already been declared", name)
 	}
 
@@ -102,6 +120,30 @@ func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 	return symbol
 }
 
+// Names returns the names of the variables defined in this table by their
+// index. Debuggers use them to find the value of a variable.
+func (s *SymbolTable) Names() []string {
+	names := make([]string, s.numDefinitions)
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			names[symbol.Index] = symbol.Name
+		}
+	}
+
+	return names
+}
+
+// FreeNames returns the names of the free variables of this table by their
+// index.
+func (s *SymbolTable) FreeNames() []string {
+	names := make([]string, len(s.FreeSymbols))
+	for i, symbol := range s.FreeSymbols {
+		names[i] = symbol.Name
+	}
+
+	return names
+}
+
 /*
 ** Helpers
  */
@@ -1,6 +1,9 @@
 package compiler
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestDefine(t *testing.T) {
 	expected := map[string]Symbol{
@@ -355,3 +358,61 @@ func TestShadowingFunctionName(t *testing.T) {
 		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
 	}
 }
+
+func TestShadowingBuiltins(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	local := NewEnclosedSymbolTable(global)
+
+	if _, err := global.Define("len", VariableType); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := global.Define("len", VariableType); err == nil {
+		t.Errorf("expected an error for the second definition of len")
+	}
+
+	expected := Symbol{Name: "len", Scope: GlobalScope, Index: 0}
+	for _, table := range []*SymbolTable{global, local} {
+		result, ok := table.Resolve(expected.Name)
+		if !ok {
+			t.Fatalf("name %s not resolvable", expected.Name)
+		}
+
+		if result != expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	global := NewBuiltinSymbolTable()
+	global.Define("a", VariableType)
+	global.Define("len", VariableType)
+
+	local := NewEnclosedSymbolTable(global)
+	local.DefineFunctionName("f")
+	local.Define("c", VariableType)
+	local.Define("d", ConstantType)
+
+	nested := NewEnclosedSymbolTable(local)
+	nested.Define("e", VariableType)
+	nested.Resolve("d")
+	nested.Resolve("c")
+	nested.Resolve("a")
+
+	tests := []struct {
+		names    []string
+		expected []string
+	}{
+		{global.Names(), []string{"a", "len"}},
+		{local.Names(), []string{"c", "d"}},
+		{nested.Names(), []string{"e"}},
+		{nested.FreeNames(), []string{"d", "c"}},
+	}
+
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.names, tt.expected) {
+			t.Errorf("wrong names. want=%v, got=%v", tt.expected, tt.names)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rhwilr/lemur/transport"
+)
+
+const program = `let total = 0;
+let items = [1, "two", [3]];
+let config = {"name": "lemur", "level": 2};
+function add(a, b) {
+  let sum = a + b;
+  return sum;
+}
+total = add(total, 5);
+println(total);
+total = add(total, len(items));
+println("done");
+`
+
+// client plays a debugging session with a server running in the background.
+type client struct {
+	t   *testing.T
+	in  *io.PipeWriter
+	seq int
+
+	messages chan message
+	events   []message
+	done     chan error
+}
+
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, in := io.Pipe()
+	out, serverOut := io.Pipe()
+
+	c := &client{t: t, in: in, messages: make(chan message, 1000), done: make(chan error, 1)}
+
+	go func() {
+		c.done <- NewServer(serverIn, serverOut).Run()
+	}()
+
+	go func() {
+		r := bufio.NewReader(out)
+		for {
+			content, err := transport.ReadMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+
+			var m message
+			json.Unmarshal(content, &m)
+			c.messages <- m
+		}
+	}()
+
+	return c
+}
+
+func (c *client) next() message {
+	select {
+	case m, ok := <-c.messages:
+		if !ok {
+			c.t.Fatalf("server closed the connection")
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timeout waiting for the server")
+	}
+
+	return message{}
+}
+
+// request sends a request and waits for its response. Events that arrive in
+// the meantime are kept for waitFor.
+func (c *client) request(command string, arguments interface{}) message {
+	c.seq++
+	transport.WriteMessage(c.in, map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": arguments})
+
+	for {
+		m := c.next()
+		if m.Type == "response" && m.RequestSeq == c.seq {
+			if m.Command != command {
+				c.t.Errorf("wrong command in response. want=%s, got=%s", command, m.Command)
+			}
+			return m
+		}
+		c.events = append(c.events, m)
+	}
+}
+
+func (c *client) success(command string, arguments interface{}, body interface{}) {
+	m := c.request(command, arguments)
+	if !m.Success {
+		c.t.Fatalf("%s failed: %s", command, m.Message)
+	}
+
+	if body != nil {
+		if err := json.Unmarshal(m.Body, body); err != nil {
+			c.t.Fatalf("invalid body of %s: %s", command, err)
+		}
+	}
+}
+
+func (c *client) waitFor(name string) message {
+	for i, m := range c.events {
+		if m.Event == name {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			return m
+		}
+	}
+
+	for {
+		m := c.next()
+		if m.Type == "event" && m.Event == name {
+			return m
+		}
+		c.events = append(c.events, m)
+	}
+}
+
+// output returns what the program printed so far.
+func (c *client) output(category string) string {
+	var out strings.Builder
+	for _, m := range c.events {
+		var body outputEvent
+		if m.Event == "output" && json.Unmarshal(m.Body, &body) == nil && body.Category == category {
+			out.WriteString(body.Output)
+		}
+	}
+
+	return out.String()
+}
+
+func (c *client) stopped(reason string) {
+	var body stoppedEvent
+	json.Unmarshal(c.waitFor("stopped").Body, &body)
+
+	if body.Reason != reason || body.ThreadID != threadID {
+		c.t.Fatalf("wrong stopped event. want reason %s, got=%+v", reason, body)
+	}
+}
+
+func (c *client) stackTrace() []StackFrame {
+	var body struct {
+		StackFrames []StackFrame `json:"stackFrames"`
+	}
+	c.success("stackTrace", map[string]int{"threadId": threadID}, &body)
+
+	return body.StackFrames
+}
+
+func (c *client) variables(reference int) map[string]Variable {
+	var body struct {
+		Variables []Variable `json:"variables"`
+	}
+	c.success("variables", map[string]int{"variablesReference": reference}, &body)
+
+	found := map[string]Variable{}
+	for _, v := range body.Variables {
+		found[v.Name] = v
+	}
+
+	return found
+}
+
+func (c *client) disconnect() {
+	c.success("disconnect", nil, nil)
+
+	if err := <-c.done; err != nil {
+		c.t.Errorf("server failed: %s", err)
+	}
+}
+
+// launch starts a session for the source in a temporary file and stops
+// before running it.
+func launch(t *testing.T, source string, stopOnEntry bool) (*client, string) {
+	dir, err := ioutil.TempDir("", "lemur-dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "main.lem")
+	if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	c.success("initialize", map[string]string{"adapterID": "lemur"}, nil)
+	c.success("launch", map[string]interface{}{"program": path, "stopOnEntry": stopOnEntry}, nil)
+	c.waitFor("initialized")
+
+	return c, path
+}
+
+func (c *client) setBreakpoints(path string, lines ...int) []Breakpoint {
+	breakpoints := make([]map[string]int, len(lines))
+	for i, line := range lines {
+		breakpoints[i] = map[string]int{"line": line}
+	}
+
+	var body struct {
+		Breakpoints []Breakpoint `json:"breakpoints"`
+	}
+	c.success("setBreakpoints", map[string]interface{}{"source": Source{Path: path}, "breakpoints": breakpoints}, &body)
+
+	return body.Breakpoints
+}
+
+func TestInitialize(t *testing.T) {
+	c := newClient(t)
+
+	var capabilities map[string]bool
+	c.success("initialize", map[string]string{"adapterID": "lemur"}, &capabilities)
+	if !capabilities["supportsConfigurationDoneRequest"] {
+		t.Errorf("expected configurationDone to be supported, got=%v", capabilities)
+	}
+
+	tests := []struct {
+		command   string
+		arguments interface{}
+		expected  string
+	}{
+		{"configurationDone", nil, "no program has been launched"},
+		{"stackTrace", map[string]int{"threadId": threadID}, "not stopped"},
+		{"launch", map[string]string{"program": "missing.lem"}, "missing.lem"},
+		{"readMemory", nil, "unsupported request readMemory"},
+	}
+
+	for _, tt := range tests {
+		m := c.request(tt.command, tt.arguments)
+		if m.Success || !strings.Contains(m.Message, tt.expected) {
+			t.Errorf("%s: expected an error containing %q, got=%+v", tt.command, tt.expected, m)
+		}
+	}
+
+	c.disconnect()
+}
+
+func TestLaunchErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = ;", "SyntaxError"},
+		{"println(y);", "identifier not found: y"},
+	}
+
+	for _, tt := range tests {
+		dir, _ := ioutil.TempDir("", "lemur-dap")
+		path := filepath.Join(dir, "main.lem")
+		ioutil.WriteFile(path, []byte(tt.input), 0644)
+
+		c := newClient(t)
+		m := c.request("launch", map[string]string{"program": path})
+		if m.Success || !strings.Contains(m.Message, tt.expected) {
+			t.Errorf("input %q: expected an error containing %q, got=%+v", tt.input, tt.expected, m)
+		}
+		c.disconnect()
+
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBreakpointsAndVariables(t *testing.T) {
+	c, path := launch(t, program, false)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	breakpoints := c.setBreakpoints(path, 5, 7)
+	if !breakpoints[0].Verified || breakpoints[1].Verified || breakpoints[1].Message != "no code on line 7" {
+		t.Errorf("wrong breakpoints: %+v", breakpoints)
+	}
+
+	if other := c.setBreakpoints("other.lem", 1); other[0].Verified {
+		t.Errorf("breakpoint in another file must not be verified")
+	}
+
+	c.success("configurationDone", nil, nil)
+	c.stopped("breakpoint")
+
+	var threads struct {
+		Threads []Thread `json:"threads"`
+	}
+	c.success("threads", nil, &threads)
+	if len(threads.Threads) != 1 || threads.Threads[0].ID != threadID {
+		t.Errorf("wrong threads: %+v", threads)
+	}
+
+	frames := c.stackTrace()
+	if len(frames) != 2 || frames[0].Name != "add" || frames[0].Line != 5 || frames[1].Name != "main" || frames[1].Line != 8 {
+		t.Fatalf("wrong stack trace: %+v", frames)
+	}
+	if frames[0].Source == nil || frames[0].Source.Path != path {
+		t.Errorf("wrong source: %+v", frames[0].Source)
+	}
+
+	var scopes struct {
+		Scopes []Scope `json:"scopes"`
+	}
+	c.success("scopes", map[string]int{"frameId": frames[0].ID}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "Locals" || scopes.Scopes[1].Name != "Globals" {
+		t.Fatalf("wrong scopes: %+v", scopes)
+	}
+
+	locals := c.variables(scopes.Scopes[0].VariablesReference)
+	if len(locals) != 2 || locals["a"].Value != "0" || locals["b"].Value != "5" || locals["a"].Type != "INTEGER" {
+		t.Errorf("wrong locals: %+v", locals)
+	}
+
+	globals := c.variables(scopes.Scopes[1].VariablesReference)
+	if globals["total"].Value != "0" || globals["add"].VariablesReference != 0 {
+		t.Errorf("wrong globals: %+v", globals)
+	}
+
+	items := c.variables(globals["items"].VariablesReference)
+	if len(items) != 3 || items["[0]"].Value != "1" || items["[1]"].Value != `"two"` {
+		t.Errorf("wrong items: %+v", items)
+	}
+	if nested := c.variables(items["[2]"].VariablesReference); nested["[0]"].Value != "3" {
+		t.Errorf("wrong nested array: %+v", nested)
+	}
+
+	config := c.variables(globals["config"].VariablesReference)
+	if config[`"name"`].Value != `"lemur"` || config[`"level"`].Value != "2" {
+		t.Errorf("wrong config: %+v", config)
+	}
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.success("evaluate", map[string]interface{}{"expression": "b", "frameId": 0}, &result)
+	if result.Result != "5" {
+		t.Errorf("wrong value of b: %s", result.Result)
+	}
+	if m := c.request("evaluate", map[string]interface{}{"expression": "missing", "frameId": 0}); m.Success {
+		t.Errorf("expected unknown variable to fail")
+	}
+
+	c.success("continue", map[string]int{"threadId": threadID}, nil)
+	c.stopped("breakpoint")
+
+	c.success("evaluate", map[string]interface{}{"expression": "a", "frameId": 0}, &result)
+	if result.Result != "5" {
+		t.Errorf("wrong value of a in the second call: %s", result.Result)
+	}
+
+	c.setBreakpoints(path)
+	c.success("continue", map[string]int{"threadId": threadID}, nil)
+
+	var exited exitedEvent
+	json.Unmarshal(c.waitFor("exited").Body, &exited)
+	c.waitFor("terminated")
+
+	if exited.ExitCode != 0 {
+		t.Errorf("wrong exit code: %d", exited.ExitCode)
+	}
+	if output := c.output("stdout"); output != "5\ndone\n" {
+		t.Errorf("wrong output: %q", output)
+	}
+
+	c.disconnect()
+}
+
+func TestStepping(t *testing.T) {
+	c, path := launch(t, program, true)
+	defer os.RemoveAll(filepath.Dir(path))
+	c.success("configurationDone", nil, nil)
+	c.stopped("entry")
+
+	steps := []struct {
+		command string
+		line    int
+		depth   int
+	}{
+		{"next", 2, 1},
+		{"next", 3, 1},
+		{"next", 4, 1},
+		{"next", 8, 1},
+		{"stepIn", 5, 2},
+		{"next", 6, 2},
+		{"stepOut", 8, 1},
+		{"next", 9, 1},
+		{"next", 10, 1},
+	}
+
+	for _, step := range steps {
+		c.success(step.command, map[string]int{"threadId": threadID}, nil)
+		c.stopped("step")
+
+		frames := c.stackTrace()
+		if frames[0].Line != step.line || len(frames) != step.depth {
+			t.Fatalf("%s: wrong position. want line %d at depth %d, got=%+v", step.command, step.line, step.depth, frames)
+		}
+	}
+
+	if m := c.request("scopes", map[string]int{"frameId": 5}); m.Success {
+		t.Errorf("expected an unknown frame to fail")
+	}
+
+	// Disconnecting ends the stopped program
+	c.disconnect()
+}
+
+func TestPause(t *testing.T) {
+	c, path := launch(t, "function spin(n) {\n  return spin(n + 1);\n}\nspin(0);\n", false)
+	defer os.RemoveAll(filepath.Dir(path))
+	c.success("configurationDone", nil, nil)
+
+	c.success("pause", map[string]int{"threadId": threadID}, nil)
+	c.stopped("pause")
+
+	if frames := c.stackTrace(); len(frames) == 0 || frames[0].Line == 0 {
+		t.Errorf("wrong stack trace: %+v", frames)
+	}
+
+	c.success("continue", map[string]int{"threadId": threadID}, nil)
+
+	// Terminating ends the running program
+	c.success("terminate", nil, nil)
+	c.waitFor("terminated")
+	c.disconnect()
+}
+
+func TestRuntimeError(t *testing.T) {
+	c, path := launch(t, "let x = 1;\nx + \"a\";\n", false)
+	defer os.RemoveAll(filepath.Dir(path))
+	c.success("configurationDone", nil, nil)
+
+	var exited exitedEvent
+	json.Unmarshal(c.waitFor("exited").Body, &exited)
+	if exited.ExitCode != 1 {
+		t.Errorf("wrong exit code: %d", exited.ExitCode)
+	}
+
+	if output := c.output("stderr"); !strings.Contains(output, "runtime error") || !strings.Contains(output, "at main") {
+		t.Errorf("wrong error output: %q", output)
+	}
+
+	c.disconnect()
+}
+
+func TestMacroOutput(t *testing.T) {
+	c, path := launch(t, "let m = macro() { println(\"HELLO\"); quote(1) };\nprintln(m());\n", false)
+	defer os.RemoveAll(filepath.Dir(path))
+	c.success("configurationDone", nil, nil)
+	c.waitFor("exited")
+
+	if output := c.output("stdout"); output != "HELLO\n1\n" {
+		t.Errorf("wrong output: %q", output)
+	}
+
+	c.disconnect()
+}
@@ -0,0 +1,494 @@
+// Package dap implements a Debug Adapter Protocol server for Lemur. Editors
+// start it and talk to it over stdin and stdout. It runs a program on the VM
+// and lets the editor set breakpoints, step through the program and inspect
+// its calls and variables.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/debugger"
+	"github.com/rhwilr/lemur/evaluator"
+	"github.com/rhwilr/lemur/lexer"
+	"github.com/rhwilr/lemur/object"
+	"github.com/rhwilr/lemur/parser"
+	"github.com/rhwilr/lemur/transport"
+	"github.com/rhwilr/lemur/vm"
+)
+
+// The program runs in a single thread.
+const threadID = 1
+
+// resumes maps the requests that resume the program to the debugger modes.
+var resumes = map[string]debugger.Mode{
+	"continue": debugger.Continue,
+	"next":     debugger.Next,
+	"stepIn":   debugger.Step,
+	"stepOut":  debugger.Finish,
+}
+
+var errNotStopped = errors.New("the program is not stopped")
+
+type Server struct {
+	in *bufio.Reader
+
+	// The program writes output events while requests are answered.
+	out     io.Writer
+	outLock sync.Mutex
+	seq     int
+
+	program     string
+	debugger    *debugger.Debugger
+	stopOnEntry bool
+	noDebug     bool
+
+	// lock guards the state shared with the goroutine running the program.
+	// While the program is stopped, it waits for the next mode on resume.
+	lock     sync.Mutex
+	started  bool
+	stopped  bool
+	finished bool
+	quitting bool
+	resume   chan debugger.Mode
+
+	references []reference
+}
+
+// NewServer returns a server that reads requests from in and writes the
+// responses and events to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:     bufio.NewReader(in),
+		out:    out,
+		resume: make(chan debugger.Mode, 1),
+	}
+}
+
+// Run handles requests until the client disconnects.
+func (s *Server) Run() error {
+	for {
+		content, err := transport.ReadMessage(s.in)
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil || req.Type != "request" {
+			continue
+		}
+
+		body, err := s.handle(req)
+		s.respond(req, body, err)
+
+		if err != nil {
+			continue
+		}
+
+		switch req.Command {
+		case "launch":
+			// The client sends the breakpoints once the program is loaded
+			s.event("initialized", nil)
+
+		case "disconnect":
+			return nil
+		}
+
+		// The program resumes after the client knows the request succeeded
+		if mode, ok := resumes[req.Command]; ok {
+			s.resume <- mode
+		}
+	}
+}
+
+func (s *Server) handle(req request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+
+	case "launch":
+		var args launchArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.launch(args)
+
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"breakpoints": s.setBreakpoints(args)}, nil
+
+	case "configurationDone":
+		return nil, s.start()
+
+	case "threads":
+		return map[string]interface{}{"threads": []Thread{{ID: threadID, Name: "main"}}}, nil
+
+	case "stackTrace":
+		var args stackTraceArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.whileStopped(func() (interface{}, error) { return s.stackTrace(args), nil })
+
+	case "scopes":
+		var args scopesArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.whileStopped(func() (interface{}, error) { return s.scopes(args.FrameID) })
+
+	case "variables":
+		var args variablesArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.whileStopped(func() (interface{}, error) {
+			variables, err := s.variables(args.VariablesReference)
+			return map[string]interface{}{"variables": variables}, err
+		})
+
+	case "evaluate":
+		var args evaluateArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return s.whileStopped(func() (interface{}, error) { return s.evaluate(args) })
+
+	case "continue", "next", "stepIn", "stepOut":
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if !s.stopped {
+			return nil, errNotStopped
+		}
+		s.stopped = false
+		s.references = nil
+
+		if req.Command == "continue" {
+			return map[string]interface{}{"allThreadsContinued": true}, nil
+		}
+
+	case "pause":
+		if s.debugger == nil {
+			return nil, errors.New("no program is running")
+		}
+		s.debugger.Pause()
+
+	case "terminate", "disconnect":
+		s.quit()
+
+	default:
+		return nil, fmt.Errorf("unsupported request %s", req.Command)
+	}
+
+	return nil, nil
+}
+
+func decode(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+/*
+** Running the program
+ */
+
+// launch compiles the program. It starts running once the client has sent
+// its configuration.
+func (s *Server) launch(args launchArguments) error {
+	if s.debugger != nil {
+		return errors.New("a program has already been launched")
+	}
+
+	input, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+
+	p := parser.New(lexer.NewWithFile(string(input), args.Program))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	// stdin and stdout belong to the protocol, the program and its macros
+	// print to the client instead.
+	in := strings.NewReader("")
+	out := &output{server: s}
+
+	env := object.NewEnvironment()
+	for _, name := range []string{"read", "print", "println"} {
+		env.DefineVariable(name, object.IOBuiltin(name, in, out))
+	}
+
+	program, err = evaluator.ExpandProgram(program, env)
+	if err != nil {
+		return fmt.Errorf("macro error: %s", err)
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		return err
+	}
+
+	bytecode := c.Bytecode()
+	machine := vm.New(bytecode)
+
+	machine.SetIO(in, out)
+
+	s.program = args.Program
+	s.stopOnEntry = args.StopOnEntry && !args.NoDebug
+	s.noDebug = args.NoDebug
+	s.debugger = debugger.New(machine, bytecode, s.stop)
+
+	return nil
+}
+
+func (s *Server) start() error {
+	if s.debugger == nil {
+		return errors.New("no program has been launched")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.started {
+		return nil
+	}
+	s.started = true
+
+	go s.run()
+
+	return nil
+}
+
+// run runs the program and tells the client when it ended.
+func (s *Server) run() {
+	exitCode := 0
+
+	err := s.debugger.Run(s.stopOnEntry)
+	if err != nil {
+		exitCode = 1
+
+		message := fmt.Sprintf("runtime error: %s\n", err)
+		if runtimeErr, ok := err.(*vm.RuntimeError); ok && len(runtimeErr.Trace) > 0 {
+			message += runtimeErr.StackTrace() + "\n"
+		}
+		s.event("output", outputEvent{Category: "stderr", Output: message})
+	}
+
+	s.lock.Lock()
+	s.finished = true
+	s.lock.Unlock()
+
+	s.event("exited", exitedEvent{ExitCode: exitCode})
+	s.event("terminated", nil)
+}
+
+// stop is called by the debugger when the program stops. It waits until the
+// client resumes the program.
+func (s *Server) stop(d *debugger.Debugger, reason debugger.Reason) debugger.Mode {
+	s.lock.Lock()
+	if s.quitting {
+		s.lock.Unlock()
+		return debugger.Quit
+	}
+	s.stopped = true
+	s.lock.Unlock()
+
+	s.event("stopped", stoppedEvent{Reason: string(reason), ThreadID: threadID, AllThreadsStopped: true})
+
+	return <-s.resume
+}
+
+// quit ends the program. A running program ends before its next line.
+func (s *Server) quit() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.started || s.finished || s.quitting {
+		return
+	}
+	s.quitting = true
+
+	if s.stopped {
+		s.stopped = false
+		s.resume <- debugger.Quit
+		return
+	}
+
+	s.debugger.Pause()
+}
+
+// whileStopped calls inspect if the program is stopped. The program does not
+// change while it is stopped.
+func (s *Server) whileStopped(inspect func() (interface{}, error)) (interface{}, error) {
+	s.lock.Lock()
+	stopped := s.stopped
+	s.lock.Unlock()
+
+	if !stopped {
+		return nil, errNotStopped
+	}
+
+	return inspect()
+}
+
+/*
+** Breakpoints
+ */
+func (s *Server) setBreakpoints(args setBreakpointsArguments) []Breakpoint {
+	breakpoints := make([]Breakpoint, len(args.Breakpoints))
+	for i, b := range args.Breakpoints {
+		breakpoints[i].Line = b.Line
+	}
+
+	message := ""
+	switch {
+	case s.debugger == nil:
+		message = "no program has been launched"
+	case s.noDebug:
+		message = "the program runs without debugging"
+	case !samePath(args.Source.Path, s.program):
+		message = "the file is not part of the program"
+	}
+
+	if message != "" {
+		for i := range breakpoints {
+			breakpoints[i].Message = message
+		}
+		return breakpoints
+	}
+
+	for _, line := range s.debugger.Breakpoints() {
+		s.debugger.ClearBreakpoint(line)
+	}
+
+	for i := range breakpoints {
+		if err := s.debugger.SetBreakpoint(breakpoints[i].Line); err != nil {
+			breakpoints[i].Message = err.Error()
+			continue
+		}
+		breakpoints[i].Verified = true
+	}
+
+	return breakpoints
+}
+
+func samePath(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+
+	return errA == nil && errB == nil && a == b
+}
+
+/*
+** Inspecting the stopped program
+ */
+func (s *Server) stackTrace(args stackTraceArguments) interface{} {
+	source := &Source{Name: filepath.Base(s.program), Path: s.program}
+
+	trace := s.debugger.Backtrace()
+	frames := []StackFrame{}
+
+	for i := args.StartFrame; i < len(trace); i++ {
+		if args.Levels > 0 && len(frames) == args.Levels {
+			break
+		}
+
+		frames = append(frames, StackFrame{
+			ID:     i,
+			Name:   trace[i].Function,
+			Source: source,
+			Line:   trace[i].Line,
+			Column: trace[i].Column,
+		})
+	}
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(trace)}
+}
+
+func (s *Server) scopes(frame int) (interface{}, error) {
+	if frame < 0 || frame >= len(s.debugger.Backtrace()) {
+		return nil, fmt.Errorf("unknown frame %d", frame)
+	}
+
+	scopes := []Scope{
+		{Name: "Locals", VariablesReference: s.reference(reference{kind: localsScope, call: frame})},
+		{Name: "Globals", VariablesReference: s.reference(reference{kind: globalsScope})},
+	}
+
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+// evaluate shows the value of a variable. Other expressions are not
+// supported.
+func (s *Server) evaluate(args evaluateArguments) (interface{}, error) {
+	name := strings.TrimSpace(args.Expression)
+
+	value, ok := s.debugger.Lookup(name, args.FrameID)
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %s", name)
+	}
+
+	v := s.variable(name, value)
+
+	return map[string]interface{}{
+		"result":             v.Value,
+		"type":               v.Type,
+		"variablesReference": v.VariablesReference,
+	}, nil
+}
+
+/*
+** Messages
+ */
+func (s *Server) respond(req request, body interface{}, err error) {
+	resp := response{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+
+	s.seq++
+	resp.Seq = s.seq
+	transport.WriteMessage(s.out, resp)
+}
+
+func (s *Server) event(name string, body interface{}) {
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+
+	s.seq++
+	transport.WriteMessage(s.out, event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+// output sends what the program prints to the client.
+type output struct {
+	server *Server
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.server.event("output", outputEvent{Category: "stdout", Output: string(p)})
+	return len(p), nil
+}
@@ -0,0 +1,103 @@
+package dap
+
+import (
+	"fmt"
+
+	"github.com/rhwilr/lemur/object"
+	"github.com/rhwilr/lemur/vm"
+)
+
+type scopeKind int
+
+const (
+	valueScope scopeKind = iota
+	localsScope
+	globalsScope
+)
+
+// reference is something the client can expand into variables: the locals
+// of a call, the globals or an array, hash or set. References are only valid
+// while the program is stopped.
+type reference struct {
+	kind  scopeKind
+	call  int
+	value object.Object
+}
+
+// reference returns the number the client uses to expand r.
+func (s *Server) reference(r reference) int {
+	s.references = append(s.references, r)
+	return len(s.references)
+}
+
+func (s *Server) variables(id int) ([]Variable, error) {
+	if id < 1 || id > len(s.references) {
+		return nil, fmt.Errorf("unknown variables reference %d", id)
+	}
+
+	r := s.references[id-1]
+	variables := []Variable{}
+
+	switch r.kind {
+	case localsScope:
+		for _, v := range s.debugger.Locals(r.call) {
+			variables = append(variables, s.variable(v.Name, v.Value))
+		}
+
+	case globalsScope:
+		for _, v := range s.debugger.Globals() {
+			variables = append(variables, s.variable(v.Name, v.Value))
+		}
+
+	case valueScope:
+		for _, v := range children(r.value) {
+			variables = append(variables, s.variable(v.Name, v.Value))
+		}
+	}
+
+	return variables, nil
+}
+
+// variable describes a value. Arrays, hashes and sets that are not empty
+// can be expanded.
+func (s *Server) variable(name string, value object.Object) Variable {
+	v := Variable{Name: name, Value: value.Inspect(), Type: string(value.Type())}
+
+	if str, ok := value.(*object.String); ok {
+		v.Value = fmt.Sprintf("%q", str.Value)
+	}
+
+	if len(children(value)) > 0 {
+		v.VariablesReference = s.reference(reference{kind: valueScope, value: value})
+	}
+
+	return v
+}
+
+// children returns the elements of an array or set or the pairs of a hash.
+func children(value object.Object) []vm.Variable {
+	var variables []vm.Variable
+
+	switch value := value.(type) {
+	case *object.Array:
+		for i, element := range value.Elements {
+			variables = append(variables, vm.Variable{Name: fmt.Sprintf("[%d]", i), Value: element})
+		}
+
+	case *object.Set:
+		for i, element := range value.Elements() {
+			variables = append(variables, vm.Variable{Name: fmt.Sprintf("[%d]", i), Value: element})
+		}
+
+	case *object.Hash:
+		for _, pair := range value.Pairs() {
+			name := pair.Key.Inspect()
+			if str, ok := pair.Key.(*object.String); ok {
+				name = fmt.Sprintf("%q", str.Value)
+			}
+			variables = append(variables, vm.Variable{Name: name, Value: pair.Value})
+		}
+	}
+
+	return variables
+}
@@ -0,0 +1,231 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rhwilr/lemur/vm"
+)
+
+const prompt = "(lemur) "
+
+const help = `break <line>    set a breakpoint, without a line list the breakpoints
+delete <line>   remove a breakpoint
+continue        run until the next breakpoint
+step            run to the next line, entering calls
+next            run to the next line of the current call
+finish          run until the current call returns
+backtrace       show the calls on the stack
+locals [<n>]    show the variables of call n, the innermost call by default
+globals         show the global variables
+print <name>    show the value of a variable
+list            show the source around the current line
+quit            end the program
+`
+
+type console struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+	lines   []string
+	last    string
+}
+
+// Console returns a Handler that reads commands from in and writes to out.
+// The source of the program is used to show the lines it stops at.
+func Console(in io.Reader, out io.Writer, source string) Handler {
+	c := &console{
+		scanner: bufio.NewScanner(in),
+		out:     out,
+		lines:   strings.Split(source, "\n"),
+	}
+
+	return c.stopped
+}
+
+func (c *console) stopped(d *Debugger, reason Reason) Mode {
+	pos := d.Position()
+
+	if reason == ReasonBreakpoint {
+		fmt.Fprintf(c.out, "breakpoint %s\n", pos)
+	} else {
+		fmt.Fprintf(c.out, "stopped %s\n", pos)
+	}
+	c.printLine(pos.Line, "")
+
+	for {
+		fmt.Fprint(c.out, prompt)
+		if !c.scanner.Scan() {
+			fmt.Fprintln(c.out)
+			return Quit
+		}
+
+		// An empty line repeats the last command
+		line := strings.TrimSpace(c.scanner.Text())
+		if line == "" {
+			line = c.last
+		}
+		c.last = line
+
+		if mode, resume := c.command(d, line); resume {
+			return mode
+		}
+	}
+}
+
+// command runs a command. It reports whether the program resumes.
+func (c *console) command(d *Debugger, line string) (Mode, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Continue, false
+	}
+
+	args := fields[1:]
+
+	switch fields[0] {
+	case "c", "continue":
+		return Continue, true
+	case "s", "step":
+		return Step, true
+	case "n", "next":
+		return Next, true
+	case "f", "finish":
+		return Finish, true
+	case "q", "quit":
+		return Quit, true
+
+	case "b", "break":
+		c.breakpoint(d, args)
+	case "d", "delete":
+		c.delete(d, args)
+	case "bt", "backtrace":
+		for i, frame := range d.Backtrace() {
+			fmt.Fprintf(c.out, "#%d %s\n", i, frame)
+		}
+	case "locals":
+		c.locals(d, args)
+	case "globals":
+		c.printVariables(d.Globals(), "no globals")
+	case "p", "print":
+		c.print(d, args)
+	case "l", "list":
+		c.list(d.Position().Line)
+	case "h", "help":
+		fmt.Fprint(c.out, help)
+
+	default:
+		fmt.Fprintf(c.out, "unknown command %q, try help\n", fields[0])
+	}
+
+	return Continue, false
+}
+
+func (c *console) breakpoint(d *Debugger, args []string) {
+	if len(args) == 0 {
+		breakpoints := d.Breakpoints()
+		if len(breakpoints) == 0 {
+			fmt.Fprintln(c.out, "no breakpoints")
+		}
+		for _, line := range breakpoints {
+			c.printLine(line, "")
+		}
+		return
+	}
+
+	line, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Fprintf(c.out, "invalid line %q\n", args[0])
+		return
+	}
+
+	if err := d.SetBreakpoint(line); err != nil {
+		fmt.Fprintln(c.out, err)
+		return
+	}
+
+	fmt.Fprintf(c.out, "breakpoint on line %d\n", line)
+}
+
+func (c *console) delete(d *Debugger, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(c.out, "usage: delete <line>")
+		return
+	}
+
+	line, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Fprintf(c.out, "invalid line %q\n", args[0])
+		return
+	}
+
+	if !d.ClearBreakpoint(line) {
+		fmt.Fprintf(c.out, "no breakpoint on line %d\n", line)
+		return
+	}
+
+	fmt.Fprintf(c.out, "removed breakpoint on line %d\n", line)
+}
+
+func (c *console) locals(d *Debugger, args []string) {
+	call := 0
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 || n >= len(d.Backtrace()) {
+			fmt.Fprintf(c.out, "no call %q\n", args[0])
+			return
+		}
+		call = n
+	}
+
+	c.printVariables(d.Locals(call), "no locals")
+}
+
+func (c *console) print(d *Debugger, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(c.out, "usage: print <name>")
+		return
+	}
+
+	value, ok := d.Lookup(args[0], 0)
+	if !ok {
+		fmt.Fprintf(c.out, "unknown variable %s\n", args[0])
+		return
+	}
+
+	fmt.Fprintf(c.out, "%s = %s\n", args[0], value.Inspect())
+}
+
+func (c *console) printVariables(variables []vm.Variable, empty string) {
+	if len(variables) == 0 {
+		fmt.Fprintln(c.out, empty)
+	}
+
+	for _, v := range variables {
+		fmt.Fprintf(c.out, "%s = %s\n", v.Name, v.Value.Inspect())
+	}
+}
+
+// list shows the lines around the current one.
+func (c *console) list(current int) {
+	for line := current - 3; line <= current+3; line++ {
+		if line < 1 || line > len(c.lines) {
+			continue
+		}
+
+		marker := ""
+		if line == current {
+			marker = "=>"
+		}
+		c.printLine(line, marker)
+	}
+}
+
+func (c *console) printLine(line int, marker string) {
+	if line < 1 || line > len(c.lines) {
+		return
+	}
+
+	fmt.Fprintf(c.out, "%2s %3d  %s\n", marker, line, strings.TrimRight(c.lines[line-1], "\r"))
+}
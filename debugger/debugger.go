@@ -0,0 +1,283 @@
+// Package debugger steps through programs running on the VM. It stops the
+// program at breakpoints and after steps and hands control to a Handler,
+// which inspects the program and decides how it continues.
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/object"
+	"github.com/rhwilr/lemur/vm"
+)
+
+// Mode tells the debugger how to continue after the program stopped.
+type Mode int
+
+const (
+	Continue Mode = iota // run until the next breakpoint
+	Step                 // stop at the next line, entering calls
+	Next                 // stop at the next line of the current call
+	Finish               // stop when the current call returns
+	Quit                 // end the program
+)
+
+// Reason tells why the program stopped.
+type Reason string
+
+const (
+	ReasonEntry      Reason = "entry"
+	ReasonBreakpoint Reason = "breakpoint"
+	ReasonStep       Reason = "step"
+	ReasonPause      Reason = "pause"
+)
+
+// Handler is called while the program is stopped. It returns how the program
+// continues.
+type Handler func(d *Debugger, reason Reason) Mode
+
+var errQuit = errors.New("debugger: program ended")
+
+type Debugger struct {
+	vm      *vm.VM
+	handler Handler
+
+	// lines holds the lines that have instructions
+	lines map[int]bool
+
+	// The breakpoints and pause requests may come from other goroutines
+	// while the program runs.
+	breakpoints     map[int]bool
+	breakpointsLock sync.Mutex
+	pause           int32
+
+	// mode is the last command, started at depth
+	mode  Mode
+	depth int
+
+	// current holds the line each call is on, starting with the outermost
+	// one
+	current []int
+
+	entry bool
+	quit  bool
+}
+
+// New attaches a debugger to the VM running the bytecode.
+func New(machine *vm.VM, bytecode *compiler.Bytecode, handler Handler) *Debugger {
+	d := &Debugger{
+		vm:          machine,
+		handler:     handler,
+		lines:       map[int]bool{},
+		breakpoints: map[int]bool{},
+	}
+
+	for _, entry := range bytecode.Lines {
+		d.lines[entry.Line] = true
+	}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			for _, entry := range fn.Lines {
+				d.lines[entry.Line] = true
+			}
+		}
+	}
+
+	machine.SetHook(d.hook)
+
+	return d
+}
+
+// Run runs the program until it ends. If stopOnEntry is set, the handler is
+// called before the first line is executed. Quitting the program is not an
+// error.
+func (d *Debugger) Run(stopOnEntry bool) error {
+	d.mode = Continue
+	if stopOnEntry {
+		d.mode = Step
+		d.entry = true
+	}
+
+	err := d.vm.Run()
+	if d.quit {
+		return nil
+	}
+
+	return err
+}
+
+func (d *Debugger) hook(machine *vm.VM) error {
+	pos := machine.Position()
+	depth := machine.Depth()
+
+	if pos.Line == 0 {
+		return nil
+	}
+
+	// A call that returned continues the line of its caller, so the line
+	// only changes within the same call.
+	if len(d.current) > depth {
+		d.current = d.current[:depth]
+	}
+	for len(d.current) < depth {
+		d.current = append(d.current, 0)
+	}
+	newLine := d.current[depth-1] != pos.Line
+	d.current[depth-1] = pos.Line
+
+	// Breakpoints and steps stop at the first instruction of a line, a
+	// pause at the next instruction.
+	reason, stop := ReasonPause, true
+	if !atomic.CompareAndSwapInt32(&d.pause, 1, 0) {
+		reason, stop = d.stops(pos.Line, depth, newLine)
+	}
+	if !stop {
+		return nil
+	}
+
+	d.mode = d.handler(d, reason)
+	d.depth = depth
+
+	if d.mode == Quit {
+		d.quit = true
+		return errQuit
+	}
+
+	return nil
+}
+
+// stops reports whether the program stops before the instruction. Steps
+// that leave a call stop in the middle of the caller's line.
+func (d *Debugger) stops(line, depth int, newLine bool) (Reason, bool) {
+	switch d.mode {
+	case Step:
+		if d.entry {
+			d.entry = false
+			return ReasonEntry, true
+		}
+		if newLine || depth < d.depth {
+			return ReasonStep, true
+		}
+
+	case Next:
+		if depth < d.depth || depth == d.depth && newLine {
+			return ReasonStep, true
+		}
+
+	case Finish:
+		if depth < d.depth {
+			return ReasonStep, true
+		}
+	}
+
+	if !newLine {
+		return "", false
+	}
+
+	d.breakpointsLock.Lock()
+	defer d.breakpointsLock.Unlock()
+
+	if d.breakpoints[line] {
+		return ReasonBreakpoint, true
+	}
+
+	return "", false
+}
+
+// Pause stops the running program before its next line. It may be called
+// from any goroutine.
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.pause, 1)
+}
+
+/*
+** Breakpoints
+ */
+
+// SetBreakpoint stops the program whenever it reaches the line. Breakpoints
+// may be changed from any goroutine.
+func (d *Debugger) SetBreakpoint(line int) error {
+	if !d.lines[line] {
+		return fmt.Errorf("no code on line %d", line)
+	}
+
+	d.breakpointsLock.Lock()
+	d.breakpoints[line] = true
+	d.breakpointsLock.Unlock()
+
+	return nil
+}
+
+// ClearBreakpoint removes the breakpoint on the line. It reports whether
+// there was one.
+func (d *Debugger) ClearBreakpoint(line int) bool {
+	d.breakpointsLock.Lock()
+	defer d.breakpointsLock.Unlock()
+
+	found := d.breakpoints[line]
+	delete(d.breakpoints, line)
+
+	return found
+}
+
+// Breakpoints returns the lines with breakpoints in order.
+func (d *Debugger) Breakpoints() []int {
+	d.breakpointsLock.Lock()
+	defer d.breakpointsLock.Unlock()
+
+	lines := make([]int, 0, len(d.breakpoints))
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+
+	return lines
+}
+
+/*
+** Inspecting the stopped program
+ */
+
+// Position returns the innermost call and the position the program stopped
+// at.
+func (d *Debugger) Position() vm.StackFrame {
+	return d.vm.Position()
+}
+
+// Backtrace returns the calls of the program, starting with the innermost
+// one.
+func (d *Debugger) Backtrace() []vm.StackFrame {
+	return d.vm.StackTrace()
+}
+
+// Locals returns the variables of a call, counted from the innermost one.
+func (d *Debugger) Locals(call int) []vm.Variable {
+	return d.vm.Locals(call)
+}
+
+// Globals returns the global variables.
+func (d *Debugger) Globals() []vm.Variable {
+	return d.vm.Globals()
+}
+
+// Lookup returns the value of a variable as seen from a call. Locals shadow
+// the globals.
+func (d *Debugger) Lookup(name string, call int) (object.Object, bool) {
+	for _, v := range d.vm.Locals(call) {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+
+	for _, v := range d.vm.Globals() {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+
+	return nil, false
+}
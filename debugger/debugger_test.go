@@ -0,0 +1,270 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/lexer"
+	"github.com/rhwilr/lemur/parser"
+	"github.com/rhwilr/lemur/vm"
+)
+
+const program = `let total = 0;
+function add(a, b) {
+  let sum = a + b;
+  return sum;
+}
+let counter = 1;
+total = add(counter, 2);
+total = add(total, 3);
+total;
+`
+
+func compile(t *testing.T, input string) (*vm.VM, *compiler.Bytecode) {
+	p := parser.New(lexer.NewWithFile(input, "main.lem"))
+	node := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	c := compiler.New()
+	if err := c.Compile(node); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := c.Bytecode()
+	return vm.New(bytecode), bytecode
+}
+
+type stop struct {
+	reason Reason
+	line   int
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		breakpoints []int
+		stopOnEntry bool
+		modes       []Mode
+		expected    []stop
+	}{
+		{nil, false, nil, []stop{}},
+		{[]int{3}, false, []Mode{Continue, Continue}, []stop{{ReasonBreakpoint, 3}, {ReasonBreakpoint, 3}}},
+		{nil, true, []Mode{Next, Next, Next, Next, Next, Continue}, []stop{{ReasonEntry, 1}, {ReasonStep, 2}, {ReasonStep, 6}, {ReasonStep, 7}, {ReasonStep, 8}, {ReasonStep, 9}}},
+		{nil, true, []Mode{Next, Next, Next, Step, Step, Step, Continue}, []stop{{ReasonEntry, 1}, {ReasonStep, 2}, {ReasonStep, 6}, {ReasonStep, 7}, {ReasonStep, 3}, {ReasonStep, 4}, {ReasonStep, 7}}},
+		{[]int{3}, false, []Mode{Finish, Continue}, []stop{{ReasonBreakpoint, 3}, {ReasonStep, 7}, {ReasonBreakpoint, 3}}},
+		{[]int{4}, false, []Mode{Next, Next, Quit}, []stop{{ReasonBreakpoint, 4}, {ReasonStep, 7}, {ReasonStep, 8}}},
+	}
+
+	for i, tt := range tests {
+		machine, bytecode := compile(t, program)
+
+		stops := []stop{}
+		d := New(machine, bytecode, func(d *Debugger, reason Reason) Mode {
+			stops = append(stops, stop{reason, d.Position().Line})
+			if len(stops) > len(tt.modes) {
+				return Quit
+			}
+			return tt.modes[len(stops)-1]
+		})
+
+		for _, line := range tt.breakpoints {
+			if err := d.SetBreakpoint(line); err != nil {
+				t.Fatalf("test %d: %s", i, err)
+			}
+		}
+
+		if err := d.Run(tt.stopOnEntry); err != nil {
+			t.Fatalf("test %d: unexpected error: %s", i, err)
+		}
+
+		if len(stops) != len(tt.expected) {
+			t.Errorf("test %d: wrong stops. want=%v, got=%v", i, tt.expected, stops)
+			continue
+		}
+		for j, s := range tt.expected {
+			if stops[j] != s {
+				t.Errorf("test %d: wrong stop %d. want=%v, got=%v", i, j, s, stops[j])
+			}
+		}
+	}
+}
+
+func TestCallsOnLine(t *testing.T) {
+	calls := `function f(x) {
+  return x;
+}
+let y = f(1) + f(2);
+y;
+`
+	fact := `function fact(n) {
+  if (n < 2) { return 1; }
+  return n * fact(n - 1);
+}
+fact(3);
+`
+
+	tests := []struct {
+		input       string
+		breakpoints []int
+		modes       []Mode
+		expected    []stop
+	}{
+		{calls, []int{4}, []Mode{Continue}, []stop{{ReasonBreakpoint, 4}}},
+		{calls, []int{4}, []Mode{Next, Continue}, []stop{{ReasonBreakpoint, 4}, {ReasonStep, 5}}},
+		{calls, []int{2, 4}, []Mode{Continue, Continue, Continue}, []stop{{ReasonBreakpoint, 4}, {ReasonBreakpoint, 2}, {ReasonBreakpoint, 2}}},
+		{fact, []int{3}, []Mode{Continue, Continue}, []stop{{ReasonBreakpoint, 3}, {ReasonBreakpoint, 3}}},
+		{fact, []int{3}, []Mode{Continue, Finish, Continue}, []stop{{ReasonBreakpoint, 3}, {ReasonBreakpoint, 3}, {ReasonStep, 3}}},
+	}
+
+	for i, tt := range tests {
+		machine, bytecode := compile(t, tt.input)
+
+		stops := []stop{}
+		d := New(machine, bytecode, func(d *Debugger, reason Reason) Mode {
+			stops = append(stops, stop{reason, d.Position().Line})
+			if len(stops) > len(tt.modes) {
+				return Quit
+			}
+			return tt.modes[len(stops)-1]
+		})
+
+		for _, line := range tt.breakpoints {
+			if err := d.SetBreakpoint(line); err != nil {
+				t.Fatalf("test %d: %s", i, err)
+			}
+		}
+
+		if err := d.Run(false); err != nil {
+			t.Fatalf("test %d: unexpected error: %s", i, err)
+		}
+
+		if len(stops) != len(tt.expected) {
+			t.Errorf("test %d: wrong stops. want=%v, got=%v", i, tt.expected, stops)
+			continue
+		}
+		for j, s := range tt.expected {
+			if stops[j] != s {
+				t.Errorf("test %d: wrong stop %d. want=%v, got=%v", i, j, s, stops[j])
+			}
+		}
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	machine, bytecode := compile(t, program)
+	d := New(machine, bytecode, func(*Debugger, Reason) Mode { return Continue })
+
+	if err := d.SetBreakpoint(5); err == nil {
+		t.Errorf("expected an error for a line without code")
+	}
+
+	d.SetBreakpoint(8)
+	d.SetBreakpoint(3)
+	if got := d.Breakpoints(); len(got) != 2 || got[0] != 3 || got[1] != 8 {
+		t.Errorf("wrong breakpoints: %v", got)
+	}
+
+	if !d.ClearBreakpoint(3) || d.ClearBreakpoint(3) {
+		t.Errorf("expected the breakpoint to be removed once")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	input := `let total = 0;
+function outer(x) {
+  let items = [x, x * 2];
+  let inner = function(y) {
+    let z = y + 1;
+    return z + len(items);
+  };
+  return inner(x);
+}
+outer(5);
+`
+	machine, bytecode := compile(t, input)
+
+	stopped := false
+	d := New(machine, bytecode, func(d *Debugger, reason Reason) Mode {
+		stopped = true
+
+		trace := d.Backtrace()
+		if len(trace) != 3 || trace[0].Function != "inner" || trace[1].Function != "outer" || trace[2].Function != "main" {
+			t.Errorf("wrong backtrace: %v", trace)
+		}
+		if trace[1].Line != 8 {
+			t.Errorf("wrong line of outer. want=8, got=%d", trace[1].Line)
+		}
+
+		expected := map[string]string{"y": "5", "items": "[5, 10]"}
+		locals := d.Locals(0)
+		if len(locals) != len(expected) {
+			t.Errorf("wrong locals: %v", locals)
+		}
+		for _, v := range locals {
+			if expected[v.Name] != v.Value.Inspect() {
+				t.Errorf("wrong local %s. want=%s, got=%s", v.Name, expected[v.Name], v.Value.Inspect())
+			}
+		}
+
+		if outer := d.Locals(1); len(outer) != 3 {
+			t.Errorf("wrong locals of outer: %v", outer)
+		}
+
+		for name, want := range map[string]string{"y": "5", "total": "0", "outer": "function outer(x)"} {
+			value, ok := d.Lookup(name, 0)
+			if !ok || value.Inspect() != want {
+				t.Errorf("wrong value of %s. want=%s, got=%v", name, want, value)
+			}
+		}
+		if _, ok := d.Lookup("x", 0); ok {
+			t.Errorf("x is not visible in inner")
+		}
+
+		return Continue
+	})
+
+	d.SetBreakpoint(5)
+	if err := d.Run(false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !stopped {
+		t.Fatalf("breakpoint was not hit")
+	}
+}
+
+func TestConsole(t *testing.T) {
+	machine, bytecode := compile(t, program)
+
+	commands := "break 3\nbreak 5\ncontinue\nprint sum\nprint a\nbacktrace\nlocals\nglobals\nfinish\n\nquit\n"
+	var out bytes.Buffer
+	d := New(machine, bytecode, Console(strings.NewReader(commands), &out, program))
+
+	if err := d.Run(true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []string{
+		"stopped at main (main.lem:1:13)",
+		"breakpoint on line 3",
+		"no code on line 5",
+		"breakpoint at add (main.lem:3:13)",
+		"unknown variable sum",
+		"a = 1",
+		"#0 at add (main.lem:3:13)\n#1 at main (main.lem:7:9)",
+		"a = 1\nb = 2\n",
+		"total = 0\nadd = function add(a, b)\ncounter = 1\n",
+		"stopped at main (main.lem:7:7)",
+		"breakpoint at add (main.lem:3:13)",
+	}
+
+	output := out.String()
+	for _, want := range expected {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output:\n%s", want, output)
+		}
+	}
+}
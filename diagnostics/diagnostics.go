@@ -0,0 +1,181 @@
+// Package diagnostics describes problems found in source code. The lexer,
+// parser, compiler and checker report them in the same form, so they can be
+// printed for humans or emitted as JSON for editors and CI.
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/rhwilr/lemur/token"
+)
+
+// Severity tells how serious a diagnostic is.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Info
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Info:
+		return "info"
+	default:
+		return "error"
+	}
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Code identifies the kind of a diagnostic. Codes are stable, tools may rely
+// on them. The first letter names the phase that reports it: L for the lexer,
+// P for the parser, C for the compiler, K for the checker and T for the type
+// checker.
+type Code string
+
+const (
+	UnterminatedString  Code = "L001"
+	UnterminatedComment Code = "L002"
+	UnterminatedChar    Code = "L003"
+
+	UnexpectedToken      Code = "P001"
+	ExpectedExpression   Code = "P002"
+	MissingSemicolon     Code = "P003"
+	IllegalCharacter     Code = "P004"
+	InvalidLiteral       Code = "P005"
+	InvalidAssignment    Code = "P006"
+	InvalidParameter     Code = "P007"
+	InvalidComprehension Code = "P008"
+	MisplacedYield       Code = "P009"
+	InvalidSpawn         Code = "P010"
+
+	CompileError       Code = "C000"
+	UndefinedVariable  Code = "C001"
+	ConstantAssignment Code = "C002"
+	Redeclaration      Code = "C003"
+	UnknownOperator    Code = "C004"
+	MisplacedMacro     Code = "C005"
+
+	UnusedVariable  Code = "K001"
+	UnusedParameter Code = "K002"
+	ShadowedBuiltin Code = "K003"
+	UnreachableCode Code = "K004"
+	WrongArity      Code = "K005"
+
+	TypeMismatch    Code = "T001"
+	UnknownType     Code = "T002"
+	InvalidOperands Code = "T003"
+)
+
+// Span is the part of the source a diagnostic refers to. End points behind the
+// last character.
+type Span struct {
+	Start token.TokenPosition
+	End   token.TokenPosition
+}
+
+// TokenSpan returns the span covered by tok in the source.
+func TokenSpan(tok token.Token) Span {
+	length := len([]rune(tok.Literal))
+
+	// The literal of strings and chars does not include the quotes.
+	if tok.Type == token.STRING || tok.Type == token.CHAR {
+		length += 2
+	}
+
+	end := tok.Position
+	end.Column += length
+
+	return Span{Start: tok.Position, End: end}
+}
+
+// Diagnostic is a single problem found in the source.
+type Diagnostic struct {
+	Code     Code
+	Severity Severity
+	Span     Span
+	Message  string
+
+	// Hint is an optional suggestion how to fix the problem.
+	Hint string
+}
+
+// Pos returns the position where the diagnostic starts.
+func (d Diagnostic) Pos() token.TokenPosition {
+	return d.Span.Start
+}
+
+// String returns the diagnostic as `file:line:col: severity[code]: message`.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s[%s]: %s", d.Span.Start, d.Severity, d.Code, d.Message)
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type jsonDiagnostic struct {
+	Code     Code         `json:"code"`
+	Severity Severity     `json:"severity"`
+	File     string       `json:"file,omitempty"`
+	Start    jsonPosition `json:"start"`
+	End      jsonPosition `json:"end"`
+	Message  string       `json:"message"`
+	Hint     string       `json:"hint,omitempty"`
+}
+
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonDiagnostic{
+		Code:     d.Code,
+		Severity: d.Severity,
+		File:     d.Span.Start.File,
+		Start:    jsonPosition{Line: d.Span.Start.Line, Column: d.Span.Start.Column},
+		End:      jsonPosition{Line: d.Span.End.Line, Column: d.Span.End.Column},
+		Message:  d.Message,
+		Hint:     d.Hint,
+	})
+}
+
+// WriteJSON writes the diagnostics as JSON lines, one object per diagnostic.
+func WriteJSON(w io.Writer, diagnostics []Diagnostic) error {
+	encoder := json.NewEncoder(w)
+	for _, d := range diagnostics {
+		if err := encoder.Encode(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HasErrors reports whether any of the diagnostics is an error.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sort orders the diagnostics by their position in the source.
+func Sort(diagnostics []Diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i].Span.Start, diagnostics[j].Span.Start
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
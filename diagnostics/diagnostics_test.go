@@ -0,0 +1,86 @@
+package diagnostics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rhwilr/lemur/token"
+)
+
+func TestTokenSpan(t *testing.T) {
+	tests := []struct {
+		tok         token.Token
+		expectedEnd int
+	}{
+		{token.Token{Type: token.IDENT, Literal: "foo", Position: token.TokenPosition{Line: 1, Column: 5}}, 8},
+		{token.Token{Type: token.STRING, Literal: "foo", Position: token.TokenPosition{Line: 1, Column: 5}}, 10},
+		{token.Token{Type: token.CHAR, Literal: "ä", Position: token.TokenPosition{Line: 1, Column: 1}}, 4},
+		{token.Token{Type: token.EOF, Literal: "", Position: token.TokenPosition{Line: 2, Column: 1}}, 1},
+	}
+
+	for _, tt := range tests {
+		span := TokenSpan(tt.tok)
+		if span.Start != tt.tok.Position {
+			t.Errorf("wrong start. want=%s, got=%s", tt.tok.Position, span.Start)
+		}
+		if span.End.Line != tt.tok.Position.Line || span.End.Column != tt.expectedEnd {
+			t.Errorf("wrong end for %q. want column %d, got=%s", tt.tok.Literal, tt.expectedEnd, span.End)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	found := []Diagnostic{
+		{
+			Code:     MissingSemicolon,
+			Severity: Error,
+			Span: Span{
+				Start: token.TokenPosition{File: "main.lem", Line: 1, Column: 10},
+				End:   token.TokenPosition{File: "main.lem", Line: 1, Column: 10},
+			},
+			Message: "Expected token ';', found end of input",
+			Hint:    "add ';' at the end of the statement",
+		},
+		{
+			Code:     UnterminatedComment,
+			Severity: Warning,
+			Span: Span{
+				Start: token.TokenPosition{Line: 2, Column: 1},
+				End:   token.TokenPosition{Line: 2, Column: 4},
+			},
+			Message: "unterminated comment",
+		},
+	}
+
+	var out bytes.Buffer
+	if err := WriteJSON(&out, found); err != nil {
+		t.Fatalf("WriteJSON failed: %s", err)
+	}
+
+	expected := `{"code":"P003","severity":"error","file":"main.lem","start":{"line":1,"column":10},"end":{"line":1,"column":10},"message":"Expected token ';', found end of input","hint":"add ';' at the end of the statement"}
+{"code":"L002","severity":"warning","start":{"line":2,"column":1},"end":{"line":2,"column":4},"message":"unterminated comment"}
+`
+	if out.String() != expected {
+		t.Errorf("wrong output.\nwant=%s\ngot=%s", expected, out.String())
+	}
+}
+
+func TestSort(t *testing.T) {
+	found := []Diagnostic{
+		{Message: "c", Span: Span{Start: token.TokenPosition{Line: 2, Column: 1}}},
+		{Message: "b", Span: Span{Start: token.TokenPosition{Line: 1, Column: 7}}},
+		{Message: "a", Span: Span{Start: token.TokenPosition{Line: 1, Column: 3}}},
+	}
+
+	Sort(found)
+
+	for i, expected := range []string{"a", "b", "c"} {
+		if found[i].Message != expected {
+			t.Errorf("wrong order at %d. want=%s, got=%s", i, expected, found[i].Message)
+		}
+	}
+
+	if !HasErrors(found) {
+		t.Errorf("expected HasErrors to report the errors")
+	}
+}
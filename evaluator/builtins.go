@@ -14,4 +14,44 @@ var builtins = map[string]*object.Builtin{
 	"rest":    object.GetBuiltinByName("rest"),
 	"push":    object.GetBuiltinByName("push"),
 	"env":     object.GetBuiltinByName("env"),
+	"next":    object.GetBuiltinByName("next"),
+	"channel": object.GetBuiltinByName("channel"),
+	"send":    object.GetBuiltinByName("send"),
+	"recv":    object.GetBuiltinByName("recv"),
+	"close":   object.GetBuiltinByName("close"),
+	"select":  object.GetBuiltinByName("select"),
+	"wait":    object.GetBuiltinByName("wait"),
+
+	"setTimeout":    object.GetBuiltinByName("setTimeout"),
+	"setInterval":   object.GetBuiltinByName("setInterval"),
+	"clearTimeout":  object.GetBuiltinByName("clearTimeout"),
+	"clearInterval": object.GetBuiltinByName("clearInterval"),
+	"sleep":         object.GetBuiltinByName("sleep"),
+
+	"ord": object.GetBuiltinByName("ord"),
+	"chr": object.GetBuiltinByName("chr"),
+
+	"keys": object.GetBuiltinByName("keys"),
+
+	"add":          object.GetBuiltinByName("add"),
+	"remove":       object.GetBuiltinByName("remove"),
+	"has":          object.GetBuiltinByName("has"),
+	"union":        object.GetBuiltinByName("union"),
+	"intersection": object.GetBuiltinByName("intersection"),
+	"difference":   object.GetBuiltinByName("difference"),
+
+	"freeze": object.GetBuiltinByName("freeze"),
+	"frozen": object.GetBuiltinByName("frozen"),
+
+	"type":   object.GetBuiltinByName("type"),
+	"arity":  object.GetBuiltinByName("arity"),
+	"name":   object.GetBuiltinByName("name"),
+	"params": object.GetBuiltinByName("params"),
+
+	"error":        object.GetBuiltinByName("error"),
+	"isError":      object.GetBuiltinByName("isError"),
+	"errorMessage": object.GetBuiltinByName("errorMessage"),
+	"errorData":    object.GetBuiltinByName("errorData"),
+	"wrap":         object.GetBuiltinByName("wrap"),
+	"unwrap":       object.GetBuiltinByName("unwrap"),
 }
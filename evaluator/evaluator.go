@@ -8,16 +8,33 @@ import (
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
+// Eval evaluates the node. Raised errors are marked with the position of the
+// innermost node that has one.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := eval(node, env)
+
+	if err, ok := result.(*object.Error); ok && err.Raised && err.Position.Line == 0 {
+		err.Position = node.Pos()
+	}
+
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
-		return evalProgram(node.Statements, env)
+		result := evalProgram(node.Statements, env)
+		if isError(result) {
+			return result
+		}
+
+		return evalEventLoop(result, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
@@ -33,12 +50,31 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalBlockStatements(node.Statements, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.BigIntegerLiteral:
+		return &object.BigInteger{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.CharLiteral:
+		return &object.Char{Value: node.Value}
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
+	case *ast.SetLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+
+		set, err := object.NewSet(elements...)
+		if err != nil {
+			return newError(err.Error())
+		}
+		return set
+	case *ast.ArrayComprehension:
+		return evalArrayComprehension(node, env)
+	case *ast.HashComprehension:
+		return evalHashComprehension(node, env)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
@@ -92,6 +128,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return val
 		}
 
+		if node.Freeze {
+			object.Freeze(val)
+		}
+
 		env.DefineConstant(node.Name.Value, val)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
@@ -110,7 +150,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		body := node.Body
 		defaults := node.Defaults
 
-		function := &object.Function{Parameters: params, Env: env, Body: body, Defaults: defaults}
+		function := &object.Function{Name: node.Name, Parameters: params, Env: env, Body: body, Defaults: defaults, Generator: node.Generator}
 
 		// When the Define flag is set, the function should be registered in the env.
 		if (node.Define) {
@@ -122,7 +162,50 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 
 		return function
+	case *ast.YieldExpression:
+		var value object.Object = NULL
+		if node.Value != nil {
+			value = Eval(node.Value, env)
+			if isError(value) {
+				return value
+			}
+		}
+
+		if !env.Yield(value) {
+			return newError("yield outside of a generator")
+		}
+
+		return NULL
+	case *ast.SpawnExpression:
+		function := Eval(node.Call.Function, env)
+		if isError(function) {
+			return function
+		}
+
+		args := evalExpressions(node.Call.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+
+		return object.NewTask(func() (object.Object, error) {
+			result := applyFunction(function, args)
+			if isError(result) {
+				return nil, fmt.Errorf("%s", result.(*object.Error).Message)
+			}
+
+			return result, nil
+		})
+	case *ast.MacroLiteral:
+		return newError("macros can only be defined with a let statement at the top level")
 	case *ast.CallExpression:
+		if node.Function.TokenLiteral() == "quote" {
+			if len(node.Arguments) != 1 {
+				return newError("wrong number of arguments to `quote`. got=%d, want=1", len(node.Arguments))
+			}
+
+			return quote(node.Arguments[0], env)
+		}
+
 		function := Eval(node.Function, env)
 		if isError(function) {
 			return function
@@ -162,10 +245,10 @@ func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 	for _, statement := range stmts {
 		result = Eval(statement, env)
 
-		switch result := result.(type) {
-		case *object.ReturnValue:
-			return result.Value
-		case *object.Error:
+		if returnValue, ok := result.(*object.ReturnValue); ok {
+			return returnValue.Value
+		}
+		if isError(result) {
 			return result
 		}
 	}
@@ -180,8 +263,7 @@ func evalBlockStatements(stmts []ast.Statement, env *object.Environment) object.
 		result = Eval(statement, env)
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if result.Type() == object.RETURN_VALUE_OBJ || isError(result) {
 				return result
 			}
 		}
@@ -191,29 +273,127 @@ func evalBlockStatements(stmts []ast.Statement, env *object.Environment) object.
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+		value := Eval(pair.Value, env)
+		if isError(value) {
+			return value
 		}
 
-		value := Eval(valueNode, env)
+		if err := hash.Set(key, value); err != nil {
+			return newError(err.Error())
+		}
+	}
+
+	return hash
+}
+
+func evalArrayComprehension(node *ast.ArrayComprehension, env *object.Environment) object.Object {
+	elements := []object.Object{}
+
+	err := evalComprehension(node.Variables, node.Iterable, node.Condition, env, func(scope *object.Environment) object.Object {
+		element := Eval(node.Element, scope)
+		if isError(element) {
+			return element
+		}
+
+		elements = append(elements, element)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+func evalHashComprehension(node *ast.HashComprehension, env *object.Environment) object.Object {
+	hash := object.NewHash()
+
+	err := evalComprehension(node.Variables, node.Iterable, node.Condition, env, func(scope *object.Environment) object.Object {
+		key := Eval(node.Key, scope)
+		if isError(key) {
+			return key
+		}
+
+		value := Eval(node.Value, scope)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		if err := hash.Set(key, value); err != nil {
+			return newError(err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
+}
+
+// evalComprehension iterates over the iterable, binds the comprehension
+// variables in a new scope and calls yield for every element that passes the
+// condition. Errors are returned, a nil result means success.
+func evalComprehension(variables []*ast.Identifier, iterable ast.Expression, condition ast.Expression, env *object.Environment, yield func(*object.Environment) object.Object) object.Object {
+	obj := Eval(iterable, env)
+	if isError(obj) {
+		return obj
+	}
+
+	iterator, ok := object.NewIterator(obj)
+	if !ok {
+		return newError("object is not iterable: %s", obj.Type())
+	}
+
+	for {
+		scope := object.NewEnclosedEnvironment(env)
+
+		if len(variables) == 2 {
+			key, value, ok := iterator.Next()
+			if !ok {
+				break
+			}
+
+			scope.DefineVariable(variables[0].Value, key)
+			scope.DefineVariable(variables[1].Value, value)
+		} else {
+			element, ok := iterator.NextElement()
+			if !ok {
+				break
+			}
+
+			scope.DefineVariable(variables[0].Value, element)
+		}
+
+		if condition != nil {
+			result := Eval(condition, scope)
+			if isError(result) {
+				return result
+			}
+
+			if !isTruthy(result) {
+				continue
+			}
+		}
+
+		if err := yield(scope); err != nil {
+			return err
+		}
+	}
+
+	if err := iterator.Err(); err != nil {
+		return newError("%s", err.Error())
+	}
+
+	return nil
 }
 
 func evalPrefixExpression(operator string, right object.Object) object.Object {
@@ -229,14 +409,18 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
-	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+	case object.IsInteger(left) && object.IsInteger(right):
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
+	case left.Type() == object.CHAR_OBJ && right.Type() == object.CHAR_OBJ:
+		return evalCharInfixExpression(operator, left, right)
+	case operator == "+" && isText(left) && isText(right):
+		return &object.String{Value: left.Inspect() + right.Inspect()}
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equals(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equals(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
@@ -286,40 +470,67 @@ func evalPostfixExpression(env *object.Environment, operator string, node *ast.P
 		return newError("%s is unknown", node.Name.TokenLiteral())
 	}
 
+	var arithmetic string
 	switch operator {
 	case "++":
-		switch arg := val.(type) {
-		case *object.Integer:
-			v := arg.Value
-			_, err := env.Set(node.Name.TokenLiteral(), &object.Integer{Value: v + 1})
-			if err != nil {
-				return newError(err.Error())
-			}
-			return arg
-		default:
-			return newError("%s is not an int", node.Name.TokenLiteral())
-
-		}
+		arithmetic = "+"
 	case "--":
-		switch arg := val.(type) {
-		case *object.Integer:
-			v := arg.Value
-			_, err := env.Set(node.Name.TokenLiteral(), &object.Integer{Value: v - 1})
-			if err != nil {
-				return newError(err.Error())
-			}
-			return arg
-		default:
-			return newError("%s is not an int", node.Name.TokenLiteral())
-		}
+		arithmetic = "-"
 	default:
 		return newError("unknown operator: %s", operator)
 	}
+
+	if !object.IsInteger(val) {
+		return newError("%s is not an int", node.Name.TokenLiteral())
+	}
+
+	result, err := object.IntegerOperation(arithmetic, val, &object.Integer{Value: 1})
+	if err != nil {
+		return newError(err.Error())
+	}
+
+	_, err = env.Set(node.Name.TokenLiteral(), result)
+	if err != nil {
+		return newError(err.Error())
+	}
+	return val
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+	switch operator {
+	case "==":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) != 0)
+	case "<":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) >= 0)
+	case "+", "+=", "-", "-=", "*", "*=", "/", "/=":
+		result, err := object.IntegerOperation(operator[:1], left, right)
+		if err != nil {
+			return newError(err.Error())
+		}
+		return result
+	case "..", "..<":
+		leftVal, leftOk := left.(*object.Integer)
+		rightVal, rightOk := right.(*object.Integer)
+		if !leftOk || !rightOk {
+			return newError("range bounds out of range: %s %s %s", left.Inspect(), operator, right.Inspect())
+		}
+		return &object.Range{Start: leftVal.Value, End: rightVal.Value, Inclusive: operator == ".."}
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
 
 	switch operator {
 	case "==":
@@ -334,22 +545,16 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 		return nativeBoolToBooleanObject(leftVal <= rightVal)
 	case ">=":
 		return nativeBoolToBooleanObject(leftVal >= rightVal)
-	case "+", "+=":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-", "-=":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*", "*=":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/", "/=":
-		return &object.Integer{Value: leftVal / rightVal}
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
+func evalCharInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.Char).Value
+	rightVal := right.(*object.Char).Value
 
 	switch operator {
 	case "==":
@@ -364,13 +569,16 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 		return nativeBoolToBooleanObject(leftVal <= rightVal)
 	case ">=":
 		return nativeBoolToBooleanObject(leftVal >= rightVal)
-	case "+":
-		return &object.String{Value: leftVal + rightVal}
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+// isText reports whether obj is a String or a Char, which can be concatenated.
+func isText(obj object.Object) bool {
+	return obj.Type() == object.STRING_OBJ || obj.Type() == object.CHAR_OBJ
+}
+
 func evalBangOperatorExpression(right object.Object) object.Object {
 	switch isTruthy(right) {
 	case false:
@@ -381,12 +589,11 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	if !object.IsInteger(right) {
 		return newError("unknown operator: -%s", right.Type())
 	}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return object.NegateInteger(right)
 }
 
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
@@ -413,6 +620,8 @@ func evalIndexExpression(left, index object.Object) object.Object {
 		return evalHashIndexExpression(left, index)
 	case left.Type() == object.STRING_OBJ:
 		return evalStringIndexExpression(left, index)
+	case left.Type() == object.RANGE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalRangeIndexExpression(left, index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -433,32 +642,36 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+	value, ok, err := hashObject.Get(index)
+	if err != nil {
+		return newError(err.Error())
 	}
-
-	pair, ok := hashObject.Pairs[key.HashKey()]
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return value
 }
 
 func evalStringIndexExpression(stringObj, index object.Object) object.Object {
-	str := stringObj.(*object.String).Value
+	str := stringObj.(*object.String)
 	idx := index.(*object.Integer).Value
-	max := int64(len(str) - 1)
 
-	if idx < 0 || idx > max {
+	ch, ok := str.At(idx)
+	if !ok {
 		return NULL
 	}
 
-	chars := []rune(str)
-	ret := chars[idx]
+	return &object.String{Value: string(ch)}
+}
 
-	return &object.String{Value: string(ret)}
+func evalRangeIndexExpression(rangeObj, index object.Object) object.Object {
+	value, ok := rangeObj.(*object.Range).At(index.(*object.Integer).Value)
+	if !ok {
+		return NULL
+	}
+
+	return value
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
@@ -467,6 +680,12 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	}
 
 	if builtin, ok := builtins[node.Value]; ok {
+		if loop := env.EventLoop(); loop != nil {
+			if bound := object.EventLoopBuiltin(node.Value, loop); bound != nil {
+				return bound
+			}
+		}
+
 		return builtin
 	}
 
@@ -491,6 +710,10 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
 		extendedEnv := extendFunctionEnv(fn, args)
+		if fn.Generator {
+			return newGenerator(fn, extendedEnv)
+		}
+
 		evaluated := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
@@ -503,6 +726,67 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
+// newGenerator runs the body of a generator function in its own goroutine.
+// Every yield hands the value over to the caller of Next and blocks until the
+// generator is resumed again. A generator that is not run to completion keeps
+// its goroutine parked until the program exits.
+func newGenerator(fn *object.Function, env *object.Environment) *object.Generator {
+	values := make(chan object.Object)
+	resume := make(chan struct{})
+	started := false
+
+	var result object.Object
+
+	env.SetYield(func(value object.Object) {
+		values <- value
+		<-resume
+	})
+
+	return object.NewGenerator(func() (object.Object, bool, error) {
+		if !started {
+			started = true
+			go func() {
+				result = Eval(fn.Body, env)
+				close(values)
+			}()
+		} else {
+			resume <- struct{}{}
+		}
+
+		value, ok := <-values
+		if !ok {
+			if isError(result) {
+				return nil, false, fmt.Errorf("%s", result.(*object.Error).Message)
+			}
+
+			return nil, false, nil
+		}
+
+		return value, true, nil
+	})
+}
+
+// evalEventLoop runs the callbacks of all timers once the program is done. The
+// result of the last callback becomes the result of the program.
+func evalEventLoop(result object.Object, env *object.Environment) object.Object {
+	loop := env.EventLoop()
+	if loop == nil {
+		return result
+	}
+
+	// A failing callback stops the loop and its error becomes the result
+	loop.Run(func(callback object.Object) error {
+		result = unwrapReturnValue(applyFunction(callback, []object.Object{}))
+		if isError(result) {
+			return fmt.Errorf("%s", result.(*object.Error).Message)
+		}
+
+		return nil
+	})
+
+	return result
+}
+
 func evalAssignStatement(a *ast.AssignStatement, env *object.Environment) (val object.Object) {
 	evaluated := Eval(a.Value, env)
 	if isError(evaluated) {
@@ -590,7 +874,7 @@ func evalWhileLoopExpression(fle *ast.WhileLoopExpression, env *object.Environme
 		if isTruthy(condition) {
 			rt := Eval(fle.Consequence, env)
 
-			if !isError(rt) && (rt.Type() == object.RETURN_VALUE_OBJ || rt.Type() == object.ERROR_OBJ) {
+			if rt != nil && (rt.Type() == object.RETURN_VALUE_OBJ || isError(rt)) {
 				return rt
 			}
 		} else {
@@ -635,6 +919,11 @@ func isTruthy(obj object.Object) bool {
 		return false
 	}
 
+	// An empty set is falsy
+	if set, ok := obj.(*object.Set); ok {
+		return set.Len() != 0
+	}
+
 	switch obj {
 	case NULL:
 		return false
@@ -656,13 +945,12 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 }
 
 func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+	return &object.Error{Message: fmt.Sprintf(format, a...), Raised: true}
 }
 
+// isError reports whether obj is a raised error that stops the evaluation.
+// Error values returned by builtins are passed on like any other value.
 func isError(obj object.Object) bool {
-	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
-	}
-
-	return false
+	err, ok := obj.(*object.Error)
+	return ok && err.Raised
 }
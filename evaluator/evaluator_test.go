@@ -1,6 +1,8 @@
 package evaluator
 
 import (
+	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/eventloop"
 	"github.com/rhwilr/lemur/lexer"
 	"github.com/rhwilr/lemur/object"
 	"github.com/rhwilr/lemur/parser"
@@ -27,6 +29,7 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"0xFF + 0o10 + 0b1 + 1_000", 1264},
 	}
 
 	for _, tt := range tests {
@@ -35,6 +38,36 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"100000000000000000000 / 10000000000", "10000000000"},
+		{"let a = 9223372036854775807; a++; a", "9223372036854775808"},
+		{"let a = 9223372036854775807; a += 10; a", "9223372036854775817"},
+		{"100000000000000000000 > 9223372036854775807", "true"},
+		{"100000000000000000000 == 100000000000000000000", "true"},
+		{"100000000000000000000 - 100000000000000000000 == 0", "true"},
+		{`{100000000000000000000: "big"}[100000000000000000000]`, "big"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s (%T)",
+				tt.input, tt.expected, evaluated.Inspect(), evaluated)
+		}
+	}
+
+	integer := testEval("100000000000000000000 - 99999999999999999999")
+	testIntegerObject(t, integer, 1)
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -205,6 +238,10 @@ func TestErrorHandling(t *testing.T) {
 			"5 + true;",
 			"type mismatch: INTEGER + BOOLEAN",
 		},
+		{
+			"5 / 0;",
+			"division by zero",
+		},
 		{
 			"5 + true; 5;",
 			"type mismatch: INTEGER + BOOLEAN",
@@ -300,6 +337,34 @@ if (10 > 1) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedPosition string
+	}{
+		{"5 + true;", "1:3"},
+		{"let a = 1;\nlet b = a +\n  c;", "3:3"},
+		{"let f = function(x) {\n  x - \"a\"\n};\nf(1);", "2:5"},
+		{"if (true) {\n  -true\n}", "2:3"},
+		{"let a = 1;\nlet a = 2;", "2:1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Position.String() != tt.expectedPosition {
+			t.Errorf("wrong error position for %q. expected=%q, got=%q",
+				tt.input, tt.expectedPosition, errObj.Position)
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -431,6 +496,365 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestArrowFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let double = x => x * 2; double(5);", 10},
+		{"let add = (a, b) => a + b; add(5, 5);", 10},
+		{"let add = (a, b = 3) => { a + b }; add(5);", 8},
+		{"(() => 7)()", 7},
+		{"let adder = x => y => x + y; adder(2)(3);", 5},
+		{"let fib = n => if (n < 2) { n } else { fib(n - 1) + fib(n - 2) }; fib(10);", 55},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let g = function() { yield 1; yield 2; }(); next(g)", "1"},
+		{"let g = function() { yield 1; yield 2; }(); next(g); next(g)", "2"},
+		{"let g = function() { yield 1; }(); next(g); next(g)", "null"},
+		{"let g = function() { yield 1; }(); next(g); next(g); next(g)", "null"},
+		{"let g = function() { yield; }(); next(g)", "null"},
+		{"let g = function() { yield 1; return 5; yield 2; }(); next(g); next(g)", "null"},
+		{
+			`let count = function(n) { let i = 0; while (i < n) { yield i; i = i + 1; } };
+			[x * 10 for x in count(4)]`,
+			"[0, 10, 20, 30]",
+		},
+		{"let f = function(a, b = 10) { yield a; yield b; }; [x for x in f(1)]", "[1, 10]"},
+		{"[i for i, x in function() { yield 7; yield 8; }()]", "[0, 1]"},
+		{
+			// the body only runs when the generator is resumed
+			"let n = 0; let g = function() { n = n + 1; yield n; }(); let a = n; next(g); a + n * 10",
+			"10",
+		},
+		{
+			`let naturals = function() { let i = 1; while (true) { yield i; i++; } };
+			let take = function(g, n) { [next(g) for x in 1..n] };
+			take(naturals(), 3)`,
+			"[1, 2, 3]",
+		},
+		{"let g = (x => yield x * 2)(4); next(g)", "8"},
+		{"[x for x in function() { yield 1; 1 + true; }()]", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"let g = function() { yield 1 + true; }(); next(g)", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"next(1)", "ERROR: argument to `next` must be GENERATOR, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result. expected=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestSpawnAndChannels(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let fib = function(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; [wait(t) for t in [spawn fib(n) for n in 10..12]]`, "[55, 89, 144]"},
+		{`wait(spawn function(a, b = 2) { a * b }(21))`, "42"},
+		{`wait(spawn len([1, 2]))`, "2"},
+		{`let ch = channel();
+			spawn function() { [send(ch, x) for x in 1..3]; close(ch); }();
+			[x * 2 for x in ch]`, "[2, 4, 6]"},
+		{`let ch = channel(2); send(ch, 1); send(ch, 2); recv(ch) + recv(ch)`, "3"},
+		{`let ch = channel(); close(ch); recv(ch)`, "null"},
+		{`let a = channel(1); let b = channel(1); send(b, 5); select([a, b])`, "[1, 5]"},
+		{`let a = channel(); close(a); select([a])`, "[0, null]"},
+		{`let counter = 0;
+			let inc = function() { counter += 1; };
+			[wait(spawn inc()) for x in 1..50];
+			counter`, "50"},
+		{`let g = wait(spawn function() { yield 1; }()); next(g)`, "1"},
+		{`let ch = channel(); close(ch); close(ch)`, "ERROR: close of closed channel"},
+		{`let ch = channel(); close(ch); send(ch, 1)`, "ERROR: send on closed channel"},
+		{`channel(-1)`, "ERROR: channel size must not be negative, got -1"},
+		{`wait(1)`, "ERROR: argument to `wait` must be TASK, got INTEGER"},
+		{"wait(spawn function() { 1 + true }())", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result. expected=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestTimers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"setTimeout(() => 5, 10); 1", "5"},
+		{"let x = 1; setTimeout(() => { x = 2 }, 10); setTimeout(() => x, 20)", "2"},
+		{"setTimeout(() => 1, 20); setTimeout(() => 2, 10)", "1"},
+		{"setTimeout(() => 1, 10); setTimeout(() => 2, 10)", "2"},
+		{
+			`let ticks = 0;
+			let t = setInterval(() => { ticks++; if (ticks == 3) { clearInterval(t); } }, 100);
+			setTimeout(() => ticks, 1000)`,
+			"3",
+		},
+		{"let t = setTimeout(() => 1, 10); clearTimeout(t); 5", "5"},
+		{
+			`let log = [];
+			setTimeout(() => { log = push(log, 2) }, 50);
+			sleep(100);
+			setTimeout(() => { log = push(log, 1) }, 10);
+			setTimeout(() => log, 500)`,
+			"[2, 1]",
+		},
+		{
+			`let x = 0;
+			setTimeout(() => { setTimeout(() => { x = 1 }, 10) }, 10);
+			wait(spawn function() { setTimeout(() => { x = x + 10 }, 30) }());
+			setTimeout(() => x, 100)`,
+			"11",
+		},
+		{"setTimeout(() => 1 + true, 10); setTimeout(() => 2, 20)", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"setTimeout(1, 10)", "ERROR: first argument to `setTimeout` must be FUNCTION, got INTEGER"},
+		{"clearTimeout(1)", "ERROR: argument to `clearTimeout` must be TIMER, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result. expected=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5 + 8)`},
+		{`quote(foobar)`, `foobar`},
+		{`quote(foobar + barfoo)`, `(foobar + barfoo)`},
+		{`quote(unquote(4))`, `4`},
+		{`quote(unquote(4 + 4))`, `8`},
+		{`quote(8 + unquote(4 + 4))`, `(8 + 8)`},
+		{`quote(unquote("a") + unquote(true))`, `("a" + true)`},
+		{`let foobar = 8; quote(unquote(foobar))`, `8`},
+		{`quote(unquote(quote(4 + 4)))`, `(4 + 4)`},
+		{`let quotedInfix = quote(4 + 4); quote(unquote(4 + 4) + unquote(quotedInfix))`, `(8 + (4 + 4))`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		quote, ok := evaluated.(*object.Quote)
+		if !ok {
+			t.Fatalf("expected *object.Quote. got=%T (%+v)", evaluated, evaluated)
+		}
+
+		if quote.Node == nil {
+			t.Fatalf("quote.Node is nil")
+		}
+
+		if quote.Node.String() != tt.expected {
+			t.Errorf("not equal. got=%q, want=%q", quote.Node.String(), tt.expected)
+		}
+	}
+}
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+	let number = 1;
+	let fn = function(x, y) { x + y };
+	let mymacro = macro(x, y) { x + y; };
+	`
+
+	env := object.NewEnvironment()
+	program := testParseProgram(input)
+
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Wrong number of statements. got=%d", len(program.Statements))
+	}
+
+	_, ok := env.Get("number")
+	if ok {
+		t.Fatalf("number should not be defined")
+	}
+	_, ok = env.Get("fn")
+	if ok {
+		t.Fatalf("fn should not be defined")
+	}
+
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment.")
+	}
+
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("Wrong number of macro parameters. got=%d", len(macro.Parameters))
+	}
+
+	if macro.Body.String() != "(x + y)" {
+		t.Fatalf("body is not %q. got=%q", "(x + y)", macro.Body.String())
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let infixExpression = macro() { quote(1 + 2); };
+			infixExpression();`,
+			`(1 + 2)`,
+		},
+		{
+			`let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); };
+			reverse(2 + 2, 10 - 5);`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`let unless = macro(condition, consequence, alternative) {
+				quote(if (!(unquote(condition))) {
+					unquote(consequence);
+				} else {
+					unquote(alternative);
+				});
+			};
+
+			unless(10 > 5, puts("not greater"), puts("greater"));`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+	}
+
+	for _, tt := range tests {
+		expected := testParseProgram(tt.expected)
+		program := testParseProgram(tt.input)
+
+		expanded, err := ExpandProgram(program, object.NewEnvironment())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q", expected.String(), expanded.String())
+		}
+	}
+}
+
+func TestMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let twice = macro(x) { quote(unquote(x) + unquote(x)) };
+			twice(21)`,
+			"42",
+		},
+		{
+			// a macro can be expanded more than once
+			`let twice = macro(x) { quote(unquote(x) + unquote(x)) };
+			twice(1) + twice(2)`,
+			"6",
+		},
+		{
+			// the tmp of the macro does not capture the tmp of the caller
+			`let withTmp = macro(body) { quote(function() { let tmp = 100; unquote(body) }()) };
+			let tmp = 1;
+			withTmp(tmp + 1)`,
+			"2",
+		},
+		{
+			`let apply = macro(f, x) { quote(function(y) { unquote(f)(y) }(unquote(x))) };
+			let y = 5;
+			apply(function(a) { a * y }, 2)`,
+			"10",
+		},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+
+		expanded, err := ExpandProgram(program, object.NewEnvironment())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		env := object.NewEnvironment()
+		evaluated := Eval(expanded, env)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result. expected=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro() { 1 }; m()`, "macro 'm' must return a quote, got INTEGER"},
+		{`let m = macro(x) { quote(x) }; m()`, "wrong number of arguments to macro 'm': want=1, got=0"},
+		{`let m = macro() { quote(unquote(function() {})) }; m()`, "can not unquote FUNCTION"},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+
+		_, err := ExpandProgram(program, object.NewEnvironment())
+		if err == nil {
+			t.Fatalf("expected an error for %q", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestPipelineOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let double = x => x * 2; 5 |> double", "10"},
+		{"let add = (a, b) => a + b; 5 |> add(3)", "8"},
+		{
+			`let filter = (xs, f) => [x for x in xs if f(x)];
+			let map = (xs, f) => [f(x) for x in xs];
+			let isEven = x => x / 2 * 2 == x;
+			1..6 |> filter(isEven) |> map(x => x * 10)`,
+			"[20, 40, 60]",
+		},
+		{`[1, 2, 3] |> len`, "3"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result. expected=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestEnclosingEnvironments(t *testing.T) {
 	input := `
 let first = 10;
@@ -500,6 +924,18 @@ func TestStringIndexExpressions(t *testing.T) {
 			"\"Steve\"[1]",
 			"t",
 		},
+		{
+			`"héllo wörld"[7]`,
+			"ö",
+		},
+		{
+			`"日本語"[2]`,
+			"語",
+		},
+		{
+			`"日本語"[3]`,
+			nil,
+		},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -513,6 +949,108 @@ func TestStringIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestCharacters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`'a'`, 'a'},
+		{`'ö'`, 'ö'},
+		{`'\n'`, '\n'},
+		{`'\''`, '\''},
+		{`chr(97)`, 'a'},
+		{`chr(0x1F600)`, '😀'},
+		{`ord('a')`, 97},
+		{`ord("語")`, 35486},
+		{`'a' == 'a'`, true},
+		{`'a' < 'b'`, true},
+		{`'b' >= 'c'`, false},
+		{`"ab" + 'c'`, "abc"},
+		{`'a' + "bc"`, "abc"},
+		{`len("日本語")`, 3},
+		{`let 名前 = "lemur"; 名前`, "lemur"},
+		{`[c for c in "añb"]`, []string{"a", "ñ", "b"}},
+		{`{'a': 1}['a']`, 1},
+		{`chr(-1)`, "argument to `chr` is not a valid code point, got -1"},
+		{`ord("ab")`, "argument to `ord` must be a single character, got 2 characters"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case rune:
+			char, ok := evaluated.(*object.Char)
+			if !ok {
+				t.Errorf("object is not Char. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if char.Value != expected {
+				t.Errorf("wrong char. want=%q, got=%q", expected, char.Value)
+			}
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		case []string:
+			array, ok := evaluated.(*object.Array)
+			if !ok || len(array.Elements) != len(expected) {
+				t.Errorf("object is not Array of length %d. got=%T (%+v)", len(expected), evaluated, evaluated)
+				continue
+			}
+			for i, element := range expected {
+				testStringObject(t, array.Elements[i], element)
+			}
+		}
+	}
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] != [1, 2]`, false},
+		{`[1, [2, "a"]] == [1, [2, "a"]]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`{"a": [1]} == {"a": [1]}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`1..3 == 1..<4`, true},
+		{`let f = function() {}; f == f`, true},
+		{`function() {} == function() {}`, false},
+		{`{[1, 2]: "a"}[[1, 2]]`, "a"},
+		{`{{"x": 1}: "a"}[{"x": 1}]`, "a"},
+		{`let cache = {[0, 0]: "origin"}; cache[[0, 0]]`, "origin"},
+		{`{[function() {}]: 1}`, "unusable as hash key: ARRAY"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
@@ -716,20 +1254,188 @@ func TestHashLiterals(t *testing.T) {
 		FALSE.HashKey():                            6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for _, pair := range result.Pairs() {
+		hashKey, _ := object.HashKeyOf(pair.Key)
+		expectedValue, ok := expected[hashKey]
 		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+			t.Errorf("unexpected key %s in Pairs", pair.Key.Inspect())
+			continue
 		}
 
 		testIntegerObject(t, pair.Value, expectedValue)
 	}
 }
 
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, "c": 3}`, `{b: 1, a: 2, c: 3}`},
+		{`keys({3: 1, 1: 2, 2: 3})`, `[3, 1, 2]`},
+		{`[k for k, v in {"z": 1, "y": 2}]`, `[z, y]`},
+		{`{x: x for x in [3, 1, 3, 2]}`, `{3: 3, 1: 1, 2: 2}`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`error("failed")`, "ERROR: failed"},
+		{`isError(error("failed"))`, "true"},
+		{`isError(1)`, "false"},
+		{`isError(len(1))`, "true"},
+		{`let e = len(1); errorMessage(e)`, "argument to `len` not supported, got INTEGER"},
+		{`errorMessage(error("failed"))`, "failed"},
+		{`errorData(error("failed", {"code": 404}))`, "{code: 404}"},
+		{`errorData(error("failed"))`, "null"},
+		{`let e = error("not found"); errorMessage(wrap(e, "reading config"))`, "reading config: not found"},
+		{`wrap(wrap(error("a"), "b"), "c")`, "ERROR: c: b: a"},
+		{`let e = error("a"); unwrap(wrap(e, "b")) == e`, "true"},
+		{`unwrap(error("a"))`, "null"},
+		{`let f = function() { return error("failed"); 1 }; let r = f(); if (isError(r)) { "recovered" } else { r }`, "recovered"},
+		{`let f = function() { error("failed"); 1 }; f()`, "1"},
+		{`[error("a"), 1][1]`, "1"},
+		{`let i = 0; while (i < 3) { error("x"); i++ }; i`, "3"},
+		{`if (error("a")) { "truthy" }`, "truthy"},
+		{`error(1)`, "ERROR: first argument to `error` must be STRING, got INTEGER"},
+		{`errorMessage("a")`, "ERROR: first argument to `errorMessage` must be ERROR, got STRING"},
+		{`wrap(error("a"), 1)`, "ERROR: second argument to `wrap` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestReflection(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`type(1)`, "INTEGER"},
+		{`type(100000000000000000000)`, "INTEGER"},
+		{`type("a")`, "STRING"},
+		{`type('a')`, "CHAR"},
+		{`type(true)`, "BOOLEAN"},
+		{`type(if (false) { 1 })`, "NULL"},
+		{`type([])`, "ARRAY"},
+		{`type({})`, "HASH"},
+		{`type(#{})`, "SET"},
+		{`type(1..2)`, "RANGE"},
+		{`type(function() {})`, "FUNCTION"},
+		{`let x = 1; type(function() { x })`, "FUNCTION"},
+		{`let fibonacci = function(x) { x }; fibonacci`, "function fibonacci(x)"},
+		{`function add(a, b = 1) { a + b }; add`, "function add(a, b)"},
+		{`function() {}`, "function()"},
+		{`let f = function(a, b) { a }; name(f)`, "f"},
+		{`name(function() {})`, "null"},
+		{`name(len)`, "len"},
+		{`let f = function(a, b) { a }; arity(f)`, "2"},
+		{`arity(function() {})`, "0"},
+		{`let x = 1; arity(function(a) { a + x })`, "1"},
+		{`params(function(a, b = 2) { a })`, "[a, b]"},
+		{`let name = "shadowed"; name`, "shadowed"},
+		{`type(len)`, "BUILTIN"},
+		{`name(println)`, "println"},
+		{`arity(len)`, "ERROR: argument to `arity` must be FUNCTION, got BUILTIN"},
+		{`params(len)`, "ERROR: argument to `params` must be FUNCTION, got BUILTIN"},
+		{`[f(arity) for f in [type, name]]`, "[BUILTIN, arity]"},
+		{`params(1)`, "ERROR: argument to `params` must be FUNCTION, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`frozen([1])`, "false"},
+		{`frozen(freeze([1]))`, "true"},
+		{`frozen(1)`, "false"},
+		{`freeze({"a": [1]})`, "{a: [1]}"},
+		{`let a = [1]; freeze([a]); frozen(a)`, "true"},
+		{`let h = {"a": #{1}}; freeze(h); frozen(h["a"])`, "true"},
+		{`const c = {"a": [1]}; frozen(c)`, "false"},
+		{`const freeze c = {"a": [1]}; frozen(c)`, "true"},
+		{`const freeze c = {"a": [1]}; frozen(c["a"])`, "true"},
+		{`const freeze c = #{1}; add(c, 2)`, "#{1, 2}"},
+		{`frozen(push(freeze([1]), 2))`, "false"},
+		{`let freeze = function(x) { x }; const freeze c = [1]; frozen(c)`, "true"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestSets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`#{1, 2, 2, 3}`, "#{1, 2, 3}"},
+		{`#{}`, "#{}"},
+		{`#{"b", "a"}`, "#{b, a}"},
+		{`len(#{1, 1, [1], [1]})`, "2"},
+		{`has(#{1, 2}, 2)`, "true"},
+		{`has(#{1, 2}, 3)`, "false"},
+		{`add(#{1}, 2)`, "#{1, 2}"},
+		{`let s = #{1}; add(s, 2); s`, "#{1}"},
+		{`remove(#{1, 2, 3}, 2)`, "#{1, 3}"},
+		{`union(#{1, 2}, #{2, 3})`, "#{1, 2, 3}"},
+		{`intersection(#{1, 2, 3}, #{3, 2, 4})`, "#{2, 3}"},
+		{`difference(#{1, 2, 3}, #{2})`, "#{1, 3}"},
+		{`#{1, 2} == #{2, 1}`, "true"},
+		{`#{1, 2} == #{1}`, "false"},
+		{`#{} || 5`, "true"},
+		{`#{1} && true`, "true"},
+		{`#{} || false`, "false"},
+		{`!#{}`, "true"},
+		{`!#{1}`, "false"},
+		{`if (#{}) { 1 } else { 2 }`, "2"},
+		{`[x * 2 for x in #{3, 1, 3}]`, "[6, 2]"},
+		{`{#{1, 2}: "a"}[#{2, 1}]`, "a"},
+		{`let add = function(a, b) { a + b }; add(1, 2)`, "3"},
+		{`#{function() {}}`, "ERROR: unusable as set element: FUNCTION"},
+		{`add([1], 2)`, "ERROR: first argument to `add` must be SET, got ARRAY"},
+		{`union(#{1}, [2])`, "ERROR: second argument to `union` must be SET, got ARRAY"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -776,15 +1482,89 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestRanges(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1..10", "1..10"},
+		{"0..<5", "0..<5"},
+		{"let n = 3; 1..n + 1", "1..4"},
+		{"len(1..10)", 10},
+		{"len(0..<10)", 10},
+		{"len(5..1)", 0},
+		{"(1..10)[0]", 1},
+		{"(1..10)[9]", 10},
+		{"(0..<10)[10]", nil},
+		{`1.."a"`, "type mismatch: INTEGER .. STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			if evaluated.Inspect() != expected && evaluated.Inspect() != "ERROR: "+expected {
+				t.Errorf("wrong result. expected=%q, got=%q", expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestComprehensions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[x * 2 for x in [1, 2, 3]]", "[2, 4, 6]"},
+		{"[x for x in [1, -2, 3] if x > 0]", "[1, 3]"},
+		{"[x for x in 1..5 if x != 3]", "[1, 2, 4, 5]"},
+		{"[i * v for i, v in [4, 5, 6]]", "[0, 5, 12]"},
+		{`[c for c in "abc"]`, "[a, b, c]"},
+		{"[x for x in []]", "[]"},
+		{"let k = 10; [x + k for x in 0..<2]", "[10, 11]"},
+		{"[[y for y in 0..<x] for x in 1..3]", "[[0], [0, 1], [0, 1, 2]]"},
+		{`{"a": v for v in [1]}`, "{a: 1}"},
+		{`{k: v * 2 for k, v in {"a": 1}}`, "{a: 2}"},
+		{`{k: true for k in {"a": 1}}`, "{a: true}"},
+		{"let x = 5; [x for x in 1..2]; x", "5"},
+		{"[x for x in 1]", "ERROR: object is not iterable: INTEGER"},
+		{"[y for x in 1..2]", "ERROR: identifier not found: y"},
+		{"{[x, function() {}]: 1 for x in 1..2}", "ERROR: unusable as hash key: ARRAY"},
+		{"{[x]: x for x in 1..2}[[2]]", "2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 /*
 ** Helpers
  */
+func testParseProgram(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
 	env := object.NewEnvironment()
 
+	// The fake clock runs timers without waiting for them
+	env.SetEventLoop(eventloop.New(eventloop.NewFakeClock()))
+
 	return Eval(program, env)
 }
 
@@ -0,0 +1,136 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/object"
+)
+
+// ExpandProgram defines the macros of the program in env and expands all macro
+// calls. The environment keeps the macros, so they can be used by programs that
+// are expanded later, e.g. in the REPL.
+func ExpandProgram(program *ast.Program, env *object.Environment) (*ast.Program, error) {
+	DefineMacros(program, env)
+
+	expanded, err := ExpandMacros(program, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return expanded.(*ast.Program), nil
+}
+
+// DefineMacros moves all macros defined with a top level let statement from
+// the program into the environment.
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	statements := []ast.Statement{}
+
+	for _, statement := range program.Statements {
+		if !isMacroDefinition(statement) {
+			statements = append(statements, statement)
+			continue
+		}
+
+		letStatement := statement.(*ast.LetStatement)
+		macroLiteral := letStatement.Value.(*ast.MacroLiteral)
+
+		macro := &object.Macro{
+			Parameters: macroLiteral.Parameters,
+			Env:        env,
+			Body:       macroLiteral.Body,
+		}
+
+		env.DefineVariable(letStatement.Name.Value, macro)
+	}
+
+	program.Statements = statements
+}
+
+func isMacroDefinition(node ast.Statement) bool {
+	letStatement, ok := node.(*ast.LetStatement)
+	if !ok {
+		return false
+	}
+
+	_, ok = letStatement.Value.(*ast.MacroLiteral)
+	return ok
+}
+
+// ExpandMacros replaces every call of a macro with the code the macro returns.
+// It runs after DefineMacros and before the program is optimized, compiled or
+// evaluated, so the expanded code works with every engine.
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, error) {
+	var err error
+
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		callExpression, ok := node.(*ast.CallExpression)
+		if !ok || err != nil {
+			return node
+		}
+
+		macro, ok := isMacroCall(callExpression, env)
+		if !ok {
+			return node
+		}
+
+		if len(callExpression.Arguments) != len(macro.Parameters) {
+			err = fmt.Errorf("wrong number of arguments to macro '%s': want=%d, got=%d",
+				callExpression.Function.String(), len(macro.Parameters), len(callExpression.Arguments))
+			return node
+		}
+
+		evalEnv := extendMacroEnv(macro, quoteArgs(callExpression))
+		evaluated := Eval(macro.Body, evalEnv)
+		if isError(evaluated) {
+			err = fmt.Errorf("%s", evaluated.(*object.Error).Message)
+			return node
+		}
+
+		quote, ok := unwrapReturnValue(evaluated).(*object.Quote)
+		if !ok {
+			err = fmt.Errorf("macro '%s' must return a quote, got %s",
+				callExpression.Function.String(), evaluated.Type())
+			return node
+		}
+
+		return quote.Node
+	})
+
+	return expanded, err
+}
+
+func isMacroCall(exp *ast.CallExpression, env *object.Environment) (*object.Macro, bool) {
+	identifier, ok := exp.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+
+	obj, ok := env.Get(identifier.Value)
+	if !ok {
+		return nil, false
+	}
+
+	macro, ok := obj.(*object.Macro)
+	return macro, ok
+}
+
+func quoteArgs(exp *ast.CallExpression) []*object.Quote {
+	args := []*object.Quote{}
+
+	for _, a := range exp.Arguments {
+		args = append(args, &object.Quote{Node: a})
+	}
+
+	return args
+}
+
+func extendMacroEnv(macro *object.Macro, args []*object.Quote) *object.Environment {
+	extended := object.NewEnclosedEnvironment(macro.Env)
+
+	for paramIdx, param := range macro.Parameters {
+		extended.DefineVariable(param.Value, args[paramIdx])
+	}
+
+	return extended
+}
@@ -0,0 +1,185 @@
+package evaluator
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/object"
+	"github.com/rhwilr/lemur/token"
+)
+
+// gensymCounter makes the names of renamed bindings unique.
+var gensymCounter int64
+
+// quote returns the node without evaluating it. Calls to unquote inside of the
+// node are evaluated and replaced with their result.
+func quote(node ast.Node, env *object.Environment) object.Object {
+	// The node belongs to the program and may be quoted again, e.g. each time
+	// a macro is expanded.
+	node = ast.Copy(node)
+
+	renameBindings(node)
+
+	node, err := evalUnquoteCalls(node, env)
+	if err != nil {
+		return err
+	}
+
+	return &object.Quote{Node: node}
+}
+
+func evalUnquoteCalls(quoted ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var err *object.Error
+
+	node := ast.Modify(quoted, func(node ast.Node) ast.Node {
+		if !isUnquoteCall(node) || err != nil {
+			return node
+		}
+
+		call := node.(*ast.CallExpression)
+		if len(call.Arguments) != 1 {
+			err = newError("wrong number of arguments to `unquote`. got=%d, want=1", len(call.Arguments))
+			return node
+		}
+
+		unquoted := Eval(call.Arguments[0], env)
+		if isError(unquoted) {
+			err = unquoted.(*object.Error)
+			return node
+		}
+
+		converted, ok := convertObjectToASTNode(unquoted)
+		if !ok {
+			err = newError("can not unquote %s", unquoted.Type())
+			return node
+		}
+
+		return converted
+	})
+
+	return node, err
+}
+
+// renameBindings makes quoted code hygienic. Variables, constants and
+// parameters declared by the quoted code itself get a unique name, so they can
+// not clash with the names used around the place where the code ends up.
+// Identifiers passed in through unquote keep their names.
+func renameBindings(quoted ast.Node) {
+	unquoted := map[*ast.Identifier]bool{}
+	ast.Modify(quoted, func(node ast.Node) ast.Node {
+		if isUnquoteCall(node) {
+			for _, arg := range node.(*ast.CallExpression).Arguments {
+				ast.Modify(arg, func(node ast.Node) ast.Node {
+					if ident, ok := node.(*ast.Identifier); ok {
+						unquoted[ident] = true
+					}
+					return node
+				})
+			}
+		}
+		return node
+	})
+
+	renamed := map[string]string{}
+	bind := func(ident *ast.Identifier) {
+		if ident != nil && !unquoted[ident] {
+			if _, ok := renamed[ident.Value]; !ok {
+				renamed[ident.Value] = gensym(ident.Value)
+			}
+		}
+	}
+
+	ast.Modify(quoted, func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			bind(node.Name)
+		case *ast.ConstStatement:
+			bind(node.Name)
+		case *ast.FunctionLiteral:
+			for _, param := range node.Parameters {
+				bind(param)
+			}
+		case *ast.ArrayComprehension:
+			for _, variable := range node.Variables {
+				bind(variable)
+			}
+		case *ast.HashComprehension:
+			for _, variable := range node.Variables {
+				bind(variable)
+			}
+		}
+		return node
+	})
+
+	if len(renamed) == 0 {
+		return
+	}
+
+	ast.Modify(quoted, func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.Identifier:
+			if name, ok := renamed[node.Value]; ok && !unquoted[node] {
+				node.Value = name
+				node.Token.Literal = name
+			}
+		case *ast.FunctionLiteral:
+			defaults := make(map[string]ast.Expression, len(node.Defaults))
+			for key, value := range node.Defaults {
+				if name, ok := renamed[key]; ok {
+					key = name
+				}
+				defaults[key] = value
+			}
+			node.Defaults = defaults
+		}
+		return node
+	})
+}
+
+func gensym(name string) string {
+	return fmt.Sprintf("%s__%d", name, atomic.AddInt64(&gensymCounter, 1))
+}
+
+func isUnquoteCall(node ast.Node) bool {
+	callExpression, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+
+	return callExpression.Function.TokenLiteral() == "unquote"
+}
+
+func convertObjectToASTNode(obj object.Object) (ast.Node, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		t := token.Token{Type: token.INT, Literal: fmt.Sprintf("%d", obj.Value)}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}, true
+
+	case *object.BigInteger:
+		t := token.Token{Type: token.INT, Literal: obj.Value.String()}
+		return &ast.BigIntegerLiteral{Token: t, Value: obj.Value}, true
+
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}, true
+
+	case *object.Char:
+		t := token.Token{Type: token.CHAR, Literal: string(obj.Value)}
+		return &ast.CharLiteral{Token: t, Value: obj.Value}, true
+
+	case *object.Boolean:
+		var t token.Token
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true"}
+		} else {
+			t = token.Token{Type: token.FALSE, Literal: "false"}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}, true
+
+	case *object.Quote:
+		return obj.Node, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,134 @@
+package eventloop
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rhwilr/lemur/object"
+)
+
+// Loop keeps track of the timers created by a program. The engines run the
+// loop after the program has finished, which keeps the program alive until all
+// callbacks are done.
+type Loop struct {
+	mu     sync.Mutex
+	clock  Clock
+	timers []*timer
+	nextID int64
+	seq    int64
+}
+
+type timer struct {
+	*object.Timer
+	due time.Time
+	seq int64
+}
+
+// New creates an event loop using the given clock. If clock is nil, the wall
+// clock is used.
+func New(clock Clock) *Loop {
+	if clock == nil {
+		clock = RealClock{}
+	}
+
+	return &Loop{clock: clock}
+}
+
+// SetTimer schedules the callback to run after the delay. Repeating timers are
+// rescheduled each time they fire until they are stopped.
+func (l *Loop) SetTimer(callback object.Object, delay time.Duration, repeat bool) *object.Timer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.nextID++
+	t := &timer{
+		Timer: &object.Timer{ID: l.nextID, Callback: callback, Delay: delay, Repeat: repeat},
+		due:   l.clock.Now().Add(delay),
+	}
+	l.schedule(t)
+
+	return t.Timer
+}
+
+// Sleep blocks for the given duration.
+func (l *Loop) Sleep(delay time.Duration) {
+	l.clock.Sleep(delay)
+}
+
+// Run calls the callback of each timer once it is due. Timers that are due at
+// the same time fire in the order they were scheduled. Run returns when no
+// timers are left or a callback fails.
+func (l *Loop) Run(call func(callback object.Object) error) error {
+	for {
+		t := l.next()
+		if t == nil {
+			return nil
+		}
+
+		if wait := t.due.Sub(l.clock.Now()); wait > 0 {
+			l.clock.Sleep(wait)
+			continue
+		}
+
+		l.remove(t)
+		if t.Repeat {
+			l.mu.Lock()
+			t.due = t.due.Add(t.Delay)
+			l.schedule(t)
+			l.mu.Unlock()
+		}
+
+		err := call(t.Callback)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// next returns the timer that is due first and drops stopped timers.
+func (l *Loop) next() *timer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	active := l.timers[:0]
+	for _, t := range l.timers {
+		if !t.Stopped() {
+			active = append(active, t)
+		}
+	}
+	l.timers = active
+
+	if len(l.timers) == 0 {
+		return nil
+	}
+
+	return l.timers[0]
+}
+
+func (l *Loop) remove(t *timer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, other := range l.timers {
+		if other == t {
+			l.timers = append(l.timers[:i], l.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// schedule inserts the timer sorted by due time. The caller must hold the lock.
+func (l *Loop) schedule(t *timer) {
+	l.seq++
+	t.seq = l.seq
+
+	i := sort.Search(len(l.timers), func(i int) bool {
+		other := l.timers[i]
+		return other.due.After(t.due) || (other.due.Equal(t.due) && other.seq > t.seq)
+	})
+
+	l.timers = append(l.timers, nil)
+	copy(l.timers[i+1:], l.timers[i:])
+	l.timers[i] = t
+}
@@ -0,0 +1,67 @@
+package eventloop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rhwilr/lemur/object"
+)
+
+func TestLoopRunsTimersInOrder(t *testing.T) {
+	clock := NewFakeClock()
+	loop := New(clock)
+
+	loop.SetTimer(&object.Integer{Value: 3}, 30*time.Millisecond, false)
+	loop.SetTimer(&object.Integer{Value: 1}, 10*time.Millisecond, false)
+	loop.SetTimer(&object.Integer{Value: 2}, 10*time.Millisecond, false)
+	stopped := loop.SetTimer(&object.Integer{Value: 4}, 20*time.Millisecond, false)
+	stopped.Stop()
+
+	var called []int64
+	err := loop.Run(func(callback object.Object) error {
+		called = append(called, callback.(*object.Integer).Value)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []int64{1, 2, 3}
+	if len(called) != len(expected) {
+		t.Fatalf("wrong number of callbacks. want=%d, got=%d", len(expected), len(called))
+	}
+
+	for i, value := range expected {
+		if called[i] != value {
+			t.Errorf("wrong callback at %d. want=%d, got=%d", i, value, called[i])
+		}
+	}
+
+	if elapsed := clock.Now().Sub(time.Unix(0, 0)); elapsed != 30*time.Millisecond {
+		t.Errorf("wrong time on clock. want=%s, got=%s", 30*time.Millisecond, elapsed)
+	}
+}
+
+func TestLoopRepeatsIntervals(t *testing.T) {
+	clock := NewFakeClock()
+	loop := New(clock)
+
+	interval := loop.SetTimer(&object.Null{}, 100*time.Millisecond, true)
+
+	calls := 0
+	loop.Run(func(callback object.Object) error {
+		calls++
+		if calls == 5 {
+			interval.Stop()
+		}
+		return nil
+	})
+
+	if calls != 5 {
+		t.Errorf("wrong number of calls. want=5, got=%d", calls)
+	}
+
+	if elapsed := clock.Now().Sub(time.Unix(0, 0)); elapsed != 500*time.Millisecond {
+		t.Errorf("wrong time on clock. want=%s, got=%s", 500*time.Millisecond, elapsed)
+	}
+}
@@ -0,0 +1,685 @@
+// Package formatter prints programs in the canonical Lemur style. Blocks are
+// indented by two spaces, operators are surrounded by spaces and statements
+// end with a semicolon. Comments and single blank lines between statements are
+// kept, everything else about the layout of the source is normalized.
+package formatter
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/diagnostics"
+	"github.com/rhwilr/lemur/lexer"
+	"github.com/rhwilr/lemur/parser"
+	"github.com/rhwilr/lemur/token"
+)
+
+const indentation = "  "
+
+// Operator precedences, as used by the parser.
+const (
+	lowest = iota
+	cond
+	assign
+	equals
+	lessGreater
+	pipe
+	rangeOp
+	sum
+	product
+	prefix
+	call
+	index
+	primary
+)
+
+var precedences = map[string]int{
+	"||":  cond,
+	"&&":  cond,
+	"==":  equals,
+	"!=":  equals,
+	"<":   lessGreater,
+	"<=":  lessGreater,
+	">":   lessGreater,
+	">=":  lessGreater,
+	"..":  rangeOp,
+	"..<": rangeOp,
+	"+":   sum,
+	"-":   sum,
+	"*":   product,
+	"/":   product,
+}
+
+// Source formats a program. Programs with syntax errors are not formatted,
+// the diagnostics of the parser are returned instead.
+func Source(input string, file string) (string, []diagnostics.Diagnostic) {
+	l := lexer.NewWithFile(input, file)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if found := p.Diagnostics(); diagnostics.HasErrors(found) {
+		return "", found
+	}
+
+	pr := &printer{
+		lines:     strings.Split(input, "\n"),
+		comments:  l.Comments(),
+		lineStart: true,
+	}
+	pr.statements(program.Statements, token.TokenPosition{Line: len(pr.lines) + 1})
+
+	return pr.out.String(), nil
+}
+
+type printer struct {
+	out       bytes.Buffer
+	depth     int
+	lineStart bool // nothing has been written on the current line
+
+	lines    []string        // the source, to find blank lines
+	comments []token.Comment // the comments that are not printed yet
+}
+
+func (p *printer) write(s string) {
+	if p.lineStart {
+		p.out.WriteString(strings.Repeat(indentation, p.depth))
+		p.lineStart = false
+	}
+
+	p.out.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.out.WriteByte('\n')
+	p.lineStart = true
+}
+
+// blankLine writes an empty line if the line before line is empty in the
+// source. first is set for the first line of a block.
+func (p *printer) blankLine(line int, first bool) {
+	if first || line < 2 || line-2 >= len(p.lines) || strings.TrimSpace(p.lines[line-2]) != "" {
+		return
+	}
+
+	if bytes.HasSuffix(p.out.Bytes(), []byte("\n\n")) {
+		return
+	}
+
+	p.newline()
+}
+
+// flush prints the comments in front of pos, each on its own line. Trailing
+// comments are appended to the previous line. It returns whether the next
+// line is still the first of its block.
+func (p *printer) flush(pos token.TokenPosition, first bool) bool {
+	for len(p.comments) > 0 && before(p.comments[0].Position, pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		if c.Trailing && p.out.Len() > 0 {
+			p.out.Truncate(p.out.Len() - 1)
+			p.out.WriteString(" " + c.Text)
+			p.newline()
+			continue
+		}
+
+		p.blankLine(c.Position.Line, first)
+		p.write(c.Text)
+		p.newline()
+		first = false
+	}
+
+	return first
+}
+
+// hasComments reports whether there are comments between from and to.
+func (p *printer) hasComments(from, to token.TokenPosition) bool {
+	for _, c := range p.comments {
+		if before(from, c.Position) && before(c.Position, to) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func before(a, b token.TokenPosition) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+/*
+** Statements
+ */
+func (p *printer) statements(statements []ast.Statement, end token.TokenPosition) {
+	first := true
+
+	for i, stmt := range statements {
+		first = p.flush(stmt.Pos(), first)
+		p.blankLine(stmt.Pos().Line, first)
+
+		var next ast.Statement
+		if i+1 < len(statements) {
+			next = statements[i+1]
+		}
+
+		p.statement(stmt)
+		if needsSemicolon(stmt, next) {
+			p.write(";")
+		}
+
+		p.newline()
+		first = false
+	}
+
+	p.flush(end, first)
+}
+
+func (p *printer) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		p.write("let " + stmt.Name.Value)
+		p.annotation(stmt.Type)
+		p.write(" = ")
+		p.expression(stmt.Value, lowest)
+		p.write(";")
+
+	case *ast.ConstStatement:
+		p.write("const ")
+		if stmt.Freeze {
+			p.write("freeze ")
+		}
+		p.write(stmt.Name.Value)
+		p.annotation(stmt.Type)
+		p.write(" = ")
+		p.expression(stmt.Value, lowest)
+		p.write(";")
+
+	case *ast.ReturnStatement:
+		p.write("return")
+		if stmt.ReturnValue != nil {
+			p.write(" ")
+			p.expression(stmt.ReturnValue, lowest)
+		}
+		p.write(";")
+
+	case *ast.ExpressionStatement:
+		p.expression(stmt.Expression, lowest)
+
+	case *ast.BlockStatement:
+		p.block(stmt)
+	}
+}
+
+// needsSemicolon reports whether the expression statement stmt has to be
+// terminated. Statements that end with a block, like if and while, only need
+// one if next would continue their expression otherwise, like `(x)` after a
+// function definition would call the function.
+func needsSemicolon(stmt ast.Statement, next ast.Statement) bool {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+
+	if !endsWithBlock(es.Expression) {
+		return true
+	}
+
+	following, ok := next.(*ast.ExpressionStatement)
+
+	return ok && continues(following.Expression)
+}
+
+// continues reports whether the printed node starts with a token that
+// continues a preceding expression, like '(', '[' or '-'.
+func continues(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		return precedence(node.Left) < precedences[node.Operator] || continues(node.Left)
+	case *ast.IndexExpression:
+		return precedence(node.Left) < call || continues(node.Left)
+	case *ast.CallExpression:
+		if node.Pipeline && len(node.Arguments) > 0 {
+			return precedence(node.Arguments[0]) < pipe || continues(node.Arguments[0])
+		}
+		return precedence(node.Function) < call || continues(node.Function)
+	case *ast.PrefixExpression:
+		return node.Operator == "-"
+	case *ast.AssignStatement:
+		return isIncrement(node)
+	case *ast.ArrayLiteral, *ast.ArrayComprehension:
+		return true
+	case *ast.FunctionLiteral:
+		return node.Arrow && (len(node.Parameters) != 1 || len(node.Defaults) > 0)
+	}
+
+	return false
+}
+
+func endsWithBlock(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.IfExpression, *ast.WhileLoopExpression:
+		return true
+	case *ast.FunctionLiteral:
+		return node.Define
+	}
+
+	return false
+}
+
+func (p *printer) block(block *ast.BlockStatement) {
+	comments := p.hasComments(block.Token.Position, block.Rbrace)
+
+	if len(block.Statements) == 0 && !comments {
+		p.write("{}")
+		return
+	}
+
+	// Blocks written on a single line stay on a single line.
+	if block.Token.Position.Line == block.Rbrace.Line && !comments {
+		p.write("{ ")
+		for i, stmt := range block.Statements {
+			if i > 0 {
+				p.write(" ")
+			}
+
+			// The last expression of the block needs no semicolon.
+			p.statement(stmt)
+			if i+1 < len(block.Statements) && needsSemicolon(stmt, block.Statements[i+1]) {
+				p.write(";")
+			}
+		}
+		p.write(" }")
+		return
+	}
+
+	p.write("{")
+	p.newline()
+
+	p.depth++
+	p.statements(block.Statements, block.Rbrace)
+	p.depth--
+
+	p.write("}")
+}
+
+/*
+** Expressions
+ */
+
+// expression prints node, in parentheses if its precedence is lower than min.
+func (p *printer) expression(node ast.Expression, min int) {
+	if precedence(node) < min {
+		p.write("(")
+		p.expression(node, lowest)
+		p.write(")")
+		return
+	}
+
+	switch node := node.(type) {
+	case *ast.Identifier:
+		p.write(node.Value)
+
+	case *ast.IntegerLiteral, *ast.BigIntegerLiteral, *ast.Boolean:
+		p.write(node.TokenLiteral())
+
+	case *ast.StringLiteral:
+		p.write(quote(node.Value))
+
+	case *ast.CharLiteral:
+		p.write("'" + node.Token.Literal + "'")
+
+	case *ast.PrefixExpression:
+		p.write(node.Operator)
+		// `- -x` must not turn into `--x`
+		if node.Operator == "-" && strings.HasPrefix(node.Right.TokenLiteral(), "-") {
+			p.expression(node.Right, primary)
+		} else {
+			p.expression(node.Right, prefix)
+		}
+
+	case *ast.InfixExpression:
+		p.infix(node)
+
+	case *ast.AssignStatement:
+		if isIncrement(node) {
+			p.write(node.Operator + node.Name.Value)
+			return
+		}
+
+		p.write(node.Name.Value + " " + node.Operator + " ")
+		p.expression(node.Value, lowest)
+
+	case *ast.PostfixExpression:
+		p.write(node.Name.Value + node.Operator)
+
+	case *ast.IfExpression:
+		p.write("if (")
+		p.expression(node.Condition, lowest)
+		p.write(") ")
+		p.block(node.Consequence)
+		if node.Alternative != nil {
+			p.write(" else ")
+			p.block(node.Alternative)
+		}
+
+	case *ast.WhileLoopExpression:
+		p.write("while (")
+		p.expression(node.Condition, lowest)
+		p.write(") ")
+		p.block(node.Consequence)
+
+	case *ast.FunctionLiteral:
+		p.function(node)
+
+	case *ast.MacroLiteral:
+		p.write("macro(")
+		for i, param := range node.Parameters {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(param.Value)
+		}
+		p.write(") ")
+		p.block(node.Body)
+
+	case *ast.CallExpression:
+		p.call(node)
+
+	case *ast.IndexExpression:
+		p.expression(node.Left, call)
+		p.write("[")
+		p.expression(node.Index, lowest)
+		p.write("]")
+
+	case *ast.ArrayLiteral:
+		p.write("[")
+		p.expressions(node.Token, node.Elements)
+		p.write("]")
+
+	case *ast.SetLiteral:
+		p.write("#{")
+		p.expressions(node.Token, node.Elements)
+		p.write("}")
+
+	case *ast.HashLiteral:
+		starts := make([]token.TokenPosition, len(node.Pairs))
+		for i, pair := range node.Pairs {
+			starts[i] = start(pair.Key)
+		}
+
+		p.write("{")
+		p.list(node.Token, starts, func(i int) {
+			p.expression(node.Pairs[i].Key, lowest)
+			p.write(": ")
+			p.expression(node.Pairs[i].Value, lowest)
+		})
+		p.write("}")
+
+	case *ast.ArrayComprehension:
+		p.write("[")
+		p.expression(node.Element, lowest)
+		p.comprehension(node.Variables, node.Iterable, node.Condition)
+		p.write("]")
+
+	case *ast.HashComprehension:
+		p.write("{")
+		p.expression(node.Key, lowest)
+		p.write(": ")
+		p.expression(node.Value, lowest)
+		p.comprehension(node.Variables, node.Iterable, node.Condition)
+		p.write("}")
+
+	case *ast.YieldExpression:
+		p.write("yield")
+		if node.Value != nil {
+			p.write(" ")
+			p.expression(node.Value, lowest)
+		}
+
+	case *ast.SpawnExpression:
+		p.write("spawn ")
+		p.expression(node.Call, call)
+	}
+}
+
+func (p *printer) infix(node *ast.InfixExpression) {
+	precedence := precedences[node.Operator]
+
+	p.expression(node.Left, precedence)
+	if precedence == rangeOp {
+		p.write(node.Operator)
+	} else {
+		p.write(" " + node.Operator + " ")
+	}
+
+	// Operators are left-associative, an operand on the right with the same
+	// precedence needs parentheses.
+	p.expression(node.Right, precedence+1)
+}
+
+func (p *printer) function(node *ast.FunctionLiteral) {
+	if node.Arrow {
+		if len(node.Parameters) == 1 && len(node.Defaults) == 0 {
+			p.write(node.Parameters[0].Value)
+		} else {
+			p.write("(")
+			p.parameters(node)
+			p.write(")")
+		}
+		p.write(" => ")
+
+		// The body of `x => x * 2` is a block without braces.
+		if node.Body.Token.Type != token.LBRACE && len(node.Body.Statements) == 1 {
+			if stmt, ok := node.Body.Statements[0].(*ast.ExpressionStatement); ok {
+				p.expression(stmt.Expression, lowest)
+				return
+			}
+		}
+
+		p.block(node.Body)
+		return
+	}
+
+	p.write("function")
+	if node.Define {
+		p.write(" " + node.Name)
+	}
+
+	p.write("(")
+	p.parameters(node)
+	p.write(")")
+	p.annotation(node.ReturnType)
+	p.write(" ")
+	p.block(node.Body)
+}
+
+func (p *printer) parameters(node *ast.FunctionLiteral) {
+	for i, param := range node.Parameters {
+		if i > 0 {
+			p.write(", ")
+		}
+
+		p.write(param.Value)
+		p.annotation(node.ParameterTypes[param.Value])
+		if value, ok := node.Defaults[param.Value]; ok {
+			p.write(" = ")
+			p.expression(value, lowest)
+		}
+	}
+}
+
+func (p *printer) annotation(annotation *ast.TypeAnnotation) {
+	if annotation != nil {
+		p.write(": " + annotation.Name)
+	}
+}
+
+// call prints a call. Calls written with the pipeline operator keep it, the
+// first argument is the value on the left.
+func (p *printer) call(node *ast.CallExpression) {
+	if node.Pipeline && len(node.Arguments) > 0 {
+		p.expression(node.Arguments[0], pipe)
+		p.write(" |> ")
+
+		if node.Token.Type == token.PIPE {
+			p.expression(node.Function, pipe+1)
+			return
+		}
+
+		p.expression(node.Function, call)
+		p.write("(")
+		for i, arg := range node.Arguments[1:] {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(arg, lowest)
+		}
+		p.write(")")
+		return
+	}
+
+	p.expression(node.Function, call)
+	p.write("(")
+	p.expressions(node.Token, node.Arguments)
+	p.write(")")
+}
+
+func (p *printer) comprehension(variables []*ast.Identifier, iterable ast.Expression, condition ast.Expression) {
+	p.write(" for ")
+	for i, v := range variables {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(v.Value)
+	}
+
+	p.write(" in ")
+	p.expression(iterable, lowest)
+
+	if condition != nil {
+		p.write(" if ")
+		p.expression(condition, lowest)
+	}
+}
+
+func (p *printer) expressions(open token.Token, list []ast.Expression) {
+	starts := make([]token.TokenPosition, len(list))
+	for i, el := range list {
+		starts[i] = start(el)
+	}
+
+	p.list(open, starts, func(i int) {
+		p.expression(list[i], lowest)
+	})
+}
+
+// list prints comma separated items. The items are put on separate lines if
+// the first one starts on a new line in the source.
+func (p *printer) list(open token.Token, starts []token.TokenPosition, item func(i int)) {
+	if len(starts) == 0 || starts[0].Line == open.Position.Line {
+		for i := range starts {
+			if i > 0 {
+				p.write(", ")
+			}
+			item(i)
+		}
+		return
+	}
+
+	p.newline()
+	p.depth++
+
+	for i := range starts {
+		p.flush(starts[i], i == 0)
+		item(i)
+		if i+1 < len(starts) {
+			p.write(",")
+		}
+		p.newline()
+	}
+
+	p.depth--
+}
+
+// precedence returns the precedence of the operator of node. Expressions
+// that end with a block or an open expression have the lowest precedence.
+func precedence(node ast.Expression) int {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		return precedences[node.Operator]
+	case *ast.AssignStatement:
+		if isIncrement(node) {
+			return prefix
+		}
+		return assign
+	case *ast.PrefixExpression, *ast.SpawnExpression:
+		return prefix
+	case *ast.CallExpression:
+		if node.Pipeline {
+			return pipe
+		}
+		return call
+	case *ast.IndexExpression:
+		return index
+	case *ast.IfExpression, *ast.WhileLoopExpression, *ast.FunctionLiteral, *ast.MacroLiteral, *ast.YieldExpression:
+		return lowest
+	}
+
+	return primary
+}
+
+// start returns the position of the first token of node.
+func start(node ast.Expression) token.TokenPosition {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		return start(node.Left)
+	case *ast.IndexExpression:
+		return start(node.Left)
+	case *ast.CallExpression:
+		if node.Pipeline && len(node.Arguments) > 0 {
+			return start(node.Arguments[0])
+		}
+		return start(node.Function)
+	case *ast.AssignStatement:
+		if !isIncrement(node) && node.Name != nil {
+			return node.Name.Pos()
+		}
+	case *ast.PostfixExpression:
+		return node.Name.Pos()
+	}
+
+	return node.Pos()
+}
+
+// isIncrement reports whether node is a prefix `++x` or `--x`.
+func isIncrement(node *ast.AssignStatement) bool {
+	return node.Operator == "++" || node.Operator == "--"
+}
+
+// quote returns s as a string literal, with the escape sequences the lexer
+// understands.
+func quote(s string) string {
+	var out strings.Builder
+
+	out.WriteByte('"')
+	for _, ch := range s {
+		switch ch {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			out.WriteRune(ch)
+		}
+	}
+	out.WriteByte('"')
+
+	return out.String()
+}
@@ -0,0 +1,81 @@
+package formatter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let   x=1+2*3;", "let x = 1 + 2 * 3;\n"},
+		{"(1 + 2) * 3; a - (b - c); (a + b) + c", "(1 + 2) * 3;\na - (b - c);\na + b + c;\n"},
+		{"- -x; !!y; -(a + b)", "-(-x);\n!!y;\n-(a + b);\n"},
+		{"let f = fn(x) {\nx * 2\n}", ""},
+		{"let f = function(x) {\nx * 2\n};", "let f = function(x) {\n  x * 2;\n};\n"},
+		{"let f = function(x) { x * 2 };", "let f = function(x) { x * 2 };\n"},
+		{"function add(a: int, b: int = 2): int { a + b };", "function add(a: int, b: int = 2): int { a + b }\n"},
+		{"const freeze c : string='a';", "const freeze c: string = 'a';\n"},
+		{"let xs = [1,2]|>map(x=>x*2)|>sum;", "let xs = [1, 2] |> map(x => x * 2) |> sum;\n"},
+		{"let f = (a, b = 1) => { a + b };", "let f = (a, b = 1) => { a + b };\n"},
+		{`"a\"b\n\t\\"`, `"a\"b\n\t\\";` + "\n"},
+		{"#{1,2}; {1:2}; {k: v for k, v in h if v > 1}; [x for x in 0..<3]", "#{1, 2};\n{1: 2};\n{k: v for k, v in h if v > 1};\n[x for x in 0..<3];\n"},
+		{"if (x) { a } else { b }\nwhile (y) { z }", "if (x) { a } else { b }\nwhile (y) { z }\n"},
+		{"function f() {}; (1 + 2) * 3", "function f() {};\n(1 + 2) * 3;\n"},
+		{"let g = function() { yield 1; yield }; spawn g(); ++i; i--; x += 1", "let g = function() { yield 1; yield };\nspawn g();\n++i;\ni--;\nx += 1;\n"},
+		{"let h = {\n\"a\": 1, // one\n// two\n\"b\": 2};", "let h = {\n  \"a\": 1, // one\n  // two\n  \"b\": 2\n};\n"},
+		{"// a\n\n\n\nlet x = 1; // b\n/* c */\nx", "// a\n\nlet x = 1; // b\n/* c */\nx;\n"},
+		{"if (x) {\n\n  a;\n\n\n  // end\n}", "if (x) {\n  a;\n\n  // end\n}\n"},
+	}
+
+	for _, tt := range tests {
+		formatted, found := Source(tt.input, "")
+
+		if tt.expected == "" {
+			if len(found) == 0 {
+				t.Errorf("input %q: expected syntax errors", tt.input)
+			}
+			continue
+		}
+
+		if len(found) > 0 {
+			t.Errorf("input %q: unexpected syntax errors: %v", tt.input, found)
+			continue
+		}
+
+		if formatted != tt.expected {
+			t.Errorf("input %q: wrong output.\nwant=%q\ngot=%q", tt.input, tt.expected, formatted)
+		}
+
+		if again, _ := Source(formatted, ""); again != formatted {
+			t.Errorf("input %q: formatting is not idempotent.\nfirst=%q\nsecond=%q", tt.input, formatted, again)
+		}
+	}
+}
+
+func TestExamplesIdempotent(t *testing.T) {
+	files, err := filepath.Glob("../examples/*.lem")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no examples found: %v", err)
+	}
+
+	for _, file := range files {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		formatted, found := Source(string(input), file)
+		if len(found) > 0 {
+			t.Errorf("%s: unexpected syntax errors: %v", file, found)
+			continue
+		}
+
+		if again, _ := Source(formatted, file); again != formatted {
+			t.Errorf("%s: formatting is not idempotent.\nfirst=%s\nsecond=%s", file, formatted, again)
+		}
+	}
+}
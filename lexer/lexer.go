@@ -1,20 +1,31 @@
 package lexer
 
 import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rhwilr/lemur/diagnostics"
 	"github.com/rhwilr/lemur/token"
 )
 
 // The Lexer is used to parse source code and turn it into tokens
 type Lexer struct {
 	input        []rune
+	file         string // name of the source file, used in token positions
 	
 	line         int  // current line in input
-	column       int  // current column in input
+	tokenLine    int  // line where the current token starts
+	column       int  // column where the current token starts
 	readColumn       int  // current column in input
 
 	position     int  // current position in input
 	readPosition int  // current reading position in input
 	ch           rune // current char under examination
+
+	diagnostics []diagnostics.Diagnostic
+	comments    []token.Comment
+	lastLine    int // line of the last token, to find trailing comments
 }
 
 // New will return a new instance of a Lexer
@@ -30,6 +41,61 @@ func New(input string) *Lexer {
 	return l
 }
 
+// NewWithFile returns a Lexer whose token positions refer to the given file.
+func NewWithFile(input string, file string) *Lexer {
+	l := New(input)
+	l.file = file
+
+	return l
+}
+
+// Diagnostics returns the problems found while reading tokens, like strings
+// that are never closed.
+func (l *Lexer) Diagnostics() []diagnostics.Diagnostic {
+	return l.diagnostics
+}
+
+// report adds a diagnostic spanning from the start of the current token to the
+// current position.
+func (l *Lexer) report(code diagnostics.Code, message string, hint string) {
+	l.reportTo(l.readColumn, code, message, hint)
+}
+
+// reportTo adds a diagnostic spanning from the start of the current token to
+// the column on the current line.
+func (l *Lexer) reportTo(column int, code diagnostics.Code, message string, hint string) {
+	start := token.TokenPosition{File: l.file, Line: l.tokenLine, Column: l.column}
+	end := token.TokenPosition{File: l.file, Line: l.line, Column: column}
+
+	l.diagnostics = append(l.diagnostics, diagnostics.Diagnostic{
+		Code:     code,
+		Severity: diagnostics.Error,
+		Span:     diagnostics.Span{Start: start, End: end},
+		Message:  message,
+		Hint:     hint,
+	})
+}
+
+// Comments returns the comments that were skipped while reading tokens.
+func (l *Lexer) Comments() []token.Comment {
+	return l.comments
+}
+
+// addComment records the comment that starts at position start and ends at
+// the current position.
+func (l *Lexer) addComment(start int) {
+	end := l.position
+	if end > len(l.input) {
+		end = len(l.input)
+	}
+
+	l.comments = append(l.comments, token.Comment{
+		Text:     strings.TrimRight(string(l.input[start:end]), " \t\r"),
+		Position: token.TokenPosition{File: l.file, Line: l.tokenLine, Column: l.column},
+		Trailing: l.lastLine == l.tokenLine,
+	})
+}
+
 // NextToken will try to parse one ore more characters and return the
 // corresponding token
 func (l *Lexer) NextToken() token.Token {
@@ -37,6 +103,7 @@ func (l *Lexer) NextToken() token.Token {
 
 	l.skipWhitespace()
 	
+	l.tokenLine = l.line
 	l.column = l.readColumn
 
 	if l.ch == '/' && l.peekChar() == '/' {
@@ -55,6 +122,10 @@ func (l *Lexer) NextToken() token.Token {
 			ch := l.ch
 			l.readChar()
 			tok = l.newToken(token.EQ, string(ch)+string(l.ch))
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok = l.newToken(token.ARROW, string(ch)+string(l.ch))
 		} else {
 			tok = l.newTokenFromRune(token.ASSIGN, l.ch)
 		}
@@ -134,6 +205,14 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = l.newTokenFromRune(token.GT, l.ch)
 		}
+	case '#':
+		if l.peekChar() == '{' {
+			ch := l.ch
+			l.readChar()
+			tok = l.newToken(token.LSET, string(ch)+string(l.ch))
+		} else {
+			tok = l.newTokenFromRune(token.ILLEGAL, l.ch)
+		}
 	case '&':
 		if l.peekChar() == '&' {
 			ch := l.ch
@@ -147,6 +226,23 @@ func (l *Lexer) NextToken() token.Token {
 			ch := l.ch
 			l.readChar()
 			tok = l.newToken(token.OR, string(ch)+string(l.ch))
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok = l.newToken(token.PIPE, string(ch)+string(l.ch))
+		} else {
+			tok = l.newTokenFromRune(token.ILLEGAL, l.ch)
+		}
+	case '.':
+		if l.peekChar() == '.' {
+			ch := l.ch
+			l.readChar()
+			if l.peekChar() == '<' {
+				l.readChar()
+				tok = l.newToken(token.RANGE_EXCLUSIVE, string(ch)+"."+string(l.ch))
+			} else {
+				tok = l.newToken(token.RANGE, string(ch)+string(l.ch))
+			}
 		} else {
 			tok = l.newTokenFromRune(token.ILLEGAL, l.ch)
 		}
@@ -158,6 +254,13 @@ func (l *Lexer) NextToken() token.Token {
 		tok = l.newTokenFromRune(token.COLON, l.ch)
 	case '"':
 		tok = l.newToken(token.STRING, l.readStringLiteral())
+	case '\'':
+		literal, ok := l.readCharLiteral()
+		if ok {
+			tok = l.newToken(token.CHAR, literal)
+		} else {
+			tok = l.newToken(token.ILLEGAL, literal)
+		}
 	case 0:
 		tok = l.newToken(token.EOF, "")
 	default:
@@ -190,7 +293,6 @@ func (l *Lexer) readChar() {
 
 	if l.ch == '\n' || l.ch == '\r' {
 		l.line++
-		l.column = 0
 		l.readColumn = 0
 	}
 }
@@ -209,21 +311,28 @@ func (l *Lexer) skipWhitespace() {
 }
 
 func (l *Lexer) skipSinglLineComments() {
+	start := l.position
+
 	// consume characters until we encounter a newline or the end of the file
-	for l.ch != '\n' && l.ch != 0 {
+	for l.ch != '\n' && l.ch != '\r' && l.ch != 0 {
 		l.readChar()
 	}
 
+	l.addComment(start)
+
 	l.skipWhitespace()
 }
 
 func (l *Lexer) skipMultiLineComments() {
+	start := l.position
+
 	// consume characters until we encounter the end of the comment or EOF
 	found := false
 
 	for !found {
 		// abort if we are at EOF.
 		if l.ch == 0 {
+			l.report(diagnostics.UnterminatedComment, "unterminated comment", "close the comment with '*/'")
 			found = true
 		}
 
@@ -238,6 +347,7 @@ func (l *Lexer) skipMultiLineComments() {
 		l.readChar()
 	}
 
+	l.addComment(start)
 	l.skipWhitespace()
 }
 
@@ -251,10 +361,13 @@ func (l *Lexer) readItentifier() string {
 	return string(l.input[position:l.position])
 }
 
+// readNumber reads a decimal, hexadecimal (0x), octal (0o) or binary (0b)
+// literal. Letters and underscores are part of the literal, so the parser can
+// report malformed literals like 0b102 or 1__000 as a whole.
 func (l *Lexer) readNumber() string {
 	position := l.position
 	
-	for isDigit(l.ch) {
+	for isDigit(l.ch) || isLetter(l.ch) {
 		l.readChar()
 	}
 
@@ -267,7 +380,12 @@ func (l *Lexer) readStringLiteral() string {
 	for {
 		l.readChar()
 
-		if l.ch == '"' || l.ch == 0 {
+		if l.ch == 0 {
+			l.report(diagnostics.UnterminatedString, "unterminated string literal", "close the string with '\"'")
+			break
+		}
+
+		if l.ch == '"' {
 			break
 		}
 
@@ -297,20 +415,56 @@ func (l *Lexer) readStringLiteral() string {
 	return out
 }
 
+// readCharLiteral returns the source between the quotes of a character literal.
+// Escape sequences are kept, the parser decodes and validates them. A literal
+// that is not closed on its line is reported and returned with its opening
+// quote.
+func (l *Lexer) readCharLiteral() (string, bool) {
+	position := l.position + 1
+
+	for {
+		if l.peekLineEnd() {
+			// The span ends behind the last character of the literal
+			l.reportTo(l.readColumn+1, diagnostics.UnterminatedChar, "unterminated character literal", "close the character with \"'\"")
+			return string(l.input[position-1 : l.readPosition]), false
+		}
+
+		l.readChar()
+
+		if l.ch == '\'' {
+			break
+		}
+
+		if l.ch == '\\' && !l.peekLineEnd() {
+			l.readChar()
+		}
+	}
+
+	return string(l.input[position:l.position]), true
+}
+
+// peekLineEnd reports whether the next character ends the line or the input.
+func (l *Lexer) peekLineEnd() bool {
+	next := l.peekChar()
+	return next == '\n' || next == '\r' || next == 0
+}
+
 func (l *Lexer) newTokenFromRune(tokenType token.TokenType, ch rune) token.Token {
 	return l.newToken(tokenType, string(ch))
 }
 
 func (l *Lexer) newToken(tokenType token.TokenType, tokenLiteral string) token.Token {
+	l.lastLine = l.tokenLine
+
 	return token.Token{
 		Type:     tokenType,
 		Literal:  tokenLiteral,
-		Position: token.TokenPosition{Line: l.line, Column: l.column},
+		Position: token.TokenPosition{File: l.file, Line: l.tokenLine, Column: l.column},
 	}
 }
 
 func isLetter(ch rune) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
 func isDigit(ch rune) bool {
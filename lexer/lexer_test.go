@@ -3,6 +3,7 @@ package lexer
 import (
 	"testing"
 
+	"github.com/rhwilr/lemur/diagnostics"
 	"github.com/rhwilr/lemur/token"
 )
 
@@ -49,6 +50,11 @@ $
 "foo bar"
 [1, 2];
 {"foo": "bar"}
+1..10
+0..<n
+[x for x in xs]
+x => x
+xs |> f
 `
 
 	tests := []struct {
@@ -202,6 +208,28 @@ $
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+
+		{token.INT, "1"},
+		{token.RANGE, ".."},
+		{token.INT, "10"},
+		{token.INT, "0"},
+		{token.RANGE_EXCLUSIVE, "..<"},
+		{token.IDENT, "n"},
+
+		{token.LBRACKET, "["},
+		{token.IDENT, "x"},
+		{token.FOR, "for"},
+		{token.IDENT, "x"},
+		{token.IN, "in"},
+		{token.IDENT, "xs"},
+		{token.RBRACKET, "]"},
+
+		{token.IDENT, "x"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "x"},
+		{token.IDENT, "xs"},
+		{token.PIPE, "|>"},
+		{token.IDENT, "f"},
 		{token.EOF, ""},
 
 		{token.EOF, ""},
@@ -336,3 +364,167 @@ func TestStringParsing(t *testing.T) {
 	}
 }
 
+func TestNumberLiterals(t *testing.T) {
+	input := `0x1F 0o755 0b1010 1_000_000 1..10`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "0x1F"},
+		{token.INT, "0o755"},
+		{token.INT, "0b1010"},
+		{token.INT, "1_000_000"},
+		{token.INT, "1"},
+		{token.RANGE, ".."},
+		{token.INT, "10"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestCharLiterals(t *testing.T) {
+	input := `'a' '\n' '\'' 'ö' let 名前 = 'x';`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.CHAR, "a"},
+		{token.CHAR, "\\n"},
+		{token.CHAR, "\\'"},
+		{token.CHAR, "ö"},
+		{token.LET, "let"},
+		{token.IDENT, "名前"},
+		{token.ASSIGN, "="},
+		{token.CHAR, "x"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestUnterminatedCharLiterals(t *testing.T) {
+	input := "'ab\n'c' '"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.ILLEGAL, "'ab"},
+		{token.CHAR, "c"},
+		{token.ILLEGAL, "'"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	if len(l.Diagnostics()) != 2 {
+		t.Fatalf("expected 2 diagnostics, got=%d", len(l.Diagnostics()))
+	}
+	for _, d := range l.Diagnostics() {
+		if d.Code != diagnostics.UnterminatedChar {
+			t.Errorf("wrong code. want=%s, got=%s", diagnostics.UnterminatedChar, d.Code)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  x + 'a'\n  y\nz"
+
+	tests := []struct {
+		expectedLiteral  string
+		expectedPosition string
+	}{
+		{"let", "main.lem:1:1"},
+		{"x", "main.lem:1:5"},
+		{"=", "main.lem:1:7"},
+		{"5", "main.lem:1:9"},
+		{";", "main.lem:1:10"},
+		{"x", "main.lem:2:3"},
+		{"+", "main.lem:2:5"},
+		{"a", "main.lem:2:7"},
+		{"y", "main.lem:3:3"},
+		{"z", "main.lem:4:1"},
+	}
+
+	l := NewWithFile(input, "main.lem")
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Position.String() != tt.expectedPosition {
+			t.Fatalf("tests[%d] - Position wrong, expected=%q, got=%q", i, tt.expectedPosition, tok.Position)
+		}
+	}
+
+	if pos := New("x").NextToken().Position.String(); pos != "1:1" {
+		t.Errorf("position without file wrong, expected=%q, got=%q", "1:1", pos)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// first\nlet x = 5; // trailing\n/* block\n comment */ x"
+
+	tests := []struct {
+		expectedText     string
+		expectedPosition string
+		expectedTrailing bool
+	}{
+		{"// first", "1:1", false},
+		{"// trailing", "2:12", true},
+		{"/* block\n comment */", "3:1", false},
+	}
+
+	l := New(input)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	comments := l.Comments()
+	if len(comments) != len(tests) {
+		t.Fatalf("wrong number of comments. expected=%d, got=%d", len(tests), len(comments))
+	}
+
+	for i, tt := range tests {
+		c := comments[i]
+		if c.Text != tt.expectedText {
+			t.Errorf("tests[%d] - Text wrong, expected=%q, got=%q", i, tt.expectedText, c.Text)
+		}
+		if c.Position.String() != tt.expectedPosition {
+			t.Errorf("tests[%d] - Position wrong, expected=%q, got=%q", i, tt.expectedPosition, c.Position)
+		}
+		if c.Trailing != tt.expectedTrailing {
+			t.Errorf("tests[%d] - Trailing wrong, expected=%t, got=%t", i, tt.expectedTrailing, c.Trailing)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rhwilr/lemur/types"
+)
+
+// builtinDocs describes the builtin functions, shown when hovering over them.
+var builtinDocs = map[string]string{
+	"len":           "Returns the length of an Array or String. The length of a String is the number of characters, not bytes.",
+	"first":         "Returns the first element in an Array.",
+	"last":          "Returns the last element in an Array.",
+	"rest":          "Returns a new Array, containing every element of the passed Array, except the first element.",
+	"push":          "Appends an element to the end of an Array.",
+	"read":          "Reads a string from stdin.",
+	"print":         "Prints its arguments to stdout.",
+	"println":       "Prints its arguments to stdout, including a newline at the end.",
+	"env":           "Returns a Hash with all environment variables. If a String is provided, it only returns the value of that environment variable.",
+	"next":          "Resumes a Generator and returns the next value it yields, or `null` once the generator has finished.",
+	"channel":       "Creates a new Channel. An optional Integer sets how many values the channel can buffer.",
+	"send":          "Sends a value to a Channel. Blocks until the value is received or buffered.",
+	"recv":          "Receives the next value from a Channel, or `null` if the channel is closed.",
+	"close":         "Closes a Channel.",
+	"select":        "Waits for any of the Channels in the passed Array to receive a value and returns an Array with the index of that channel and the value.",
+	"wait":          "Waits for a spawned Task to finish and returns its result.",
+	"setTimeout":    "Calls a Function once after the given number of milliseconds. Returns a Timer.",
+	"setInterval":   "Calls a Function repeatedly, every given number of milliseconds. Returns a Timer.",
+	"clearTimeout":  "Stops a Timer.",
+	"clearInterval": "Stops a Timer.",
+	"sleep":         "Pauses the program for the given number of milliseconds.",
+	"ord":           "Returns the Unicode code point of a Char or a single character String.",
+	"chr":           "Returns the Char for a Unicode code point.",
+	"keys":          "Returns an Array with the keys of a Hash in insertion order.",
+	"add":           "Returns a new Set with the element added.",
+	"remove":        "Returns a new Set with the element removed.",
+	"has":           "Returns `true` if the Set contains the element.",
+	"union":         "Returns a new Set with the elements of both Sets.",
+	"intersection":  "Returns a new Set with the elements that are in both Sets.",
+	"difference":    "Returns a new Set with the elements of the first Set that are not in the second.",
+	"freeze":        "Deep-freezes an Array, Hash or Set and returns it.",
+	"frozen":        "Returns `true` if the value is frozen.",
+	"type":          "Returns the type of a value as a String, e.g. `\"INTEGER\"` or `\"FUNCTION\"`. Builtin functions are `\"BUILTIN\"`.",
+	"arity":         "Returns the number of parameters of a function defined in the program.",
+	"name":          "Returns the name of a function. Anonymous functions have no name.",
+	"params":        "Returns the parameter names of a function defined in the program.",
+	"error":         "Creates an error value with a message and optional data.",
+	"isError":       "Returns `true` if the value is an error.",
+	"errorMessage":  "Returns the message of an error.",
+	"errorData":     "Returns the data attached to an error.",
+	"wrap":          "Returns a new error that wraps the error with an additional message.",
+	"unwrap":        "Returns the error wrapped by an error, or `null`.",
+}
+
+// builtinSignature returns the signature of a builtin, like `len(any): int`.
+func builtinSignature(name string) string {
+	s, ok := types.Builtins[name]
+	if !ok {
+		return name + "(...)"
+	}
+
+	params := make([]string, len(s.Parameters))
+	for i, p := range s.Parameters {
+		params[i] = string(p)
+	}
+	if s.Variadic && len(params) > 0 {
+		params[len(params)-1] += "..."
+	}
+
+	return fmt.Sprintf("%s(%s): %s", name, strings.Join(params, ", "), s.Return)
+}
@@ -0,0 +1,396 @@
+package lsp
+
+import (
+	"math"
+	"strings"
+
+	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/token"
+)
+
+type declarationKind int
+
+const (
+	variableDeclaration declarationKind = iota
+	constantDeclaration
+	parameterDeclaration
+	functionDeclaration
+	comprehensionDeclaration
+)
+
+// declaration is a name introduced by a let, const, parameter, function
+// definition or comprehension.
+type declaration struct {
+	name  *ast.Identifier
+	kind  declarationKind
+	scope *scope
+
+	value    ast.Expression       // the value of a let or const
+	function *ast.FunctionLiteral // the function the name is bound to
+}
+
+// scope is a symbol table of the compiler together with the declarations of
+// its names and the part of the source it covers.
+type scope struct {
+	symbols      *compiler.SymbolTable
+	declarations map[string]*declaration
+	outer        *scope
+	start, end   token.TokenPosition
+}
+
+func (s *scope) contains(pos token.TokenPosition) bool {
+	return !before(pos, s.start) && !before(s.end, pos)
+}
+
+// reference is the use of a name. The declaration is nil for builtins.
+type reference struct {
+	ident       *ast.Identifier
+	declaration *declaration
+}
+
+// function is a named function, shown as a document symbol.
+type function struct {
+	name     *ast.Identifier
+	node     *ast.FunctionLiteral
+	start    token.TokenPosition
+	children []*function
+}
+
+// index knows where the names of a program are declared and used.
+type index struct {
+	lines []string
+
+	scope        *scope
+	declarations []*declaration
+	references   []reference
+
+	functions []*function
+	parent    *function
+}
+
+func newIndex(program *ast.Program, lines []string) *index {
+	ix := &index{
+		lines: lines,
+		scope: &scope{
+			symbols:      compiler.NewBuiltinSymbolTable(),
+			declarations: map[string]*declaration{},
+			end:          token.TokenPosition{Line: math.MaxInt32},
+		},
+	}
+
+	for _, stmt := range program.Statements {
+		ix.statement(stmt)
+	}
+
+	return ix
+}
+
+func (ix *index) statement(node ast.Statement) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		ix.binding(node.Token, node.Name, variableDeclaration, node.Value)
+
+	case *ast.ConstStatement:
+		ix.binding(node.Token, node.Name, constantDeclaration, node.Value)
+
+	case *ast.ReturnStatement:
+		ix.expression(node.ReturnValue)
+
+	case *ast.ExpressionStatement:
+		ix.expression(node.Expression)
+
+	case *ast.BlockStatement:
+		if node != nil {
+			for _, stmt := range node.Statements {
+				ix.statement(stmt)
+			}
+		}
+	}
+}
+
+func (ix *index) binding(tok token.Token, name *ast.Identifier, kind declarationKind, value ast.Expression) {
+	if name == nil {
+		return
+	}
+
+	d := ix.define(name, kind)
+	d.value = value
+
+	if fn, ok := value.(*ast.FunctionLiteral); ok {
+		d.function = fn
+		ix.function(fn, name, tok.Position)
+		return
+	}
+
+	ix.expression(value)
+}
+
+func (ix *index) expression(node ast.Expression) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		ix.resolve(node)
+
+	case *ast.PrefixExpression:
+		ix.expression(node.Right)
+
+	case *ast.InfixExpression:
+		ix.expression(node.Left)
+		ix.expression(node.Right)
+
+	case *ast.IfExpression:
+		ix.expression(node.Condition)
+		ix.statement(node.Consequence)
+		if node.Alternative != nil {
+			ix.statement(node.Alternative)
+		}
+
+	case *ast.WhileLoopExpression:
+		ix.expression(node.Condition)
+		ix.statement(node.Consequence)
+
+	case *ast.AssignStatement:
+		if node.Name != nil {
+			ix.resolve(node.Name)
+		}
+		ix.expression(node.Value)
+
+	case *ast.PostfixExpression:
+		ix.resolve(node.Name)
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			ix.expression(el)
+		}
+
+	case *ast.SetLiteral:
+		for _, el := range node.Elements {
+			ix.expression(el)
+		}
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			ix.expression(pair.Key)
+			ix.expression(pair.Value)
+		}
+
+	case *ast.IndexExpression:
+		ix.expression(node.Left)
+		ix.expression(node.Index)
+
+	case *ast.ArrayComprehension:
+		ix.comprehension(node.Token, node.Variables, node.Iterable, node.Condition, node.Element)
+
+	case *ast.HashComprehension:
+		ix.comprehension(node.Token, node.Variables, node.Iterable, node.Condition, node.Key, node.Value)
+
+	case *ast.FunctionLiteral:
+		ix.function(node, nil, node.Token.Position)
+
+	case *ast.YieldExpression:
+		ix.expression(node.Value)
+
+	case *ast.SpawnExpression:
+		if node.Call != nil {
+			ix.expression(node.Call)
+		}
+
+	case *ast.CallExpression:
+		ix.expression(node.Function)
+		for _, arg := range node.Arguments {
+			ix.expression(arg)
+		}
+	}
+}
+
+// function indexes a function literal. name is the name of the let or const
+// the function is bound to.
+func (ix *index) function(node *ast.FunctionLiteral, name *ast.Identifier, start token.TokenPosition) {
+	if node.Define {
+		name = ix.functionName(node)
+		d := ix.define(name, functionDeclaration)
+		d.function = node
+	}
+
+	parent := ix.parent
+	if name != nil {
+		fn := &function{name: name, node: node, start: start}
+		if parent == nil {
+			ix.functions = append(ix.functions, fn)
+		} else {
+			parent.children = append(parent.children, fn)
+		}
+		ix.parent = fn
+	}
+
+	end := node.Body.Rbrace
+	if end.Line == 0 {
+		// The body of an arrow function may have no braces.
+		end = ix.scope.end
+	}
+
+	ix.enterScope(node.Token.Position, end)
+
+	if node.Name != "" {
+		ix.scope.symbols.DefineFunctionName(node.Name)
+	}
+
+	for _, p := range node.Parameters {
+		ix.define(p, parameterDeclaration)
+		if value, ok := node.Defaults[p.Value]; ok {
+			ix.expression(value)
+		}
+	}
+
+	ix.statement(node.Body)
+
+	ix.leaveScope()
+	ix.parent = parent
+}
+
+func (ix *index) comprehension(tok token.Token, variables []*ast.Identifier, iterable ast.Expression, condition ast.Expression, values ...ast.Expression) {
+	ix.expression(iterable)
+
+	// The variables are only visible inside the comprehension, whose end is
+	// not known. They are not offered for completion.
+	ix.enterScope(tok.Position, tok.Position)
+	for _, v := range variables {
+		ix.define(v, comprehensionDeclaration)
+	}
+
+	ix.expression(condition)
+	for _, value := range values {
+		ix.expression(value)
+	}
+
+	ix.leaveScope()
+}
+
+// functionName returns the name of a function definition as an identifier.
+// The parser does not keep the position of the name, it is found in the
+// source after the 'function' keyword.
+func (ix *index) functionName(node *ast.FunctionLiteral) *ast.Identifier {
+	pos := node.Token.Position
+
+	if pos.Line >= 1 && pos.Line <= len(ix.lines) {
+		line := []rune(lineText(ix.lines, pos.Line-1))
+		column := pos.Column - 1 + len(node.Token.Literal)
+
+		for column < len(line) && (line[column] == ' ' || line[column] == '\t') {
+			column++
+		}
+
+		if strings.HasPrefix(string(line[column:]), node.Name) {
+			pos.Column = column + 1
+		}
+	}
+
+	return &ast.Identifier{
+		Token: token.Token{Type: token.IDENT, Literal: node.Name, Position: pos},
+		Value: node.Name,
+	}
+}
+
+/*
+** Scopes
+ */
+func (ix *index) enterScope(start, end token.TokenPosition) {
+	ix.scope = &scope{
+		symbols:      compiler.NewEnclosedSymbolTable(ix.scope.symbols),
+		declarations: map[string]*declaration{},
+		outer:        ix.scope,
+		start:        start,
+		end:          end,
+	}
+}
+
+func (ix *index) leaveScope() {
+	ix.scope = ix.scope.outer
+}
+
+func (ix *index) define(name *ast.Identifier, kind declarationKind) *declaration {
+	symbolType := compiler.VariableType
+	if kind == constantDeclaration {
+		symbolType = compiler.ConstantType
+	}
+
+	// Redeclarations are reported by the compiler, the latest one wins.
+	ix.scope.symbols.Define(name.Value, symbolType)
+
+	d := &declaration{name: name, kind: kind, scope: ix.scope}
+	ix.scope.declarations[name.Value] = d
+	ix.declarations = append(ix.declarations, d)
+
+	return d
+}
+
+// resolve records the use of ident. Names the compiler can not resolve are
+// left out.
+func (ix *index) resolve(ident *ast.Identifier) {
+	symbol, ok := ix.scope.symbols.Resolve(ident.Value)
+	if !ok {
+		return
+	}
+
+	r := reference{ident: ident}
+	if symbol.Scope != compiler.BuiltinScope {
+		for s := ix.scope; s != nil && r.declaration == nil; s = s.outer {
+			r.declaration = s.declarations[ident.Value]
+		}
+	}
+
+	ix.references = append(ix.references, r)
+}
+
+/*
+** Queries
+ */
+
+// at returns the identifier at pos and its declaration.
+func (ix *index) at(pos token.TokenPosition) (*ast.Identifier, *declaration) {
+	for _, d := range ix.declarations {
+		if covers(d.name, pos) {
+			return d.name, d
+		}
+	}
+
+	for _, r := range ix.references {
+		if covers(r.ident, pos) {
+			return r.ident, r.declaration
+		}
+	}
+
+	return nil, nil
+}
+
+// visible returns the declarations that can be used at pos, by name.
+func (ix *index) visible(pos token.TokenPosition) map[string]*declaration {
+	found := map[string]*declaration{}
+
+	for _, d := range ix.declarations {
+		if !d.scope.contains(pos) || !before(d.name.Pos(), pos) {
+			continue
+		}
+
+		// Inner scopes shadow the outer ones.
+		if other, ok := found[d.name.Value]; ok && before(d.scope.start, other.scope.start) {
+			continue
+		}
+
+		found[d.name.Value] = d
+	}
+
+	return found
+}
+
+// covers reports whether pos is on ident, or right behind it.
+func covers(ident *ast.Identifier, pos token.TokenPosition) bool {
+	start := ident.Pos()
+	length := len([]rune(ident.Value))
+
+	return pos.Line == start.Line && pos.Column >= start.Column && pos.Column <= start.Column+length
+}
+
+func before(a, b token.TokenPosition) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
@@ -0,0 +1,342 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rhwilr/lemur/transport"
+)
+
+const uri = "file:///main.lem"
+
+const source = `let counter = 0;
+function add(a: int, b) {
+  let sum = a + b;
+  function inner() { sum }
+  inner()
+}
+let total = add(counter, 2);
+println(len("abc"), total);
+`
+
+// client records the messages of a session, the server answers them all at
+// once.
+type client struct {
+	in bytes.Buffer
+	id int
+}
+
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+func (c *client) request(method string, params interface{}) int {
+	c.id++
+	transport.WriteMessage(&c.in, map[string]interface{}{"jsonrpc": "2.0", "id": c.id, "method": method, "params": params})
+	return c.id
+}
+
+func (c *client) notify(method string, params interface{}) {
+	transport.WriteMessage(&c.in, map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (c *client) open(text string) {
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "lemur", "version": 1, "text": text},
+	})
+}
+
+func (c *client) at(method string, line, character int) int {
+	return c.request(method, map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": character},
+	})
+}
+
+// run plays the session and returns the responses by id and the
+// notifications of the server.
+func (c *client) run(t *testing.T) (map[int]message, []message) {
+	c.request("shutdown", nil)
+	c.notify("exit", nil)
+
+	var out bytes.Buffer
+	if err := NewServer(&c.in, &out).Run(); err != nil {
+		t.Fatalf("server failed: %s", err)
+	}
+
+	responses := map[int]message{}
+	notifications := []message{}
+
+	r := bufio.NewReader(&out)
+	for {
+		content, err := transport.ReadMessage(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid message: %s", err)
+		}
+
+		var m message
+		if err := json.Unmarshal(content, &m); err != nil {
+			t.Fatalf("invalid message %s: %s", content, err)
+		}
+
+		if m.ID != nil {
+			responses[*m.ID] = m
+		} else {
+			notifications = append(notifications, m)
+		}
+	}
+
+	return responses, notifications
+}
+
+func TestInitialize(t *testing.T) {
+	c := &client{}
+	id := c.request("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}})
+	unknown := c.request("workspace/unknown", nil)
+
+	responses, _ := c.run(t)
+
+	var result struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	json.Unmarshal(responses[id].Result, &result)
+
+	for _, capability := range []string{"textDocumentSync", "hoverProvider", "definitionProvider", "documentSymbolProvider", "completionProvider"} {
+		if _, ok := result.Capabilities[capability]; !ok {
+			t.Errorf("capability %s is missing", capability)
+		}
+	}
+
+	if err := responses[unknown].Error; err == nil || err.Code != methodNotFound {
+		t.Errorf("expected method not found, got=%+v", err)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	c := &client{}
+	c.notify("exit", nil)
+
+	if err := NewServer(&c.in, &bytes.Buffer{}).Run(); err != ErrNoShutdown {
+		t.Errorf("expected ErrNoShutdown, got=%v", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedCodes []string
+		expectedRange Range
+	}{
+		{source, []string{}, Range{}},
+		{"let x = 5\nlet y = ;", []string{"P003", "P002"}, Range{Start: Position{Line: 0, Character: 9}, End: Position{Line: 0, Character: 9}}},
+		{"let x = 1;\nprintln(y);", []string{"C001"}, Range{Start: Position{Line: 1, Character: 8}, End: Position{Line: 1, Character: 8}}},
+		{"let s = \"ä😀\"; let x = ;", []string{"P002"}, Range{Start: Position{Line: 0, Character: 23}, End: Position{Line: 0, Character: 24}}},
+		{"let m = macro() { println(\"HELLO\"); quote(1) };\nm();", []string{}, Range{}},
+		{"let m = macro() { while (true) { 1 } };\nm();", []string{}, Range{}},
+		{"let m = macro(x) { quote(unquote(x) + 1) };\nlet y = m(2);\nprintln(z);", []string{"C001"}, Range{Start: Position{Line: 2, Character: 8}, End: Position{Line: 2, Character: 8}}},
+	}
+
+	for _, tt := range tests {
+		c := &client{}
+		c.open(tt.input)
+		_, notifications := c.run(t)
+
+		if len(notifications) != 1 || notifications[0].Method != "textDocument/publishDiagnostics" {
+			t.Fatalf("input %q: expected diagnostics, got=%+v", tt.input, notifications)
+		}
+
+		var params publishDiagnosticsParams
+		json.Unmarshal(notifications[0].Params, &params)
+
+		if len(params.Diagnostics) != len(tt.expectedCodes) {
+			t.Errorf("input %q: wrong number of diagnostics. want=%d, got=%+v", tt.input, len(tt.expectedCodes), params.Diagnostics)
+			continue
+		}
+
+		for i, code := range tt.expectedCodes {
+			if params.Diagnostics[i].Code != code {
+				t.Errorf("input %q: wrong code. want=%s, got=%s", tt.input, code, params.Diagnostics[i].Code)
+			}
+		}
+
+		if len(params.Diagnostics) > 0 && params.Diagnostics[0].Range != tt.expectedRange {
+			t.Errorf("input %q: wrong range. want=%+v, got=%+v", tt.input, tt.expectedRange, params.Diagnostics[0].Range)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	tests := []struct {
+		line, character int
+		expected        string
+	}{
+		{7, 9, "len(any): int"},
+		{7, 9, "Returns the length of an Array or String."},
+		{6, 18, "let counter: int = 0"},
+		{1, 10, "function add(a: int, b: any): any"},
+		{2, 16, "parameter b: any"},
+		{6, 5, "let total: any"},
+		{3, 21, "let sum: any"},
+	}
+
+	c := &client{}
+	c.open(source)
+	ids := make([]int, len(tests))
+	for i, tt := range tests {
+		ids[i] = c.at("textDocument/hover", tt.line, tt.character)
+	}
+	blank := c.at("textDocument/hover", 0, 15)
+
+	responses, _ := c.run(t)
+
+	for i, tt := range tests {
+		var hover Hover
+		json.Unmarshal(responses[ids[i]].Result, &hover)
+
+		if !strings.Contains(hover.Contents.Value, tt.expected) {
+			t.Errorf("hover at %d:%d: expected %q, got=%q", tt.line, tt.character, tt.expected, hover.Contents.Value)
+		}
+	}
+
+	if string(responses[blank].Result) != "null" {
+		t.Errorf("expected no hover, got=%s", responses[blank].Result)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	tests := []struct {
+		line, character int
+		expected        Range
+	}{
+		{6, 18, Range{Start: Position{Line: 0, Character: 4}, End: Position{Line: 0, Character: 11}}},
+		{6, 13, Range{Start: Position{Line: 1, Character: 9}, End: Position{Line: 1, Character: 12}}},
+		{3, 21, Range{Start: Position{Line: 2, Character: 6}, End: Position{Line: 2, Character: 9}}},
+		{4, 3, Range{Start: Position{Line: 3, Character: 11}, End: Position{Line: 3, Character: 16}}},
+		{2, 12, Range{Start: Position{Line: 1, Character: 13}, End: Position{Line: 1, Character: 14}}},
+	}
+
+	c := &client{}
+	c.open(source)
+	ids := make([]int, len(tests))
+	for i, tt := range tests {
+		ids[i] = c.at("textDocument/definition", tt.line, tt.character)
+	}
+	builtin := c.at("textDocument/definition", 7, 9)
+
+	responses, _ := c.run(t)
+
+	for i, tt := range tests {
+		var location Location
+		json.Unmarshal(responses[ids[i]].Result, &location)
+
+		if location.URI != uri || location.Range != tt.expected {
+			t.Errorf("definition at %d:%d: want=%+v, got=%+v", tt.line, tt.character, tt.expected, location)
+		}
+	}
+
+	if string(responses[builtin].Result) != "null" {
+		t.Errorf("expected no definition for a builtin, got=%s", responses[builtin].Result)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := &client{}
+	c.open(source + "let double = x => x * 2;\n")
+	id := c.request("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": uri}})
+
+	responses, _ := c.run(t)
+
+	var symbols []DocumentSymbol
+	json.Unmarshal(responses[id].Result, &symbols)
+
+	if len(symbols) != 2 || symbols[0].Name != "add" || symbols[1].Name != "double" {
+		t.Fatalf("wrong symbols: %+v", symbols)
+	}
+
+	add := symbols[0]
+	if add.Range != (Range{Start: Position{Line: 1, Character: 0}, End: Position{Line: 5, Character: 1}}) {
+		t.Errorf("wrong range for add: %+v", add.Range)
+	}
+
+	if len(add.Children) != 1 || add.Children[0].Name != "inner" {
+		t.Errorf("expected inner as child of add, got=%+v", add.Children)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c := &client{}
+	c.open(source)
+	inside := c.at("textDocument/completion", 4, 2)
+	outside := c.at("textDocument/completion", 7, 0)
+
+	responses, _ := c.run(t)
+
+	labels := func(id int) map[string]CompletionItem {
+		var items []CompletionItem
+		json.Unmarshal(responses[id].Result, &items)
+
+		found := map[string]CompletionItem{}
+		for _, item := range items {
+			found[item.Label] = item
+		}
+		return found
+	}
+
+	in := labels(inside)
+	for _, name := range []string{"counter", "add", "a", "b", "sum", "inner", "len", "println"} {
+		if _, ok := in[name]; !ok {
+			t.Errorf("expected %s to be completed inside of add", name)
+		}
+	}
+	if _, ok := in["total"]; ok {
+		t.Errorf("total is declared after the cursor")
+	}
+	if in["inner"].Kind != functionCompletion || in["counter"].Kind != variableCompletion {
+		t.Errorf("wrong kinds: %+v %+v", in["inner"], in["counter"])
+	}
+
+	out := labels(outside)
+	for _, name := range []string{"a", "sum", "inner"} {
+		if _, ok := out[name]; ok {
+			t.Errorf("%s is not visible outside of add", name)
+		}
+	}
+	if _, ok := out["total"]; !ok {
+		t.Errorf("expected total to be completed")
+	}
+}
+
+func TestIncompleteDocuments(t *testing.T) {
+	tests := []string{"let ", "const ", "let = 1;", "let y: = 3;", "function f() { let }", "let x = 1;\nconst "}
+
+	for _, input := range tests {
+		c := &client{}
+		c.open(input)
+		hover := c.at("textDocument/hover", 0, 4)
+		completion := c.at("textDocument/completion", 0, 4)
+		symbols := c.request("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": uri}})
+
+		responses, notifications := c.run(t)
+
+		if len(notifications) != 1 || notifications[0].Method != "textDocument/publishDiagnostics" {
+			t.Errorf("input %q: expected diagnostics, got=%+v", input, notifications)
+		}
+
+		for _, id := range []int{hover, completion, symbols} {
+			if responses[id].Error != nil {
+				t.Errorf("input %q: unexpected error %+v", input, responses[id].Error)
+			}
+		}
+	}
+}
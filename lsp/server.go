@@ -0,0 +1,503 @@
+// Package lsp implements a Language Server Protocol server for Lemur. Editors
+// start it and talk to it over stdin and stdout. It reports syntax and
+// compiler errors, shows the documentation of builtins and the inferred types
+// of names on hover, jumps to definitions, lists the functions of a document
+// and completes names.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/build"
+	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/diagnostics"
+	"github.com/rhwilr/lemur/evaluator"
+	"github.com/rhwilr/lemur/lexer"
+	"github.com/rhwilr/lemur/object"
+	"github.com/rhwilr/lemur/optimizer"
+	"github.com/rhwilr/lemur/parser"
+	"github.com/rhwilr/lemur/token"
+	"github.com/rhwilr/lemur/transport"
+	"github.com/rhwilr/lemur/types"
+)
+
+// ErrNoShutdown is returned by Run if the client exits without asking the
+// server to shut down first.
+var ErrNoShutdown = errors.New("exit without shutdown")
+
+// document is an open text document and what is known about its program.
+type document struct {
+	text  string
+	lines []string
+	index *index
+	info  *types.Info
+}
+
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	documents map[string]*document
+	shutdown  bool
+}
+
+// NewServer returns a server that reads requests from in and writes the
+// responses to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		documents: map[string]*document{},
+	}
+}
+
+// Run handles requests until the client sends the exit notification.
+func (s *Server) Run() error {
+	for {
+		content, err := transport.ReadMessage(s.in)
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			s.reply(nil, nil, &responseError{Code: parseError, Message: err.Error()})
+			continue
+		}
+
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+
+		result, rerr := s.handle(req)
+		if req.ID != nil {
+			s.reply(req.ID, result, rerr)
+		}
+	}
+}
+
+func (s *Server) handle(req request) (interface{}, *responseError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // the full text is sent on every change
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"documentSymbolProvider": true,
+				"completionProvider":     map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "lemur", "version": build.FullVersion()},
+		}, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		s.open(params.TextDocument.URI, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.open(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.publish(params.TextDocument.URI, []Diagnostic{})
+
+	case "textDocument/hover":
+		return s.withPosition(req.Params, s.hover)
+
+	case "textDocument/definition":
+		return s.withPosition(req.Params, s.definition)
+
+	case "textDocument/completion":
+		return s.withPosition(req.Params, s.completion)
+
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return []DocumentSymbol{}, nil
+		}
+		return doc.symbols(doc.index.functions), nil
+
+	default:
+		if req.ID != nil {
+			return nil, &responseError{Code: methodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *Server) withPosition(raw json.RawMessage, handler func(uri string, doc *document, pos token.TokenPosition) interface{}) (interface{}, *responseError) {
+	var params textDocumentPositionParams
+	if err := decode(raw, &params); err != nil {
+		return nil, err
+	}
+
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil, nil
+	}
+
+	return handler(params.TextDocument.URI, doc, doc.fromLSP(params.Position)), nil
+}
+
+func decode(raw json.RawMessage, v interface{}) *responseError {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &responseError{Code: invalidParams, Message: err.Error()}
+	}
+
+	return nil
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) {
+	resp := response{JSONRPC: "2.0", ID: id, Error: rerr}
+
+	if rerr == nil {
+		content, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = &responseError{Code: parseError, Message: err.Error()}
+		} else {
+			resp.Result = content
+		}
+	}
+
+	transport.WriteMessage(s.out, resp)
+}
+
+func (s *Server) publish(uri string, found []Diagnostic) {
+	transport.WriteMessage(s.out, notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Diagnostics: found},
+	})
+}
+
+/*
+** Documents
+ */
+
+// open analyzes the text of a document and publishes its diagnostics.
+func (s *Server) open(uri string, text string) {
+	doc := &document{text: text, lines: strings.Split(text, "\n")}
+
+	p := parser.New(lexer.New(text))
+	program := p.ParseProgram()
+	doc.index = newIndex(program, doc.lines)
+	doc.info = infer(program)
+	s.documents[uri] = doc
+
+	found := []Diagnostic{}
+	for _, d := range check(text) {
+		found = append(found, doc.diagnostic(d))
+	}
+
+	s.publish(uri, found)
+}
+
+// infer returns the types of the program, which may be incomplete.
+func infer(program *ast.Program) (info *types.Info) {
+	defer func() {
+		if recover() != nil {
+			info = &types.Info{}
+		}
+	}()
+
+	return types.Infer(program)
+}
+
+// check returns the syntax errors of a program, or the first error of the
+// compiler if there are none.
+func check(text string) (found []diagnostics.Diagnostic) {
+	p := parser.New(lexer.New(text))
+	program := p.ParseProgram()
+
+	found = p.Diagnostics()
+	if diagnostics.HasErrors(found) {
+		return found
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			found = append(found, diagnostics.Diagnostic{Code: diagnostics.CompileError, Severity: diagnostics.Error, Message: fmt.Sprint(r)})
+		}
+	}()
+
+	skipMacroCalls(program)
+
+	program, err := optimizer.New(program).Optimize()
+	if err == nil {
+		err = compiler.New().Compile(program)
+	}
+
+	if compileErr, ok := err.(*compiler.Error); ok {
+		return append(found, compileErr.Diagnostic())
+	}
+	if err != nil {
+		return append(found, diagnostics.Diagnostic{Code: diagnostics.CompileError, Severity: diagnostics.Error, Message: err.Error()})
+	}
+
+	return found
+}
+
+// skipMacroCalls removes the macro definitions from the program and replaces
+// the calls of macros with an empty array. Expanding them would run the
+// macros inside the server, where they could print to the protocol or never
+// return.
+func skipMacroCalls(program *ast.Program) {
+	env := object.NewEnvironment()
+	evaluator.DefineMacros(program, env)
+
+	ast.Modify(program, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok {
+			return node
+		}
+
+		name, ok := call.Function.(*ast.Identifier)
+		if !ok {
+			return node
+		}
+
+		if macro, ok := env.Get(name.Value); ok {
+			if _, ok := macro.(*object.Macro); ok {
+				return &ast.ArrayLiteral{Token: call.Token, Elements: []ast.Expression{}}
+			}
+		}
+
+		return node
+	})
+}
+
+func (doc *document) diagnostic(d diagnostics.Diagnostic) Diagnostic {
+	severity := 1
+	switch d.Severity {
+	case diagnostics.Warning:
+		severity = 2
+	case diagnostics.Info:
+		severity = 3
+	}
+
+	return Diagnostic{
+		Range:    Range{Start: doc.toLSP(d.Span.Start), End: doc.toLSP(d.Span.End)},
+		Severity: severity,
+		Code:     string(d.Code),
+		Source:   "lemur",
+		Message:  d.Message,
+	}
+}
+
+// toLSP converts a position of the lexer, which counts lines and characters
+// from 1, to a position of the protocol.
+func (doc *document) toLSP(pos token.TokenPosition) Position {
+	if pos.Line < 1 {
+		return Position{}
+	}
+
+	line := []rune(lineText(doc.lines, pos.Line-1))
+	column := pos.Column - 1
+	if column > len(line) {
+		column = len(line)
+	}
+	if column < 0 {
+		column = 0
+	}
+
+	return Position{Line: pos.Line - 1, Character: utf16Length(string(line[:column]))}
+}
+
+func (doc *document) fromLSP(pos Position) token.TokenPosition {
+	line := lineText(doc.lines, pos.Line)
+	return token.TokenPosition{Line: pos.Line + 1, Column: runeOffset(line, pos.Character) + 1}
+}
+
+func (doc *document) identRange(ident *ast.Identifier) Range {
+	start := ident.Pos()
+	end := start
+	end.Column += len([]rune(ident.Value))
+
+	return Range{Start: doc.toLSP(start), End: doc.toLSP(end)}
+}
+
+/*
+** Requests
+ */
+func (s *Server) hover(uri string, doc *document, pos token.TokenPosition) interface{} {
+	ident, d := doc.index.at(pos)
+	if ident == nil {
+		return nil
+	}
+
+	var text string
+	if d == nil {
+		text = fmt.Sprintf("```lemur\n%s\n```", builtinSignature(ident.Value))
+		if docs, ok := builtinDocs[ident.Value]; ok {
+			text += "\n\n" + docs
+		}
+	} else {
+		text = fmt.Sprintf("```lemur\n%s\n```", doc.describe(d))
+	}
+
+	return Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: text},
+		Range:    doc.identRange(ident),
+	}
+}
+
+// describe returns the declaration of a name with its inferred type, and the
+// value of let and const declarations that are literals.
+func (doc *document) describe(d *declaration) string {
+	name := d.name.Value
+
+	if d.function != nil {
+		return doc.signature(name, d.function)
+	}
+
+	typ := doc.info.Types[d.name]
+	if typ == "" {
+		typ = types.Any
+	}
+
+	switch d.kind {
+	case parameterDeclaration:
+		return fmt.Sprintf("parameter %s: %s", name, typ)
+	case comprehensionDeclaration:
+		return fmt.Sprintf("%s: %s", name, typ)
+	}
+
+	keyword := "let"
+	if d.kind == constantDeclaration {
+		keyword = "const"
+	}
+
+	switch d.value.(type) {
+	case *ast.IntegerLiteral, *ast.BigIntegerLiteral, *ast.StringLiteral, *ast.CharLiteral, *ast.Boolean:
+		value := d.value.String()
+		if s, ok := d.value.(*ast.StringLiteral); ok {
+			value = fmt.Sprintf("%q", s.Value)
+		}
+		if c, ok := d.value.(*ast.CharLiteral); ok {
+			value = "'" + c.Token.Literal + "'"
+		}
+		return fmt.Sprintf("%s %s: %s = %s", keyword, name, typ, value)
+	}
+
+	return fmt.Sprintf("%s %s: %s", keyword, name, typ)
+}
+
+// signature returns the signature of a function with the types of its
+// parameters and result, like `function add(a: int, b: int): int`.
+func (doc *document) signature(name string, fn *ast.FunctionLiteral) string {
+	s := doc.info.Signatures[fn]
+
+	params := make([]string, len(fn.Parameters))
+	for i, p := range fn.Parameters {
+		params[i] = p.Value
+		if s != nil && i < len(s.Parameters) {
+			params[i] += ": " + string(s.Parameters[i])
+		}
+	}
+
+	result := ""
+	if s != nil {
+		result = ": " + string(s.Return)
+	}
+
+	return fmt.Sprintf("function %s(%s)%s", name, strings.Join(params, ", "), result)
+}
+
+func (s *Server) definition(uri string, doc *document, pos token.TokenPosition) interface{} {
+	_, d := doc.index.at(pos)
+	if d == nil {
+		return nil
+	}
+
+	return Location{URI: uri, Range: doc.identRange(d.name)}
+}
+
+func (s *Server) completion(uri string, doc *document, pos token.TokenPosition) interface{} {
+	items := []CompletionItem{}
+
+	visible := doc.index.visible(pos)
+	names := make([]string, 0, len(visible))
+	for name := range visible {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		d := visible[name]
+		item := CompletionItem{Label: name, Kind: variableCompletion, Detail: doc.describe(d)}
+
+		if d.function != nil {
+			item.Kind = functionCompletion
+		} else if d.kind == constantDeclaration {
+			item.Kind = constantCompletion
+		}
+
+		items = append(items, item)
+	}
+
+	for _, b := range object.Builtins {
+		if _, ok := visible[b.Name]; ok {
+			continue
+		}
+
+		items = append(items, CompletionItem{Label: b.Name, Kind: functionCompletion, Detail: builtinSignature(b.Name)})
+	}
+
+	return items
+}
+
+func (doc *document) symbols(functions []*function) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+
+	for _, fn := range functions {
+		end := fn.node.Body.Rbrace
+		end.Column++
+		if fn.node.Body.Rbrace.Line == 0 {
+			end = fn.name.Pos()
+			end.Column += len([]rune(fn.name.Value))
+		}
+
+		symbols = append(symbols, DocumentSymbol{
+			Name:           fn.name.Value,
+			Detail:         doc.signature(fn.name.Value, fn.node),
+			Kind:           functionSymbol,
+			Range:          Range{Start: doc.toLSP(fn.start), End: doc.toLSP(end)},
+			SelectionRange: doc.identRange(fn.name),
+			Children:       doc.symbols(fn.children),
+		})
+	}
+
+	return symbols
+}
@@ -3,8 +3,13 @@ package object
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var Builtins = []struct {
@@ -23,7 +28,11 @@ var Builtins = []struct {
 			case *Array:
 				return &Integer{Value: int64(len(arg.Elements))}
 			case *String:
-				return &Integer{Value: int64(len(arg.Value))}
+				return &Integer{Value: arg.Len()}
+			case *Range:
+				return &Integer{Value: arg.Len()}
+			case *Set:
+				return &Integer{Value: int64(arg.Len())}
 			default:
 				return newError("argument to `len` not supported, got %s", args[0].Type())
 			}
@@ -126,22 +135,113 @@ var Builtins = []struct {
 	{
 		"read",
 		&Builtin{Fn: func(args ...Object) Object {
-			reader := bufio.NewReader(os.Stdin)
-			text, _ := reader.ReadString('\n')
+			return readLine(bufio.NewReader(os.Stdin))
+		},
+		},
+	},
 
-			// convert CRLF to LF
-			text = strings.Replace(text, "\n", "", -1)
+	{
+		"print",
+		&Builtin{Fn: func(args ...Object) Object {
+			return writeArgs(os.Stdout, args, false)
+		},
+		},
+	},
 
-			return &String{Value: text}
+	{
+		"println",
+		&Builtin{Fn: func(args ...Object) Object {
+			return writeArgs(os.Stdout, args, true)
 		},
 		},
 	},
 
 	{
-		"print",
+		"env",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			if len(args) == 1 && args[0].Type() == STRING_OBJ {
+				stringArg, _ := args[0].(*String)
+				env := os.Getenv(stringArg.Value)
+				return &String{Value: env}
+			}
+
+			hash := NewHash()
+
+			for _, e := range os.Environ() {
+				pair := strings.SplitN(e, "=", 2)
+				hash.Set(&String{Value: pair[0]}, &String{Value: pair[1]})
+			}
+
+			return hash
+		},
+		},
+	},
+
+	{
+		"next",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			if args[0].Type() != GENERATOR_OBJ {
+				return newError("argument to `next` must be GENERATOR, got %s", args[0].Type())
+			}
+
+			value, ok, err := args[0].(*Generator).Next()
+			if err != nil {
+				return newError("%s", err.Error())
+			}
+			if !ok {
+				return nil
+			}
+
+			return value
+		},
+		},
+	},
+
+	{
+		"channel",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0-1", len(args))
+			}
+
+			size := int64(0)
+			if len(args) == 1 {
+				if args[0].Type() != INTEGER_OBJ {
+					return newError("argument to `channel` must be INTEGER, got %s", args[0].Type())
+				}
+
+				size = args[0].(*Integer).Value
+				if size < 0 {
+					return newError("channel size must not be negative, got %d", size)
+				}
+			}
+
+			return NewChannel(int(size))
+		},
+		},
+	},
+
+	{
+		"send",
 		&Builtin{Fn: func(args ...Object) Object {
-			for _, arg := range args {
-				fmt.Print(arg.Inspect())
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+
+			if args[0].Type() != CHANNEL_OBJ {
+				return newError("argument to `send` must be CHANNEL, got %s", args[0].Type())
+			}
+
+			if err := args[0].(*Channel).Send(args[1]); err != nil {
+				return newError("%s", err.Error())
 			}
 
 			return nil
@@ -150,15 +250,39 @@ var Builtins = []struct {
 	},
 
 	{
-		"println",
+		"recv",
 		&Builtin{Fn: func(args ...Object) Object {
-			if len(args) == 0 {
-				fmt.Println("")
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			if args[0].Type() != CHANNEL_OBJ {
+				return newError("argument to `recv` must be CHANNEL, got %s", args[0].Type())
+			}
+
+			value, ok := args[0].(*Channel).Recv()
+			if !ok {
 				return nil
 			}
 
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+			return value
+		},
+		},
+	},
+
+	{
+		"close",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			if args[0].Type() != CHANNEL_OBJ {
+				return newError("argument to `close` must be CHANNEL, got %s", args[0].Type())
+			}
+
+			if err := args[0].(*Channel).Close(); err != nil {
+				return newError("%s", err.Error())
 			}
 
 			return nil
@@ -167,37 +291,531 @@ var Builtins = []struct {
 	},
 
 	{
-		"env",
+		"select",
 		&Builtin{Fn: func(args ...Object) Object {
-			if len(args) > 1 {
+			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
 
-			if len(args) == 1 && args[0].Type() == STRING_OBJ {
-				stringArg, _ := args[0].(*String)
-				env := os.Getenv(stringArg.Value)
-				return &String{Value: env}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `select` must be ARRAY, got %s", args[0].Type())
 			}
 
-			hash := &Hash{}
-			hash.Pairs = make(map[HashKey]HashPair)
+			elements := args[0].(*Array).Elements
+			if len(elements) == 0 {
+				return newError("`select` needs at least one channel")
+			}
 
-			for _, e := range os.Environ() {
-				pair := strings.SplitN(e, "=", 2)
+			cases := make([]reflect.SelectCase, len(elements))
+			for i, element := range elements {
+				channel, ok := element.(*Channel)
+				if !ok {
+					return newError("argument to `select` must only contain CHANNEL, got %s", element.Type())
+				}
+
+				cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(channel.ch)}
+			}
+
+			// The received value is null if the selected channel was closed
+			chosen, value, ok := reflect.Select(cases)
+			var received Object = NULL
+			if ok {
+				received = value.Interface().(Object)
+			}
+
+			return &Array{Elements: []Object{&Integer{Value: int64(chosen)}, received}}
+		},
+		},
+	},
+
+	{
+		"wait",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			if args[0].Type() != TASK_OBJ {
+				return newError("argument to `wait` must be TASK, got %s", args[0].Type())
+			}
+
+			result, err := args[0].(*Task).Wait()
+			if err != nil {
+				return newError("%s", err.Error())
+			}
+
+			return result
+		},
+		},
+	},
+
+	// The timer builtins need an event loop. The engines replace them with the
+	// functions returned by EventLoopBuiltin.
+	{
+		"setTimeout",
+		&Builtin{Fn: func(args ...Object) Object {
+			return newError("`setTimeout` requires an event loop")
+		},
+		},
+	},
 
-				key := String{Value: pair[0]}
-				value := HashPair{
-					Key:   &key,
-					Value: &String{Value: pair[1]},
+	{
+		"setInterval",
+		&Builtin{Fn: func(args ...Object) Object {
+			return newError("`setInterval` requires an event loop")
+		},
+		},
+	},
+
+	{
+		"clearTimeout",
+		&Builtin{Fn: clearTimer("clearTimeout")},
+	},
+
+	{
+		"clearInterval",
+		&Builtin{Fn: clearTimer("clearInterval")},
+	},
+
+	{
+		"sleep",
+		&Builtin{Fn: func(args ...Object) Object {
+			delay, err := timerDelay("sleep", args, 0)
+			if err != nil {
+				return err
+			}
+
+			time.Sleep(delay)
+			return nil
+		},
+		},
+	},
+
+	{
+		"ord",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Char:
+				return &Integer{Value: int64(arg.Value)}
+			case *String:
+				if arg.Len() != 1 {
+					return newError("argument to `ord` must be a single character, got %d characters", arg.Len())
 				}
+				ch, _ := arg.At(0)
+				return &Integer{Value: int64(ch)}
+			default:
+				return newError("argument to `ord` must be CHAR or STRING, got %s", args[0].Type())
+			}
+		},
+		},
+	},
 
-				hash.Pairs[key.HashKey()] = value
+	{
+		"chr",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
 
-			return hash
+			code, ok := args[0].(*Integer)
+			if !ok {
+				return newError("argument to `chr` must be INTEGER, got %s", args[0].Type())
+			}
+
+			if code.Value < 0 || code.Value > unicode.MaxRune || !utf8.ValidRune(rune(code.Value)) {
+				return newError("argument to `chr` is not a valid code point, got %d", code.Value)
+			}
+
+			return &Char{Value: rune(code.Value)}
+		},
+		},
+	},
+
+	{
+		"keys",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError("argument to `keys` must be HASH, got %s", args[0].Type())
+			}
+
+			keys := make([]Object, 0, hash.Len())
+			for _, pair := range hash.Pairs() {
+				keys = append(keys, pair.Key)
+			}
+
+			return &Array{Elements: keys}
 		},
 		},
 	},
+
+	{
+		"add",
+		&Builtin{Fn: func(args ...Object) Object {
+			set, err := setArgument("add", args, 2)
+			if err != nil {
+				return err
+			}
+
+			result, _ := NewSet(set.Elements()...)
+			if err := result.Add(args[1]); err != nil {
+				return newError(err.Error())
+			}
+
+			return result
+		},
+		},
+	},
+
+	{
+		"remove",
+		&Builtin{Fn: func(args ...Object) Object {
+			set, err := setArgument("remove", args, 2)
+			if err != nil {
+				return err
+			}
+
+			result, _ := NewSet(set.Elements()...)
+			if _, err := result.Remove(args[1]); err != nil {
+				return newError(err.Error())
+			}
+
+			return result
+		},
+		},
+	},
+
+	{
+		"has",
+		&Builtin{Fn: func(args ...Object) Object {
+			set, err := setArgument("has", args, 2)
+			if err != nil {
+				return err
+			}
+
+			ok, hasErr := set.Has(args[1])
+			if hasErr != nil {
+				return newError(hasErr.Error())
+			}
+
+			if ok {
+				return TRUE
+			}
+			return FALSE
+		},
+		},
+	},
+
+	{
+		"union",
+		&Builtin{Fn: setOperation("union", func(inOther bool) bool { return true })},
+	},
+
+	{
+		"intersection",
+		&Builtin{Fn: setOperation("intersection", func(inOther bool) bool { return inOther })},
+	},
+
+	{
+		"difference",
+		&Builtin{Fn: setOperation("difference", func(inOther bool) bool { return !inOther })},
+	},
+
+	{
+		"freeze",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			return Freeze(args[0])
+		},
+		},
+	},
+
+	{
+		"frozen",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			if IsFrozen(args[0]) {
+				return TRUE
+			}
+			return FALSE
+		},
+		},
+	},
+
+	{
+		"type",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			return &String{Value: TypeName(args[0])}
+		},
+		},
+	},
+
+	{
+		"arity",
+		&Builtin{Fn: func(args ...Object) Object {
+			_, params, err := functionArgument("arity", args)
+			if err != nil {
+				return err
+			}
+
+			return &Integer{Value: int64(len(params))}
+		},
+		},
+	},
+
+	{
+		"name",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) == 1 {
+				if builtin, ok := args[0].(*Builtin); ok {
+					return &String{Value: builtin.Name}
+				}
+			}
+
+			name, _, err := functionArgument("name", args)
+			if err != nil {
+				return err
+			}
+
+			if name == "" {
+				return nil
+			}
+			return &String{Value: name}
+		},
+		},
+	},
+
+	{
+		"params",
+		&Builtin{Fn: func(args ...Object) Object {
+			_, params, err := functionArgument("params", args)
+			if err != nil {
+				return err
+			}
+
+			elements := make([]Object, len(params))
+			for i, param := range params {
+				elements[i] = &String{Value: param}
+			}
+
+			return &Array{Elements: elements}
+		},
+		},
+	},
+
+	{
+		"error",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+
+			message, ok := args[0].(*String)
+			if !ok {
+				return newError("first argument to `error` must be STRING, got %s", args[0].Type())
+			}
+
+			err := &Error{Message: message.Value}
+			if len(args) == 2 {
+				err.Data = args[1]
+			}
+
+			return err
+		},
+		},
+	},
+
+	{
+		"isError",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			if args[0].Type() == ERROR_OBJ {
+				return TRUE
+			}
+			return FALSE
+		},
+		},
+	},
+
+	{
+		"errorMessage",
+		&Builtin{Fn: func(args ...Object) Object {
+			err, errArg := errorArgument("errorMessage", args, 1)
+			if errArg != nil {
+				return errArg
+			}
+
+			return &String{Value: err.FullMessage()}
+		},
+		},
+	},
+
+	{
+		"errorData",
+		&Builtin{Fn: func(args ...Object) Object {
+			err, errArg := errorArgument("errorData", args, 1)
+			if errArg != nil {
+				return errArg
+			}
+
+			return err.Data
+		},
+		},
+	},
+
+	{
+		"wrap",
+		&Builtin{Fn: func(args ...Object) Object {
+			err, errArg := errorArgument("wrap", args, 2)
+			if errArg != nil {
+				return errArg
+			}
+
+			message, ok := args[1].(*String)
+			if !ok {
+				return newError("second argument to `wrap` must be STRING, got %s", args[1].Type())
+			}
+
+			return &Error{Message: message.Value, Cause: err}
+		},
+		},
+	},
+
+	{
+		"unwrap",
+		&Builtin{Fn: func(args ...Object) Object {
+			err, errArg := errorArgument("unwrap", args, 1)
+			if errArg != nil {
+				return errArg
+			}
+
+			if err.Cause == nil {
+				return nil
+			}
+			return err.Cause
+		},
+		},
+	},
+}
+
+func init() {
+	for _, def := range Builtins {
+		def.Builtin.Name = def.Name
+	}
+}
+
+// IOBuiltin returns the builtin with the given name reading from in and
+// writing to out instead of stdin and stdout, or nil if the builtin does no
+// input or output.
+func IOBuiltin(name string, in io.Reader, out io.Writer) *Builtin {
+	switch name {
+	case "read":
+		reader := bufio.NewReader(in)
+
+		return &Builtin{Fn: func(args ...Object) Object {
+			return readLine(reader)
+		}, Name: name}
+
+	case "print", "println":
+		newline := name == "println"
+
+		return &Builtin{Fn: func(args ...Object) Object {
+			return writeArgs(out, args, newline)
+		}, Name: name}
+	}
+
+	return nil
+}
+
+// readLine reads the next line for the read builtin.
+func readLine(reader *bufio.Reader) Object {
+	text, _ := reader.ReadString('\n')
+
+	// convert CRLF to LF
+	text = strings.Replace(text, "\n", "", -1)
+
+	return &String{Value: text}
+}
+
+// writeArgs writes the arguments of print and println. With newline, every
+// argument is written on its own line.
+func writeArgs(w io.Writer, args []Object, newline bool) Object {
+	if newline && len(args) == 0 {
+		fmt.Fprintln(w)
+		return nil
+	}
+
+	for _, arg := range args {
+		if newline {
+			fmt.Fprintln(w, arg.Inspect())
+		} else {
+			fmt.Fprint(w, arg.Inspect())
+		}
+	}
+
+	return nil
+}
+
+// EventLoopBuiltin returns the builtin with the given name bound to the event
+// loop, or nil if the builtin does not use the event loop.
+func EventLoopBuiltin(name string, loop EventLoop) *Builtin {
+	switch name {
+	case "setTimeout", "setInterval":
+		repeat := name == "setInterval"
+
+		return &Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+
+			switch args[0].Type() {
+			case FUNCTION_OBJ, CLOSURE_OBJ, BUILTIN_OBJ:
+			default:
+				return newError("first argument to `%s` must be FUNCTION, got %s", name, args[0].Type())
+			}
+
+			delay, err := timerDelay(name, args, 1)
+			if err != nil {
+				return err
+			}
+
+			return loop.SetTimer(args[0], delay, repeat)
+		}, Name: name}
+
+	case "sleep":
+		return &Builtin{Fn: func(args ...Object) Object {
+			delay, err := timerDelay("sleep", args, 0)
+			if err != nil {
+				return err
+			}
+
+			loop.Sleep(delay)
+			return nil
+		}, Name: name}
+	}
+
+	return nil
 }
 
 func GetBuiltinByName(name string) *Builtin {
@@ -213,6 +831,125 @@ func GetBuiltinByName(name string) *Builtin {
 /*
 ** Helper functions
  */
+func clearTimer(name string) BuiltinFunction {
+	return func(args ...Object) Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+
+		if args[0].Type() != TIMER_OBJ {
+			return newError("argument to `%s` must be TIMER, got %s", name, args[0].Type())
+		}
+
+		args[0].(*Timer).Stop()
+		return nil
+	}
+}
+
+// timerDelay reads the delay in milliseconds from the argument at index.
+func timerDelay(name string, args []Object, index int) (time.Duration, *Error) {
+	if len(args) != index+1 {
+		return 0, newError("wrong number of arguments. got=%d, want=%d", len(args), index+1)
+	}
+
+	ms, ok := args[index].(*Integer)
+	if !ok {
+		return 0, newError("delay of `%s` must be INTEGER, got %s", name, args[index].Type())
+	}
+
+	if ms.Value < 0 {
+		return 0, newError("delay of `%s` must not be negative, got %d", name, ms.Value)
+	}
+
+	return time.Duration(ms.Value) * time.Millisecond, nil
+}
+
+// functionArgument returns the name and the parameter names of the function
+// passed as the only argument.
+func functionArgument(name string, args []Object) (string, []string, *Error) {
+	if len(args) != 1 {
+		return "", nil, newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	switch fn := args[0].(type) {
+	case *Function:
+		params := make([]string, len(fn.Parameters))
+		for i, param := range fn.Parameters {
+			params[i] = param.Value
+		}
+		return fn.Name, params, nil
+	case *CompiledFunction:
+		return fn.Name, fn.Parameters, nil
+	case *Closure:
+		return fn.Fn.Name, fn.Fn.Parameters, nil
+	}
+
+	return "", nil, newError("argument to `%s` must be FUNCTION, got %s", name, args[0].Type())
+}
+
+// errorArgument checks the number of arguments and returns the first one,
+// which must be an Error.
+func errorArgument(name string, args []Object, want int) (*Error, *Error) {
+	if len(args) != want {
+		return nil, newError("wrong number of arguments. got=%d, want=%d", len(args), want)
+	}
+
+	err, ok := args[0].(*Error)
+	if !ok {
+		return nil, newError("first argument to `%s` must be ERROR, got %s", name, args[0].Type())
+	}
+
+	return err, nil
+}
+
+// setArgument checks the number of arguments and returns the first one, which
+// must be a Set.
+func setArgument(name string, args []Object, want int) (*Set, *Error) {
+	if len(args) != want {
+		return nil, newError("wrong number of arguments. got=%d, want=%d", len(args), want)
+	}
+
+	set, ok := args[0].(*Set)
+	if !ok {
+		return nil, newError("first argument to `%s` must be SET, got %s", name, args[0].Type())
+	}
+
+	return set, nil
+}
+
+// setOperation returns a builtin that keeps the elements of the first set for
+// which keep returns true. For a union, the elements of the second set are
+// added as well.
+func setOperation(name string, keep func(inOther bool) bool) BuiltinFunction {
+	return func(args ...Object) Object {
+		left, err := setArgument(name, args, 2)
+		if err != nil {
+			return err
+		}
+
+		right, ok := args[1].(*Set)
+		if !ok {
+			return newError("second argument to `%s` must be SET, got %s", name, args[1].Type())
+		}
+
+		result, _ := NewSet()
+		for _, element := range left.Elements() {
+			inOther, _ := right.Has(element)
+			if keep(inOther) {
+				result.Add(element)
+			}
+		}
+
+		if name == "union" {
+			for _, element := range right.Elements() {
+				result.Add(element)
+			}
+		}
+
+		return result
+	}
+}
+
 func newError(format string, a ...interface{}) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
@@ -1,11 +1,24 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
+// Environment is safe for concurrent use, spawned tasks share the environment
+// their function was defined in.
 type Environment struct {
+	mu sync.RWMutex
+
 	variables map[string]Object
 	constants map[string]Object
 	outer     *Environment
+
+	// yield is set on the environment of a running generator function.
+	yield func(Object)
+
+	// loop is set on the global environment if timers are supported.
+	loop EventLoop
 }
 
 func NewEnvironment() *Environment {
@@ -24,14 +37,17 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
+	e.mu.RLock()
 	obj, ok := e.constants[name]
 
 	//constant was found
 	if ok {
+		e.mu.RUnlock()
 		return obj, ok
 	}
 
 	obj, ok = e.variables[name]
+	e.mu.RUnlock()
 	// variable was found
 	if ok {
 		return obj, ok
@@ -45,6 +61,41 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return e.outer.Get(name)
 }
 
+// SetYield marks the environment as the one of a generator. The given
+// function is called for every value the generator yields.
+func (e *Environment) SetYield(fn func(Object)) {
+	e.yield = fn
+}
+
+// Yield passes the value to the closest enclosing generator and returns once
+// the generator is resumed. It returns false outside of generators.
+func (e *Environment) Yield(val Object) bool {
+	if e.yield != nil {
+		e.yield(val)
+		return true
+	}
+
+	if e.outer == nil {
+		return false
+	}
+
+	return e.outer.Yield(val)
+}
+
+func (e *Environment) SetEventLoop(loop EventLoop) {
+	e.loop = loop
+}
+
+// EventLoop returns the event loop of the global environment, or nil if there
+// is none.
+func (e *Environment) EventLoop() EventLoop {
+	if e.loop != nil || e.outer == nil {
+		return e.loop
+	}
+
+	return e.outer.EventLoop()
+}
+
 func (e *Environment) Exists(name string, inherit bool) bool {
 	return e.constantExists(name, inherit) || e.variableExists(name, inherit)
 }
@@ -58,33 +109,47 @@ func (e *Environment) Set(name string, val Object) (Object, error) {
 		return val, fmt.Errorf("assignment to undeclared variable '%s'", name)
 	}
 
+	e.mu.Lock()
 	_, ok := e.variables[name]
 	// variable was found
 	if ok {
 		e.variables[name] = val
+		e.mu.Unlock()
 		return val, nil
 	}
+	e.mu.Unlock()
 
 	return e.outer.Set(name, val)
 }
 
 func (e *Environment) DefineConstant(name string, val Object) Object {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	e.constants[name] = val
 	return val
 }
 
 func (e *Environment) DefineVariable(name string, val Object) Object {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	e.variables[name] = val
 	return val
 }
 
 func (e *Environment) constantExists(name string, inherit bool) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	_, ok := e.constants[name]
 	return ok
 }
 
 func (e *Environment) variableExists(name string, inherit bool) bool {
+	e.mu.RLock()
 	_, ok := e.variables[name]
+	e.mu.RUnlock()
 
 	if ok {
 		return ok
@@ -0,0 +1,179 @@
+package object
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+)
+
+// Equals reports whether both objects are equal. Arrays and hashes are equal if
+// their contents are equal. Other objects without a value, like functions, are
+// only equal to themselves.
+func Equals(left, right Object) bool {
+	return equals(left, right, map[[2]Object]bool{})
+}
+
+func equals(left, right Object, visited map[[2]Object]bool) bool {
+	if left == right {
+		return true
+	}
+
+	if IsInteger(left) && IsInteger(right) {
+		return CompareIntegers(left, right) == 0
+	}
+
+	if left == nil || right == nil || left.Type() != right.Type() {
+		return false
+	}
+
+	switch left := left.(type) {
+	case *Boolean:
+		return left.Value == right.(*Boolean).Value
+	case *String:
+		return left.Value == right.(*String).Value
+	case *Char:
+		return left.Value == right.(*Char).Value
+	case *Range:
+		right := right.(*Range)
+		return left.Len() == right.Len() && (left.Len() == 0 || left.Start == right.Start)
+
+	case *Array:
+		right := right.(*Array)
+		if len(left.Elements) != len(right.Elements) {
+			return false
+		}
+
+		// A pair that is already being compared further up is assumed to be
+		// equal, so cyclic structures terminate.
+		pair := [2]Object{left, right}
+		if visited[pair] {
+			return true
+		}
+		visited[pair] = true
+
+		for i := range left.Elements {
+			if !equals(left.Elements[i], right.Elements[i], visited) {
+				return false
+			}
+		}
+		return true
+
+	case *Hash:
+		right := right.(*Hash)
+		if left.Len() != right.Len() {
+			return false
+		}
+
+		pair := [2]Object{left, right}
+		if visited[pair] {
+			return true
+		}
+		visited[pair] = true
+
+		for _, leftPair := range left.Pairs() {
+			rightValue, ok, _ := right.Get(leftPair.Key)
+			if !ok || !equals(leftPair.Value, rightValue, visited) {
+				return false
+			}
+		}
+		return true
+
+	case *Set:
+		right := right.(*Set)
+		if left.Len() != right.Len() {
+			return false
+		}
+
+		for _, element := range left.Elements() {
+			if ok, _ := right.Has(element); !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// HashKeyOf returns the hash key of obj. Arrays and hashes are hashed by their
+// contents, so equal arrays can be used as the same key. It returns false if obj
+// or any object it contains can not be hashed, or if it contains itself.
+func HashKeyOf(obj Object) (HashKey, bool) {
+	return hashKeyOf(obj, map[Object]bool{})
+}
+
+func hashKeyOf(obj Object, visiting map[Object]bool) (HashKey, bool) {
+	switch obj := obj.(type) {
+	case Hashable:
+		return obj.HashKey(), true
+
+	case *Array:
+		if visiting[obj] {
+			return HashKey{}, false
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+
+		h := fnv.New64a()
+		for _, element := range obj.Elements {
+			key, ok := hashKeyOf(element, visiting)
+			if !ok {
+				return HashKey{}, false
+			}
+			writeHashKey(h, key)
+		}
+
+		return HashKey{Type: obj.Type(), Value: h.Sum64()}, true
+
+	case *Hash:
+		if visiting[obj] {
+			return HashKey{}, false
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+
+		// Pairs are unordered, so their hashes are combined by addition.
+		var sum uint64
+		for _, pair := range obj.Pairs() {
+			key, ok := hashKeyOf(pair.Key, visiting)
+			if !ok {
+				return HashKey{}, false
+			}
+
+			value, ok := hashKeyOf(pair.Value, visiting)
+			if !ok {
+				return HashKey{}, false
+			}
+
+			h := fnv.New64a()
+			writeHashKey(h, key)
+			writeHashKey(h, value)
+			sum += h.Sum64()
+		}
+
+		return HashKey{Type: obj.Type(), Value: sum}, true
+
+	case *Set:
+		// Elements are unordered, so their hashes are combined by addition.
+		var sum uint64
+		for _, element := range obj.Elements() {
+			key, _ := hashKeyOf(element, visiting)
+
+			h := fnv.New64a()
+			writeHashKey(h, key)
+			sum += h.Sum64()
+		}
+
+		return HashKey{Type: obj.Type(), Value: sum}, true
+	}
+
+	return HashKey{}, false
+}
+
+func writeHashKey(h hash.Hash64, key HashKey) {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, key.Value)
+
+	h.Write([]byte(key.Type))
+	h.Write(value)
+}
@@ -0,0 +1,60 @@
+package object
+
+import "fmt"
+
+// Freeze marks obj and every array, hash and set it contains as frozen. Frozen
+// values can not be modified. Freeze returns obj.
+func Freeze(obj Object) Object {
+	switch obj := obj.(type) {
+	case *Array:
+		if obj.Frozen {
+			break
+		}
+		obj.Frozen = true
+
+		for _, element := range obj.Elements {
+			Freeze(element)
+		}
+
+	case *Hash:
+		if obj.Frozen {
+			break
+		}
+		obj.Frozen = true
+
+		for _, pair := range obj.pairs {
+			Freeze(pair.Key)
+			Freeze(pair.Value)
+		}
+
+	case *Set:
+		if obj.Frozen {
+			break
+		}
+		obj.Frozen = true
+
+		for _, element := range obj.Elements() {
+			Freeze(element)
+		}
+	}
+
+	return obj
+}
+
+// IsFrozen reports whether obj is a frozen array, hash or set.
+func IsFrozen(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.Frozen
+	case *Hash:
+		return obj.Frozen
+	case *Set:
+		return obj.Frozen
+	}
+
+	return false
+}
+
+func errFrozen(obj Object) error {
+	return fmt.Errorf("cannot modify frozen %s", obj.Type())
+}
@@ -0,0 +1,136 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// IsInteger reports whether obj is an Integer or a BigInteger.
+func IsInteger(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInteger:
+		return true
+	}
+	return false
+}
+
+// NewBigInteger returns an Integer if the value fits into an int64 and a
+// BigInteger otherwise.
+func NewBigInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &BigInteger{Value: value}
+}
+
+func toBigInt(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInteger:
+		return obj.Value
+	}
+	return nil
+}
+
+// IntegerOperation applies one of the operators +, -, * and / to two integers.
+// Results that overflow an int64 are promoted to a BigInteger and results that
+// fit into an int64 are demoted to an Integer.
+func IntegerOperation(operator string, left, right Object) (Object, error) {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		if result, ok := int64Operation(operator, l.Value, r.Value); ok {
+			return &Integer{Value: result}, nil
+		}
+	}
+
+	a, b := toBigInt(left), toBigInt(right)
+	if a == nil || b == nil {
+		return nil, fmt.Errorf("unsupported types for integer operation: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+
+	result := new(big.Int)
+	switch operator {
+	case "+":
+		result.Add(a, b)
+	case "-":
+		result.Sub(a, b)
+	case "*":
+		result.Mul(a, b)
+	case "/":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result.Quo(a, b)
+	default:
+		return nil, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+
+	return NewBigInteger(result), nil
+}
+
+// int64Operation returns false if the operation overflows or is not an int64
+// operation.
+func int64Operation(operator string, a, b int64) (int64, bool) {
+	switch operator {
+	case "+":
+		result := a + b
+		if (a > 0 && b > 0 && result < 0) || (a < 0 && b < 0 && result >= 0) {
+			return 0, false
+		}
+		return result, true
+	case "-":
+		result := a - b
+		if (a >= 0 && b < 0 && result < 0) || (a < 0 && b > 0 && result >= 0) {
+			return 0, false
+		}
+		return result, true
+	case "*":
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return 0, false
+		}
+		result := a * b
+		if result/b != a {
+			return 0, false
+		}
+		return result, true
+	case "/":
+		if b == 0 || (a == math.MinInt64 && b == -1) {
+			return 0, false
+		}
+		return a / b, true
+	}
+	return 0, false
+}
+
+// CompareIntegers returns -1, 0 or +1 depending on whether left is less than,
+// equal to or greater than right.
+func CompareIntegers(left, right Object) int {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		switch {
+		case l.Value < r.Value:
+			return -1
+		case l.Value > r.Value:
+			return 1
+		}
+		return 0
+	}
+
+	return toBigInt(left).Cmp(toBigInt(right))
+}
+
+// NegateInteger returns the negated integer.
+func NegateInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+	return NewBigInteger(new(big.Int).Neg(toBigInt(obj)))
+}
@@ -5,8 +5,14 @@ import (
 	"fmt"
 	"github.com/rhwilr/lemur/ast"
 	"github.com/rhwilr/lemur/code"
+	"github.com/rhwilr/lemur/token"
 	"hash/fnv"
+	"math/big"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 type ObjectType string
@@ -29,8 +35,34 @@ const (
 	BUILTIN_OBJ           = "BUILTIN"
 	CLOSURE_OBJ           = "CLOSURE"
 	ERROR_OBJ             = "ERROR"
+	RANGE_OBJ             = "RANGE"
+	ITERATOR_OBJ          = "ITERATOR"
+	GENERATOR_OBJ         = "GENERATOR"
+	TASK_OBJ              = "TASK"
+	CHANNEL_OBJ           = "CHANNEL"
+	TIMER_OBJ             = "TIMER"
+	QUOTE_OBJ             = "QUOTE"
+	MACRO_OBJ             = "MACRO"
+	BIG_INTEGER_OBJ       = "BIG_INTEGER"
+	CHAR_OBJ              = "CHAR"
+	SET_OBJ               = "SET"
 )
 
+// TypeName returns the type of obj as seen by a program. Unlike Type, it does
+// not tell apart the different kinds of integers and of functions defined in
+// the program. Builtins are reported as BUILTIN, they have no parameters that
+// arity and params could return.
+func TypeName(obj Object) string {
+	switch obj.Type() {
+	case BIG_INTEGER_OBJ:
+		return INTEGER_OBJ
+	case COMPILED_FUNCTION_OBJ, CLOSURE_OBJ:
+		return FUNCTION_OBJ
+	}
+
+	return string(obj.Type())
+}
+
 /*
 ** Integer
  */
@@ -41,6 +73,16 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+/*
+** BigInteger
+ */
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (i *BigInteger) Type() ObjectType { return BIG_INTEGER_OBJ }
+func (i *BigInteger) Inspect() string  { return i.Value.String() }
+
 /*
 ** Boolean
  */
@@ -48,6 +90,13 @@ type Boolean struct {
 	Value bool
 }
 
+// TRUE and FALSE are shared by the engines and builtins, so booleans can be
+// compared by pointer.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
 
@@ -56,16 +105,68 @@ func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
  */
 type String struct {
 	Value string
+
+	// The byte offset of each character is computed on first use. ASCII
+	// strings need no table as every character is a single byte.
+	once    sync.Once
+	length  int
+	offsets []int
 }
 
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
+func (s *String) index() {
+	s.once.Do(func() {
+		s.length = utf8.RuneCountInString(s.Value)
+		if s.length == len(s.Value) {
+			return
+		}
+
+		s.offsets = make([]int, 0, s.length)
+		for offset := range s.Value {
+			s.offsets = append(s.offsets, offset)
+		}
+	})
+}
+
+// Len returns the number of characters in the string.
+func (s *String) Len() int64 {
+	s.index()
+	return int64(s.length)
+}
+
+// At returns the character at the index.
+func (s *String) At(index int64) (rune, bool) {
+	s.index()
+	if index < 0 || index >= int64(s.length) {
+		return 0, false
+	}
+
+	if s.offsets == nil {
+		return rune(s.Value[index]), true
+	}
+
+	ch, _ := utf8.DecodeRuneInString(s.Value[s.offsets[index]:])
+	return ch, true
+}
+
+/*
+** Char
+ */
+type Char struct {
+	Value rune
+}
+
+func (c *Char) Type() ObjectType { return CHAR_OBJ }
+func (c *Char) Inspect() string  { return string(c.Value) }
+
 /*
 ** Array
  */
 type Array struct {
 	Elements []Object
+	Frozen   bool
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
@@ -84,6 +185,57 @@ func (ao *Array) Inspect() string {
 	return out.String()
 }
 
+// Set replaces the element at the index, which must be in range.
+func (ao *Array) Set(index int64, value Object) error {
+	if ao.Frozen {
+		return errFrozen(ao)
+	}
+
+	ao.Elements[index] = value
+	return nil
+}
+
+/*
+** Range
+ */
+type Range struct {
+	Start     int64
+	End       int64
+	Inclusive bool
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Inclusive {
+		return fmt.Sprintf("%d..%d", r.Start, r.End)
+	}
+
+	return fmt.Sprintf("%d..<%d", r.Start, r.End)
+}
+
+// Len returns the number of integers in the range without materializing them.
+func (r *Range) Len() int64 {
+	length := r.End - r.Start
+	if r.Inclusive {
+		length++
+	}
+
+	if length < 0 {
+		return 0
+	}
+
+	return length
+}
+
+// At returns the integer at the given offset of the range.
+func (r *Range) At(i int64) (*Integer, bool) {
+	if i < 0 || i >= r.Len() {
+		return nil, false
+	}
+
+	return &Integer{Value: r.Start + i}, true
+}
+
 /*
 ** HashKey
  */
@@ -106,6 +258,15 @@ func (b *Boolean) HashKey() HashKey {
 func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
+func (i *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(i.Value.String()))
+
+	return HashKey{Type: i.Type(), Value: h.Sum64()}
+}
+func (c *Char) HashKey() HashKey {
+	return HashKey{Type: c.Type(), Value: uint64(c.Value)}
+}
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -121,8 +282,18 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash keeps its pairs in insertion order. Keys with the same HashKey are told
+// apart by comparing the keys themselves, so collisions can not overwrite each
+// other.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs   []HashPair
+	buckets map[HashKey][]int // indexes into pairs
+	Frozen  bool
+}
+
+// NewHash returns an empty hash.
+func NewHash() *Hash {
+	return &Hash{buckets: map[HashKey][]int{}}
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
@@ -130,7 +301,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 	pairs := []string{}
 
-	for _, pair := range h.Pairs {
+	for _, pair := range h.pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
@@ -141,6 +312,191 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// Get returns the value stored for the key. It returns an error if the key can
+// not be used as a hash key.
+func (h *Hash) Get(key Object) (Object, bool, error) {
+	hashKey, ok := HashKeyOf(key)
+	if !ok {
+		return nil, false, fmt.Errorf("unusable as hash key: %s", key.Type())
+	}
+
+	index, ok := h.find(hashKey, key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	return h.pairs[index].Value, true, nil
+}
+
+// Set stores the value for the key. Updating an existing key keeps its
+// position.
+func (h *Hash) Set(key, value Object) error {
+	if h.Frozen {
+		return errFrozen(h)
+	}
+
+	hashKey, ok := HashKeyOf(key)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", key.Type())
+	}
+
+	if index, ok := h.find(hashKey, key); ok {
+		h.pairs[index].Value = value
+		return nil
+	}
+
+	if h.buckets == nil {
+		h.buckets = map[HashKey][]int{}
+	}
+
+	h.buckets[hashKey] = append(h.buckets[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+	return nil
+}
+
+// Delete removes the key and reports whether it was present.
+func (h *Hash) Delete(key Object) (bool, error) {
+	if h.Frozen {
+		return false, errFrozen(h)
+	}
+
+	hashKey, ok := HashKeyOf(key)
+	if !ok {
+		return false, fmt.Errorf("unusable as hash key: %s", key.Type())
+	}
+
+	index, ok := h.find(hashKey, key)
+	if !ok {
+		return false, nil
+	}
+
+	h.pairs = append(h.pairs[:index:index], h.pairs[index+1:]...)
+
+	// Every pair after the removed one moved down by one.
+	for k, bucket := range h.buckets {
+		kept := bucket[:0]
+		for _, i := range bucket {
+			switch {
+			case i > index:
+				kept = append(kept, i-1)
+			case i < index:
+				kept = append(kept, i)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(h.buckets, k)
+		} else {
+			h.buckets[k] = kept
+		}
+	}
+
+	return true, nil
+}
+
+// Pairs returns the pairs in insertion order. The slice must not be modified.
+func (h *Hash) Pairs() []HashPair {
+	return h.pairs
+}
+
+// Len returns the number of pairs.
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+func (h *Hash) find(hashKey HashKey, key Object) (int, bool) {
+	for _, index := range h.buckets[hashKey] {
+		if Equals(h.pairs[index].Key, key) {
+			return index, true
+		}
+	}
+
+	return 0, false
+}
+
+/*
+** Set
+ */
+// Set is an insertion-ordered collection of unique elements.
+type Set struct {
+	elements Hash
+	Frozen   bool
+}
+
+// NewSet returns a set with the given elements.
+func NewSet(elements ...Object) (*Set, error) {
+	set := &Set{}
+	for _, element := range elements {
+		if err := set.Add(element); err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+func (s *Set) Type() ObjectType { return SET_OBJ }
+func (s *Set) Inspect() string {
+	elements := []string{}
+	for _, element := range s.Elements() {
+		elements = append(elements, element.Inspect())
+	}
+
+	return "#{" + strings.Join(elements, ", ") + "}"
+}
+
+// Add inserts the element. Adding an element twice has no effect.
+func (s *Set) Add(element Object) error {
+	if s.Frozen {
+		return errFrozen(s)
+	}
+
+	if _, ok := HashKeyOf(element); !ok {
+		return fmt.Errorf("unusable as set element: %s", element.Type())
+	}
+
+	return s.elements.Set(element, element)
+}
+
+// Remove removes the element and reports whether it was present.
+func (s *Set) Remove(element Object) (bool, error) {
+	if s.Frozen {
+		return false, errFrozen(s)
+	}
+
+	ok, err := s.elements.Delete(element)
+	if err != nil {
+		return false, fmt.Errorf("unusable as set element: %s", element.Type())
+	}
+
+	return ok, nil
+}
+
+// Has reports whether the set contains the element.
+func (s *Set) Has(element Object) (bool, error) {
+	_, ok, err := s.elements.Get(element)
+	if err != nil {
+		return false, fmt.Errorf("unusable as set element: %s", element.Type())
+	}
+
+	return ok, nil
+}
+
+// Elements returns the elements in insertion order.
+func (s *Set) Elements() []Object {
+	elements := make([]Object, 0, s.Len())
+	for _, pair := range s.elements.Pairs() {
+		elements = append(elements, pair.Key)
+	}
+
+	return elements
+}
+
+// Len returns the number of elements.
+func (s *Set) Len() int {
+	return s.elements.Len()
+}
+
 /*
 ** ReturnValue
  */
@@ -159,30 +515,64 @@ type Null struct{}
 func (n *Null) Type() ObjectType { return NULL_OBJ }
 func (n *Null) Inspect() string  { return "null" }
 
+// NULL is shared by the engines and builtins, so null can be compared by
+// pointer.
+var NULL = &Null{}
+
 /*
 ** Function
  */
 type Function struct {
+	Name       string
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Defaults   map[string]ast.Expression
 	Env        *Environment
+	Generator  bool
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
+	params := []string{}
+	for _, p := range f.Parameters {
+		params = append(params, p.Value)
+	}
+
+	return inspectFunction(f.Name, params)
+}
+
+/*
+** Quote
+ */
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) Inspect() string  { return "QUOTE(" + q.Node.String() + ")" }
+
+/*
+** Macro
+ */
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range f.Parameters {
+	for _, p := range m.Parameters {
 		params = append(params, p.String())
 	}
 
-	out.WriteString("fn")
-	out.WriteString("(")
+	out.WriteString("macro(")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString("} {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(") {\n")
+	out.WriteString(m.Body.String())
 	out.WriteString("\n}")
 
 	return out.String()
@@ -196,11 +586,30 @@ type CompiledFunction struct {
 	NumLocals     int
 	NumParameters int
 	NumDefaults   int
+	Generator     bool
+	Name          string
+	Parameters    []string
+	Lines         code.LineTable // debug information, may be empty
+
+	// The names of the locals and free variables by index, only kept for
+	// debuggers and not written to compiled files
+	LocalNames []string
+	FreeNames  []string
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
 func (cf *CompiledFunction) Inspect() string {
-	return fmt.Sprintf("CompiledFunction[%p]", cf)
+	return inspectFunction(cf.Name, cf.Parameters)
+}
+
+// inspectFunction prints a function as `function name(a, b)`. Anonymous
+// functions are printed without a name.
+func inspectFunction(name string, params []string) string {
+	if name != "" {
+		name = " " + name
+	}
+
+	return fmt.Sprintf("function%s(%s)", name, strings.Join(params, ", "))
 }
 
 /*
@@ -209,7 +618,8 @@ func (cf *CompiledFunction) Inspect() string {
 type BuiltinFunction func(args ...Object) Object
 
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn   BuiltinFunction
+	Name string
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -224,17 +634,317 @@ type Closure struct {
 }
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
-func (c *Closure) Inspect() string  { return fmt.Sprintf("Closure[%p]", c) }
+func (c *Closure) Inspect() string  { return c.Fn.Inspect() }
+
+/*
+** Iterator
+ */
+type Iterator struct {
+	next func() (Object, Object, bool)
+	err  error
+
+	// yieldKeys is set when iterating with a single variable should produce
+	// the keys instead of the values, as it is the case for hashes.
+	yieldKeys bool
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "iterator" }
+
+// Next returns the next key and value of the iterated object. For arrays,
+// strings and ranges the key is the index, for hashes it is the hash key. The
+// last return value is false once the iterator is exhausted.
+func (it *Iterator) Next() (Object, Object, bool) {
+	return it.next()
+}
+
+// Err returns the error that stopped the iteration, if any. Only generators
+// can fail while being iterated.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// NextElement returns the next element when iterating with a single variable.
+// Hashes produce their keys, all other objects their values.
+func (it *Iterator) NextElement() (Object, bool) {
+	key, value, ok := it.next()
+	if it.yieldKeys {
+		return key, ok
+	}
+
+	return value, ok
+}
+
+// NewIterator returns an iterator over the given object, or false if the object
+// can not be iterated.
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		i := 0
+		return &Iterator{next: func() (Object, Object, bool) {
+			if i >= len(obj.Elements) {
+				return nil, nil, false
+			}
+
+			i++
+			return &Integer{Value: int64(i - 1)}, obj.Elements[i-1], true
+		}}, true
+
+	case *String:
+		var i int64
+		return &Iterator{next: func() (Object, Object, bool) {
+			ch, ok := obj.At(i)
+			if !ok {
+				return nil, nil, false
+			}
+
+			i++
+			return &Integer{Value: i - 1}, &String{Value: string(ch)}, true
+		}}, true
+
+	case *Range:
+		var i int64
+		return &Iterator{next: func() (Object, Object, bool) {
+			value, ok := obj.At(i)
+			if !ok {
+				return nil, nil, false
+			}
+
+			i++
+			return &Integer{Value: i - 1}, value, true
+		}}, true
+
+	case *Set:
+		elements := obj.Elements()
+		i := 0
+		return &Iterator{next: func() (Object, Object, bool) {
+			if i >= len(elements) {
+				return nil, nil, false
+			}
+
+			i++
+			return &Integer{Value: int64(i - 1)}, elements[i-1], true
+		}}, true
+
+	case *Hash:
+		pairs := obj.Pairs()
+		i := 0
+		return &Iterator{next: func() (Object, Object, bool) {
+			if i >= len(pairs) {
+				return nil, nil, false
+			}
+
+			i++
+			return pairs[i-1].Key, pairs[i-1].Value, true
+		}, yieldKeys: true}, true
+
+	case *Generator:
+		it := &Iterator{}
+		var i int64
+		it.next = func() (Object, Object, bool) {
+			value, ok, err := obj.Next()
+			if err != nil {
+				it.err = err
+			}
+			if !ok {
+				return nil, nil, false
+			}
+
+			i++
+			return &Integer{Value: i - 1}, value, true
+		}
+		return it, true
+
+	case *Channel:
+		var i int64
+		return &Iterator{next: func() (Object, Object, bool) {
+			value, ok := obj.Recv()
+			if !ok {
+				return nil, nil, false
+			}
+
+			i++
+			return &Integer{Value: i - 1}, value, true
+		}}, true
+
+	case *Iterator:
+		return obj, true
+	}
+
+	return nil, false
+}
+
+/*
+** Generator
+ */
+type Generator struct {
+	resume func() (Object, bool, error)
+	done   bool
+}
+
+// NewGenerator wraps a suspended generator function. Each call to resume runs
+// the function until it yields a value (true) or returns (false).
+func NewGenerator(resume func() (Object, bool, error)) *Generator {
+	return &Generator{resume: resume}
+}
+
+func (g *Generator) Type() ObjectType { return GENERATOR_OBJ }
+func (g *Generator) Inspect() string  { return fmt.Sprintf("Generator[%p]", g) }
+
+// Next resumes the generator and returns the next yielded value. The second
+// return value is false once the generator has finished.
+func (g *Generator) Next() (Object, bool, error) {
+	if g.done {
+		return nil, false, nil
+	}
+
+	value, ok, err := g.resume()
+	if !ok || err != nil {
+		g.done = true
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+/*
+** Task
+ */
+type Task struct {
+	done   chan struct{}
+	result Object
+	err    error
+}
+
+// NewTask runs the given function concurrently and returns a task to wait for
+// its result.
+func NewTask(run func() (Object, error)) *Task {
+	t := &Task{done: make(chan struct{})}
+
+	go func() {
+		t.result, t.err = run()
+		close(t.done)
+	}()
+
+	return t
+}
+
+func (t *Task) Type() ObjectType { return TASK_OBJ }
+func (t *Task) Inspect() string  { return fmt.Sprintf("Task[%p]", t) }
+
+// Wait blocks until the task has finished and returns its result.
+func (t *Task) Wait() (Object, error) {
+	<-t.done
+	return t.result, t.err
+}
+
+/*
+** Channel
+ */
+type Channel struct {
+	ch chan Object
+}
+
+// NewChannel creates a channel that can hold up to size values before a send
+// blocks. A size of 0 creates an unbuffered channel.
+func NewChannel(size int) *Channel {
+	return &Channel{ch: make(chan Object, size)}
+}
+
+func (c *Channel) Type() ObjectType { return CHANNEL_OBJ }
+func (c *Channel) Inspect() string  { return fmt.Sprintf("Channel[%p]", c) }
+
+// Send blocks until the value was handed over to a receiver or buffered.
+func (c *Channel) Send(value Object) (err error) {
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("send on closed channel")
+		}
+	}()
+
+	c.ch <- value
+	return nil
+}
+
+// Recv blocks until a value is available. The second return value is false
+// once the channel is closed and drained.
+func (c *Channel) Recv() (Object, bool) {
+	value, ok := <-c.ch
+	return value, ok
+}
+
+func (c *Channel) Close() (err error) {
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("close of closed channel")
+		}
+	}()
+
+	close(c.ch)
+	return nil
+}
+
+/*
+** Timer
+ */
+type Timer struct {
+	ID       int64
+	Callback Object
+	Delay    time.Duration
+	Repeat   bool
+
+	stopped int32
+}
+
+func (t *Timer) Type() ObjectType { return TIMER_OBJ }
+func (t *Timer) Inspect() string  { return fmt.Sprintf("Timer[%d]", t.ID) }
+
+// Stop prevents the timer from firing again.
+func (t *Timer) Stop() {
+	atomic.StoreInt32(&t.stopped, 1)
+}
+
+func (t *Timer) Stopped() bool {
+	return atomic.LoadInt32(&t.stopped) == 1
+}
+
+// EventLoop schedules the timers created by the timer builtins. It is
+// implemented by the eventloop package and driven by the engines.
+type EventLoop interface {
+	SetTimer(callback Object, delay time.Duration, repeat bool) *Timer
+	Sleep(delay time.Duration)
+
+	// Run calls the callbacks of all timers when they are due and returns once
+	// no timers are left.
+	Run(call func(callback Object) error) error
+}
 
 /*
 ** Error
  */
+// Errors returned by builtins and created with `error` are ordinary values.
+// Only raised errors, which the evaluator uses for runtime errors, stop the
+// program.
 type Error struct {
-	Message string
+	Message  string
+	Data     Object
+	Cause    *Error
+	Raised   bool
+	Position token.TokenPosition // where a raised error happened
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string  { return "ERROR: " + e.FullMessage() }
+
+// FullMessage returns the message followed by the messages of all wrapped
+// errors, e.g. `reading config: file not found`.
+func (e *Error) FullMessage() string {
+	if e.Cause == nil {
+		return e.Message
+	}
+
+	return e.Message + ": " + e.Cause.FullMessage()
+}
 
 /*
 ** Helpers
@@ -255,7 +965,11 @@ func ObjectToNativeBoolean(o Object) bool {
 	case *Array:
 		return len(obj.Elements) != 0
 	case *Hash:
-		return len(obj.Pairs) != 0
+		return obj.Len() != 0
+	case *Set:
+		return obj.Len() != 0
+	case *Range:
+		return obj.Len() != 0
 	default:
 		return true
 	}
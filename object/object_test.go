@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -58,3 +62,294 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestBigIntegerHashKey(t *testing.T) {
+	big1 := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 70)}
+	big2 := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 70)}
+	other := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 71)}
+
+	if big1.HashKey() != big2.HashKey() {
+		t.Errorf("big integers with same content have different hash keys")
+	}
+
+	if big1.HashKey() == other.HashKey() {
+		t.Errorf("big integers with different content have same hash keys")
+	}
+}
+
+func TestIntegerOperation(t *testing.T) {
+	tests := []struct {
+		operator string
+		left     Object
+		right    Object
+		expected string
+	}{
+		{"+", &Integer{Value: 1}, &Integer{Value: 2}, "3"},
+		{"+", &Integer{Value: math.MaxInt64}, &Integer{Value: 1}, "9223372036854775808"},
+		{"-", &Integer{Value: math.MinInt64}, &Integer{Value: 1}, "-9223372036854775809"},
+		{"*", &Integer{Value: math.MaxInt64}, &Integer{Value: 2}, "18446744073709551614"},
+		{"*", &Integer{Value: math.MinInt64}, &Integer{Value: -1}, "9223372036854775808"},
+		{"/", &Integer{Value: math.MinInt64}, &Integer{Value: -1}, "9223372036854775808"},
+		{"/", &Integer{Value: -7}, &Integer{Value: 2}, "-3"},
+	}
+
+	for _, tt := range tests {
+		result, err := IntegerOperation(tt.operator, tt.left, tt.right)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s %s %s. want=%s, got=%s",
+				tt.left.Inspect(), tt.operator, tt.right.Inspect(), tt.expected, result.Inspect())
+		}
+	}
+
+	_, err := IntegerOperation("/", &Integer{Value: 1}, &Integer{Value: 0})
+	if err == nil || err.Error() != "division by zero" {
+		t.Errorf("expected division by zero error, got=%v", err)
+	}
+}
+
+func TestIntegerDemotion(t *testing.T) {
+	value := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}
+	result, err := IntegerOperation("/", value, &Integer{Value: 1 << 32})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	integer, ok := result.(*Integer)
+	if !ok || integer.Value != 1<<32 {
+		t.Errorf("result was not demoted to Integer. got=%T (%+v)", result, result)
+	}
+}
+
+func TestStringAt(t *testing.T) {
+	tests := []struct {
+		value    string
+		length   int64
+		index    int64
+		expected rune
+		ok       bool
+	}{
+		{"hello", 5, 1, 'e', true},
+		{"hello", 5, 5, 0, false},
+		{"héllo", 5, 1, 'é', true},
+		{"héllo", 5, 2, 'l', true},
+		{"日本語", 3, 2, '語', true},
+		{"日本語", 3, -1, 0, false},
+	}
+
+	for _, tt := range tests {
+		str := &String{Value: tt.value}
+
+		if str.Len() != tt.length {
+			t.Errorf("wrong length of %q. want=%d, got=%d", tt.value, tt.length, str.Len())
+		}
+
+		ch, ok := str.At(tt.index)
+		if ch != tt.expected || ok != tt.ok {
+			t.Errorf("wrong character at %d of %q. want=%q (%t), got=%q (%t)",
+				tt.index, tt.value, tt.expected, tt.ok, ch, ok)
+		}
+	}
+}
+
+func TestEquals(t *testing.T) {
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+	hash := func(key, value Object) *Hash {
+		h := NewHash()
+		h.Set(key, value)
+		return h
+	}
+
+	tests := []struct {
+		left     Object
+		right    Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 1}, true},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&String{Value: "a"}, &Char{Value: 'a'}, false},
+		{array(&Integer{Value: 1}, &String{Value: "a"}), array(&Integer{Value: 1}, &String{Value: "a"}), true},
+		{array(&Integer{Value: 1}), array(&Integer{Value: 2}), false},
+		{array(&Integer{Value: 1}), array(&Integer{Value: 1}, &Integer{Value: 1}), false},
+		{array(array(&Integer{Value: 1})), array(array(&Integer{Value: 1})), true},
+		{hash(&String{Value: "a"}, array()), hash(&String{Value: "a"}, array()), true},
+		{hash(&String{Value: "a"}, &Integer{Value: 1}), hash(&String{Value: "a"}, &Integer{Value: 2}), false},
+		{&Range{Start: 1, End: 3}, &Range{Start: 1, End: 2, Inclusive: true}, true},
+	}
+
+	for i, tt := range tests {
+		if Equals(tt.left, tt.right) != tt.expected {
+			t.Errorf("tests[%d] - Equals(%s, %s) is not %t", i, tt.left.Inspect(), tt.right.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestEqualsWithCycles(t *testing.T) {
+	a := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	a.Elements[1] = a
+	b := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	b.Elements[1] = b
+
+	if !Equals(a, b) {
+		t.Errorf("cyclic arrays with same content are not equal")
+	}
+
+	if _, ok := HashKeyOf(a); ok {
+		t.Errorf("cyclic array is hashable")
+	}
+}
+
+func TestArrayHashKey(t *testing.T) {
+	one1 := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	one2 := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	two := &Array{Elements: []Object{&String{Value: "a"}, &Integer{Value: 1}}}
+
+	key1, ok1 := HashKeyOf(one1)
+	key2, ok2 := HashKeyOf(one2)
+	other, _ := HashKeyOf(two)
+
+	if !ok1 || !ok2 || key1 != key2 {
+		t.Errorf("arrays with same content have different hash keys")
+	}
+
+	if key1 == other {
+		t.Errorf("arrays with different content have same hash keys")
+	}
+
+	if _, ok := HashKeyOf(&Array{Elements: []Object{&Builtin{}}}); ok {
+		t.Errorf("array with a builtin is hashable")
+	}
+}
+
+// collider is a key whose hash key collides with every other collider.
+type collider struct{ name string }
+
+func (c *collider) Type() ObjectType { return "COLLIDER" }
+func (c *collider) Inspect() string  { return c.name }
+func (c *collider) HashKey() HashKey { return HashKey{Type: c.Type(), Value: 1} }
+
+func TestHashCollisions(t *testing.T) {
+	a := &collider{name: "a"}
+	b := &collider{name: "b"}
+
+	hash := NewHash()
+	hash.Set(a, &Integer{Value: 1})
+	hash.Set(b, &Integer{Value: 2})
+
+	if hash.Len() != 2 {
+		t.Fatalf("colliding keys overwrote each other. got=%d pairs", hash.Len())
+	}
+
+	for key, expected := range map[Object]int64{a: 1, b: 2} {
+		value, ok, err := hash.Get(key)
+		if err != nil || !ok {
+			t.Fatalf("no value for key %s", key.Inspect())
+		}
+
+		if value.(*Integer).Value != expected {
+			t.Errorf("wrong value for key %s. want=%d, got=%s", key.Inspect(), expected, value.Inspect())
+		}
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	hash := NewHash()
+	hash.Set(&String{Value: "b"}, &Integer{Value: 1})
+	hash.Set(&String{Value: "a"}, &Integer{Value: 2})
+	hash.Set(&String{Value: "c"}, &Integer{Value: 3})
+	hash.Set(&String{Value: "b"}, &Integer{Value: 4})
+
+	expected := "{b: 4, a: 2, c: 3}"
+	if hash.Inspect() != expected {
+		t.Errorf("wrong order. want=%s, got=%s", expected, hash.Inspect())
+	}
+
+	if _, _, err := hash.Get(&Builtin{}); err == nil {
+		t.Errorf("expected error for unusable hash key")
+	}
+}
+
+func TestSet(t *testing.T) {
+	set, err := NewSet(&Integer{Value: 3}, &Integer{Value: 1}, &Integer{Value: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if set.Len() != 2 || set.Inspect() != "#{3, 1}" {
+		t.Fatalf("wrong set. got=%s", set.Inspect())
+	}
+
+	set.Add(&Integer{Value: 2})
+	removed, _ := set.Remove(&Integer{Value: 3})
+	if !removed || set.Inspect() != "#{1, 2}" {
+		t.Errorf("wrong set after add and remove. got=%s", set.Inspect())
+	}
+
+	if ok, _ := set.Has(&Integer{Value: 2}); !ok {
+		t.Errorf("set does not contain 2")
+	}
+
+	if ok, _ := set.Has(&Integer{Value: 3}); ok {
+		t.Errorf("set still contains 3")
+	}
+
+	if err := set.Add(&Builtin{}); err == nil || err.Error() != "unusable as set element: BUILTIN" {
+		t.Errorf("expected error for unusable set element, got=%v", err)
+	}
+
+	other, _ := NewSet(&Integer{Value: 2}, &Integer{Value: 1})
+	if !Equals(set, other) {
+		t.Errorf("sets with same elements are not equal")
+	}
+
+	key1, _ := HashKeyOf(set)
+	key2, _ := HashKeyOf(other)
+	if key1 != key2 {
+		t.Errorf("sets with same elements have different hash keys")
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	inner := NewHash()
+	inner.Set(&String{Value: "a"}, &Integer{Value: 1})
+	set, _ := NewSet(&Integer{Value: 1})
+	array := &Array{Elements: []Object{inner, set}}
+
+	Freeze(array)
+
+	for _, obj := range []Object{array, inner, set} {
+		if !IsFrozen(obj) {
+			t.Errorf("%s is not frozen", obj.Inspect())
+		}
+	}
+
+	if err := inner.Set(&String{Value: "b"}, &Integer{Value: 2}); err == nil || err.Error() != "cannot modify frozen HASH" {
+		t.Errorf("expected error for frozen hash, got=%v", err)
+	}
+
+	if _, err := inner.Delete(&String{Value: "a"}); err == nil {
+		t.Errorf("expected error when deleting from frozen hash")
+	}
+
+	if err := set.Add(&Integer{Value: 2}); err == nil || err.Error() != "cannot modify frozen SET" {
+		t.Errorf("expected error for frozen set, got=%v", err)
+	}
+
+	if err := array.Set(0, &Integer{Value: 3}); err == nil || err.Error() != "cannot modify frozen ARRAY" {
+		t.Errorf("expected error for frozen array, got=%v", err)
+	}
+
+	if array.Elements[0] != inner || inner.Len() != 1 || set.Len() != 1 {
+		t.Errorf("frozen values were modified")
+	}
+
+	cyclic := &Array{}
+	cyclic.Elements = []Object{cyclic}
+	Freeze(cyclic)
+	if !cyclic.Frozen {
+		t.Errorf("cyclic array is not frozen")
+	}
+}
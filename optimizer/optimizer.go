@@ -4,6 +4,7 @@ import (
 	"strconv"
 
 	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/object"
 	"github.com/rhwilr/lemur/token"
 )
 
@@ -69,17 +70,15 @@ func optimizeInfixExpression(node *ast.InfixExpression) ast.Expression {
 	}
 
 	// Integers
-	_, okL := left.(*ast.IntegerLiteral)
-	_, okR := right.(*ast.IntegerLiteral)
-	if okL && okR {
+	if isIntegerAst(left) && isIntegerAst(right) {
 		if opt := optimizeIntegerInfixExpression(node.Operator, left, right); opt != nil {
 			return opt
 		}
 	}
 
 	// Strings
-	_, okL = left.(*ast.StringLiteral)
-	_, okR = right.(*ast.StringLiteral)
+	_, okL := left.(*ast.StringLiteral)
+	_, okR := right.(*ast.StringLiteral)
 	if okL && okR {
 		if opt := optimizeStringInfixExpression(node.Operator, left, right); opt != nil {
 			return opt
@@ -100,34 +99,34 @@ func optimizeInfixExpression(node *ast.InfixExpression) ast.Expression {
 
 
 func optimizeIntegerInfixExpression(operator string, left, right ast.Expression) ast.Expression {
-	leftVal := left.(*ast.IntegerLiteral).Value
-	rightVal := right.(*ast.IntegerLiteral).Value
+	leftVal := integerAstToObject(left)
+	rightVal := integerAstToObject(right)
+	cmp := object.CompareIntegers(leftVal, rightVal)
 
 	switch operator {
 	case "==":
-		return nativeBoolToBooleanAst(leftVal == rightVal)
+		return nativeBoolToBooleanAst(cmp == 0)
 	case "!=":
-		return nativeBoolToBooleanAst(leftVal != rightVal)
+		return nativeBoolToBooleanAst(cmp != 0)
 	case "<":
-		return nativeBoolToBooleanAst(leftVal < rightVal)
+		return nativeBoolToBooleanAst(cmp < 0)
 	case ">":
-		return nativeBoolToBooleanAst(leftVal > rightVal)
+		return nativeBoolToBooleanAst(cmp > 0)
 	case "<=":
-		return nativeBoolToBooleanAst(leftVal <= rightVal)
+		return nativeBoolToBooleanAst(cmp <= 0)
 	case ">=":
-		return nativeBoolToBooleanAst(leftVal >= rightVal)
+		return nativeBoolToBooleanAst(cmp >= 0)
 	case "||":
-		return nativeBoolToBooleanAst(leftVal != 0 || rightVal != 0)
+		return nativeBoolToBooleanAst(object.ObjectToNativeBoolean(leftVal) || object.ObjectToNativeBoolean(rightVal))
 	case "&&":
-		return nativeBoolToBooleanAst(leftVal != 0 && rightVal != 0)
-	case "+", "+=":
-		return nativeIntegerToIntegerAst(leftVal + rightVal)
-	case "-", "-=":
-		return nativeIntegerToIntegerAst(leftVal - rightVal)
-	case "*", "*=":
-		return nativeIntegerToIntegerAst(leftVal * rightVal)
-	case "/", "/=":
-		return nativeIntegerToIntegerAst(leftVal / rightVal)
+		return nativeBoolToBooleanAst(object.ObjectToNativeBoolean(leftVal) && object.ObjectToNativeBoolean(rightVal))
+	case "+", "+=", "-", "-=", "*", "*=", "/", "/=":
+		// Errors like a division by zero are left for the runtime to report.
+		result, err := object.IntegerOperation(operator[:1], leftVal, rightVal)
+		if err != nil {
+			return nil
+		}
+		return integerObjectToAst(result)
 	default:
 		return nil
 	}
@@ -200,6 +199,8 @@ func evaluateExpression(node ast.Expression) (ast.Expression, bool) {
 	switch node := node.(type) {
 	case *ast.IntegerLiteral:
 		return node, true
+	case *ast.BigIntegerLiteral:
+		return node, true
 	case *ast.Boolean:
 		return node, true
 	case *ast.StringLiteral:
@@ -248,6 +249,34 @@ func nativeIntegerToIntegerAst(value int64) *ast.IntegerLiteral {
 	}
 }
 
+func isIntegerAst(node ast.Expression) bool {
+	switch node.(type) {
+	case *ast.IntegerLiteral, *ast.BigIntegerLiteral:
+		return true
+	}
+	return false
+}
+
+func integerAstToObject(node ast.Expression) object.Object {
+	if big, ok := node.(*ast.BigIntegerLiteral); ok {
+		return &object.BigInteger{Value: big.Value}
+	}
+	return &object.Integer{Value: node.(*ast.IntegerLiteral).Value}
+}
+
+func integerObjectToAst(obj object.Object) ast.Expression {
+	if big, ok := obj.(*object.BigInteger); ok {
+		return &ast.BigIntegerLiteral{
+			Token: token.Token{
+				Type:    token.INT,
+				Literal: big.Value.String(),
+			},
+			Value: big.Value,
+		}
+	}
+	return nativeIntegerToIntegerAst(obj.(*object.Integer).Value)
+}
+
 func nativeStringToStringAst(value string) *ast.StringLiteral {
 	return &ast.StringLiteral{
 		Token: token.Token{
@@ -76,6 +76,25 @@ func TestSimpleArithmeticCalculations(t *testing.T) {
 	runOptimizerTests(t, tests)
 }
 
+func TestBigIntegerCalculations(t *testing.T) {
+	tests := []optimizerTestCase{
+		{
+			input: `let input = 9223372036854775807 + 1;`,
+			expected: `let input = 9223372036854775808;`,
+		},
+		{
+			input: `let input = 9223372036854775808 - 1;`,
+			expected: `let input = 9223372036854775807;`,
+		},
+		{
+			input: `let input = 1 / 0;`,
+			expected: `let input = (1 / 0);`,
+		},
+	}
+
+	runOptimizerTests(t, tests)
+}
+
 func TestStringConcatinations(t *testing.T) {
 	tests := []optimizerTestCase{
 		{
@@ -1,11 +1,15 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/diagnostics"
 	"github.com/rhwilr/lemur/lexer"
 	"github.com/rhwilr/lemur/token"
+	"math/big"
 	"strconv"
+	"strings"
 )
 
 /*
@@ -18,6 +22,8 @@ const (
 	ASSIGN      // =
 	EQUALS      // ==
 	LESSGREATER // > or <
+	PIPE        // |>
+	RANGE       // .. or ..<
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
@@ -33,6 +39,9 @@ var precedences = map[token.TokenType]int{
 	token.GT:     LESSGREATER,
 	token.GT_EQ:  LESSGREATER,
 
+	token.RANGE:           RANGE,
+	token.RANGE_EXCLUSIVE: RANGE,
+
 	token.PLUS:            SUM,
 	token.PLUS_EQUALS:     SUM,
 	token.MINUS:           SUM,
@@ -46,8 +55,10 @@ var precedences = map[token.TokenType]int{
 	token.ASSIGN:          ASSIGN,
 	token.AND:             COND,
 	token.OR:              COND,
+	token.PIPE:            PIPE,
 }
 
+
 type (
 	prefixParseFn  func() ast.Expression
 	infixParseFn   func(ast.Expression) ast.Expression
@@ -57,7 +68,17 @@ type (
 type Parser struct {
 	l *lexer.Lexer
 
-	errors []string
+	diagnostics []diagnostics.Diagnostic
+
+	// Set after a syntax error until the parser has synchronized at the next
+	// statement. Errors found in the meantime are follow-ups and are dropped.
+	panicking bool
+
+	// Number of braces opened up to and including curToken.
+	depth int
+
+	// Number of lexer diagnostics seen so far.
+	lexerErrors int
 
 	prevToken token.Token
 	curToken  token.Token
@@ -66,6 +87,10 @@ type Parser struct {
 	prefixParseFns  map[token.TokenType]prefixParseFn
 	infixParseFns   map[token.TokenType]infixParseFn
 	postfixParseFns map[token.TokenType]postfixParseFn
+
+	// One entry per function body that is currently parsed. The entry is set
+	// to true as soon as a yield is found, making the function a generator.
+	functionYields []bool
 }
 
 /*
@@ -73,8 +98,8 @@ type Parser struct {
  */
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []diagnostics.Diagnostic{},
 	}
 
 	// Read two tokens, so curToken and peekToken are both set
@@ -93,11 +118,16 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.WHILE, p.parseWhileLoopExpression)
 	p.registerPrefix(token.STRING, p.parseString)
+	p.registerPrefix(token.CHAR, p.parseCharLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.LSET, p.parseSetLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.YIELD, p.parseYieldExpression)
+	p.registerPrefix(token.SPAWN, p.parseSpawnExpression)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 
 	// Register parsing functions for infix Operators
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
@@ -113,6 +143,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.RANGE, p.parseInfixExpression)
+	p.registerInfix(token.RANGE_EXCLUSIVE, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
@@ -120,6 +152,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS_EQUALS, p.parseAssignExpression)
 	p.registerInfix(token.SLASH_EQUALS, p.parseAssignExpression)
 	p.registerInfix(token.ASTERISK_EQUALS, p.parseAssignExpression)
+	p.registerInfix(token.PIPE, p.parsePipelineExpression)
 
 	// Register postfix functions.
 	p.postfixParseFns = make(map[token.TokenType]postfixParseFn)
@@ -133,7 +166,74 @@ func New(l *lexer.Lexer) *Parser {
 ** Returns possible errors encountered during the parsing phase
  */
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.Diagnostics() {
+		if d.Severity == diagnostics.Error {
+			errors = append(errors, fmt.Sprintf("SyntaxError: [%s] %s", d.Pos(), d.Message))
+		}
+	}
+
+	return errors
+}
+
+/*
+** Returns the diagnostics of the lexer and the parser ordered by position
+ */
+func (p *Parser) Diagnostics() []diagnostics.Diagnostic {
+	all := append([]diagnostics.Diagnostic{}, p.l.Diagnostics()...)
+	all = append(all, p.diagnostics...)
+	diagnostics.Sort(all)
+
+	return all
+}
+
+func (p *Parser) addError(code diagnostics.Code, tok token.Token, format string, a ...interface{}) {
+	p.report(code, diagnostics.TokenSpan(tok), "", format, a...)
+}
+
+// report adds an error diagnostic, unless the parser is still recovering from
+// a previous one.
+func (p *Parser) report(code diagnostics.Code, span diagnostics.Span, hint string, format string, a ...interface{}) {
+	if p.panicking {
+		return
+	}
+
+	p.panicking = true
+	p.diagnostics = append(p.diagnostics, diagnostics.Diagnostic{
+		Code:     code,
+		Severity: diagnostics.Error,
+		Span:     span,
+		Message:  fmt.Sprintf(format, a...),
+		Hint:     hint,
+	})
+}
+
+// synchronize skips the rest of a statement that had a syntax error. It stops
+// at the end of the statement, before the brace that closes the enclosing
+// block or before the next statement keyword, so the caller can continue with
+// the next statement. It returns false if curToken already closed the block.
+func (p *Parser) synchronize(depth int) bool {
+	p.panicking = false
+
+	for !p.curTokenIs(token.EOF) {
+		if p.depth < depth {
+			return false
+		}
+
+		if p.depth == depth {
+			if p.curTokenIs(token.SEMICOLON) ||
+				p.peekTokenIs(token.RBRACE) ||
+				p.peekTokenIs(token.LET) ||
+				p.peekTokenIs(token.CONST) ||
+				p.peekTokenIs(token.RETURN) {
+				return true
+			}
+		}
+
+		p.nextToken()
+	}
+
+	return true
 }
 
 /*
@@ -151,6 +251,10 @@ func (p *Parser) ParseProgram() *ast.Program {
 			programm.Statements = append(programm.Statements, stmt)
 		}
 
+		if p.panicking {
+			p.synchronize(0)
+		}
+
 		p.nextToken()
 	}
 
@@ -158,11 +262,19 @@ func (p *Parser) ParseProgram() *ast.Program {
 }
 
 func (p *Parser) parseStatement() ast.Statement {
+	// A failed let or const returns a nil pointer, which must not end up
+	// in the program as a non-nil interface.
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		if stmt := p.parseLetStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
 	case token.CONST:
-		return p.parseConstStatement()
+		if stmt := p.parseConstStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
 	case token.RETURN:
 		return p.parseReturnStatement()
 	default:
@@ -177,6 +289,13 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		if stmt.Type = p.parseTypeAnnotation(); stmt.Type == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -188,14 +307,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 		fl.Name = stmt.Name.Value
 	}
 
-	if !p.peekTokenIs(token.SEMICOLON) {
-		msg := fmt.Sprintf("SyntaxError: [%d:%d] Expected token ';'", p.curToken.Position.Line, p.curToken.Position.Column + 1)
-		p.errors = append(p.errors, msg)
-	}
-
-	for !p.curTokenIs(token.SEMICOLON) {
-		p.nextToken()
-	}
+	p.expectSemicolon()
 
 	return stmt
 }
@@ -206,7 +318,21 @@ func (p *Parser) parseConstStatement() *ast.ConstStatement {
 		return nil
 	}
 
+	// `freeze` is only a modifier if another identifier follows, so it can
+	// still be used as a name.
+	if p.curToken.Literal == "freeze" && p.peekTokenIs(token.IDENT) {
+		stmt.Freeze = true
+		p.nextToken()
+	}
+
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		if stmt.Type = p.parseTypeAnnotation(); stmt.Type == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -218,9 +344,7 @@ func (p *Parser) parseConstStatement() *ast.ConstStatement {
 		fl.Name = stmt.Name.Value
 	}
 
-	for !p.curTokenIs(token.SEMICOLON) {
-		p.nextToken()
-	}
+	p.expectSemicolon()
 
 	return stmt
 }
@@ -264,7 +388,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	// prefix expressions
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		p.noPrefixParseFnError(p.curToken.Type)
+		p.noPrefixParseFnError()
 		return nil
 	}
 	leftExp := prefix()
@@ -303,8 +427,7 @@ func (p *Parser) parsePrefixAssignmentExpression() ast.Expression {
 	if n, ok := name.(*ast.Identifier); ok {
 		stmt.Name = n
 	} else {
-		msg := fmt.Sprintf("SyntaxError: [%d:%d] Expected assign token to be IDENT, got '%s' instead", p.curToken.Position.Line, p.curToken.Position.Column, name.TokenLiteral())
-		p.errors = append(p.errors, msg)
+		p.addError(diagnostics.InvalidAssignment, p.curToken, "Expected assign token to be IDENT, got '%s' instead", name.TokenLiteral())
 	}
 
 	stmt.Operator = p.prevToken.Literal
@@ -317,8 +440,7 @@ func (p *Parser) parsePrefixAssignmentExpression() ast.Expression {
 func (p *Parser) parsePostfixExpression() ast.Expression {
 	// The current postfix operator only support identifiers
 	if p.curToken.Type != token.IDENT {
-		msg := fmt.Sprintf("postfix operators are only supported on identifiers")
-		p.errors = append(p.errors, msg)
+		p.addError(diagnostics.InvalidAssignment, p.curToken, "postfix operators are only supported on identifiers")
 		return nil
 	}
 
@@ -349,7 +471,15 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
-	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// `x => ...` is an arrow function with a single parameter
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		return p.parseArrowFunction(ident.Token, []ast.Expression{ident})
+	}
+
+	return ident
 }
 
 func (p *Parser) parseBoolean() ast.Expression {
@@ -360,14 +490,51 @@ func (p *Parser) parseString() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseCharLiteral() ast.Expression {
+	literal := []rune(p.curToken.Literal)
+
+	if len(literal) == 2 && literal[0] == '\\' {
+		switch literal[1] {
+		case 'n':
+			literal = []rune{'\n'}
+		case 'r':
+			literal = []rune{'\r'}
+		case 't':
+			literal = []rune{'\t'}
+		case '0':
+			literal = []rune{0}
+		case '\'', '"', '\\':
+			literal = literal[1:]
+		}
+	}
+
+	if len(literal) != 1 || literal[0] == '\\' {
+		p.addError(diagnostics.InvalidLiteral, p.curToken, "invalid character literal '%s'", p.curToken.Literal)
+		return nil
+	}
+
+	return &ast.CharLiteral{Token: p.curToken, Value: literal[0]}
+}
+
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
-	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	digits, base, msg := splitIntegerLiteral(p.curToken.Literal)
+	if msg != "" {
+		p.addError(diagnostics.InvalidLiteral, p.curToken, "%s", msg)
+		return nil
+	}
+
+	value, err := strconv.ParseInt(digits, base, 64)
+
+	if errors.Is(err, strconv.ErrRange) {
+		if bigValue, ok := new(big.Int).SetString(digits, base); ok {
+			return &ast.BigIntegerLiteral{Token: p.curToken, Value: bigValue}
+		}
+	}
 
 	if err != nil {
-		msg := fmt.Sprintf("cound not parse %q as Integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(diagnostics.InvalidLiteral, p.curToken, "cound not parse %q as Integer", p.curToken.Literal)
 		return nil
 	}
 
@@ -375,9 +542,88 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
+// splitIntegerLiteral returns the digits of an integer literal without its base
+// prefix and underscores, together with the base. For malformed literals it
+// returns a message describing the problem instead.
+func splitIntegerLiteral(literal string) (string, int, string) {
+	name, digits, base := "decimal", literal, 10
+
+	if len(literal) > 1 && literal[0] == '0' {
+		switch literal[1] {
+		case 'x', 'X':
+			name, digits, base = "hexadecimal", literal[2:], 16
+		case 'o', 'O':
+			name, digits, base = "octal", literal[2:], 8
+		case 'b', 'B':
+			name, digits, base = "binary", literal[2:], 2
+		}
+	}
+
+	// An underscore may follow the base prefix, e.g. 0x_FF
+	if base != 10 && strings.HasPrefix(digits, "_") {
+		digits = digits[1:]
+	}
+
+	if digits == "" {
+		return "", 0, fmt.Sprintf("%s literal '%s' has no digits", name, literal)
+	}
+
+	for i, ch := range digits {
+		if ch == '_' {
+			if i == len(digits)-1 || digits[i+1] == '_' {
+				return "", 0, fmt.Sprintf("'_' must separate successive digits in '%s'", literal)
+			}
+			continue
+		}
+
+		if digitValue(ch) >= base {
+			return "", 0, fmt.Sprintf("invalid digit '%c' in %s literal '%s'", ch, name, literal)
+		}
+	}
+
+	// A leading zero used to mean octal, reading 0755 as decimal would
+	// silently change its value.
+	if base == 10 && len(digits) > 1 && digits[0] == '0' {
+		return "", 0, leadingZeroMessage(literal)
+	}
+
+	return strings.ReplaceAll(digits, "_", ""), base, ""
+}
+
+// leadingZeroMessage tells to remove the leading zeros of a decimal literal.
+// If the literal only has octal digits, it may have been meant as octal, so
+// the 0o prefix is suggested as well.
+func leadingZeroMessage(literal string) string {
+	message := fmt.Sprintf("leading zeros are not allowed in '%s', remove the leading zero", literal)
+
+	if strings.ContainsAny(literal, "89") {
+		return message
+	}
+
+	digits := strings.TrimLeft(literal, "0_")
+	if digits == "" {
+		digits = "0"
+	}
+
+	return fmt.Sprintf("%s or write '0o%s' for an octal number", message, digits)
+}
+
+func digitValue(ch rune) int {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'z':
+		return int(ch-'a') + 10
+	case 'A' <= ch && ch <= 'Z':
+		return int(ch-'A') + 10
+	}
+	return 36
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+	depth := p.depth
 
 	p.nextToken()
 
@@ -388,23 +634,69 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 			block.Statements = append(block.Statements, stmt)
 		}
 
+		if p.panicking && !p.synchronize(depth) {
+			break
+		}
+
 		p.nextToken()
 	}
 
+	block.Rbrace = p.curToken.Position
+
 	return block
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
-	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		array.Elements = []ast.Expression{}
+		return array
+	}
+
+	p.nextToken()
+	first := p.parseExpression(LOWEST)
+
+	// An element followed by `for` turns the literal into a comprehension.
+	if p.peekTokenIs(token.FOR) {
+		comprehension := &ast.ArrayComprehension{Token: array.Token, Element: first}
+		if !p.parseComprehensionClause(&comprehension.Variables, &comprehension.Iterable, &comprehension.Condition) {
+			return nil
+		}
+
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+
+		return comprehension
+	}
+
+	array.Elements = []ast.Expression{first}
+
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		array.Elements = append(array.Elements, p.parseExpression(LOWEST))
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
 
 	return array
 }
 
+func (p *Parser) parseSetLiteral() ast.Expression {
+	set := &ast.SetLiteral{Token: p.curToken}
+	set.Elements = p.parseExpressionList(token.RBRACE)
+
+	return set
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
+	hash.Pairs = []*ast.HashLiteralPair{}
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
@@ -417,7 +709,22 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 
-		hash.Pairs[key] = value
+		// The first pair followed by `for` turns the literal into a
+		// comprehension.
+		if len(hash.Pairs) == 0 && p.peekTokenIs(token.FOR) {
+			comprehension := &ast.HashComprehension{Token: hash.Token, Key: key, Value: value}
+			if !p.parseComprehensionClause(&comprehension.Variables, &comprehension.Iterable, &comprehension.Condition) {
+				return nil
+			}
+
+			if !p.expectPeek(token.RBRACE) {
+				return nil
+			}
+
+			return comprehension
+		}
+
+		hash.Pairs = append(hash.Pairs, &ast.HashLiteralPair{Key: key, Value: value})
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -431,6 +738,47 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
+// parseComprehensionClause parses `for a, b in iterable if condition`, with
+// the current token being the last token of the element expression.
+func (p *Parser) parseComprehensionClause(variables *[]*ast.Identifier, iterable *ast.Expression, condition *ast.Expression) bool {
+	if !p.expectPeek(token.FOR) {
+		return false
+	}
+
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return false
+		}
+
+		*variables = append(*variables, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if len(*variables) > 2 {
+		p.addError(diagnostics.InvalidComprehension, p.curToken, "Expected at most 2 comprehension variables, got %d", len(*variables))
+		return false
+	}
+
+	if !p.expectPeek(token.IN) {
+		return false
+	}
+
+	p.nextToken()
+	*iterable = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		*condition = p.parseExpression(LOWEST)
+	}
+
+	return true
+}
+
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	list := []ast.Expression{}
 
@@ -456,17 +804,108 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	start := p.curToken
+
+	// `() => ...` is an arrow function without parameters
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+
+		return p.parseArrowFunction(start, []ast.Expression{})
+	}
+
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
 
+	// A list of expressions can only be the parameters of an arrow function.
+	list := []ast.Expression{exp}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		list = append(list, p.parseExpression(LOWEST))
+	}
+
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
+	if len(list) > 1 || p.peekTokenIs(token.ARROW) {
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+
+		return p.parseArrowFunction(start, list)
+	}
+
 	return exp
 }
 
+// parseArrowFunction turns `(a, b = 1) => a + b` into a regular function
+// literal. The parameters have already been parsed as expressions, the current
+// token is the arrow.
+func (p *Parser) parseArrowFunction(start token.Token, params []ast.Expression) ast.Expression {
+	lit := &ast.FunctionLiteral{
+		Token:      token.Token{Type: token.FUNCTION, Literal: "function", Position: start.Position},
+		Parameters: []*ast.Identifier{},
+		Defaults:   make(map[string]ast.Expression),
+		Arrow:      true,
+	}
+
+	for _, param := range params {
+		switch param := param.(type) {
+		case *ast.Identifier:
+			lit.Parameters = append(lit.Parameters, param)
+
+		case *ast.AssignStatement:
+			if param.Name == nil || param.Operator != "=" || !isDefaultParameterLiteral(param.Value) {
+				p.addError(diagnostics.InvalidParameter, start, "Invalid arrow function parameter '%s'", param.String())
+				return nil
+			}
+
+			lit.Parameters = append(lit.Parameters, param.Name)
+			lit.Defaults[param.Name.Value] = param.Value
+
+		default:
+			p.addError(diagnostics.InvalidParameter, start, "Invalid arrow function parameter '%s'", param.String())
+			return nil
+		}
+	}
+
+	// A block body behaves like the body of a regular function, any other
+	// expression is the return value of the function.
+	p.enterFunction()
+	defer func() { lit.Generator = p.leaveFunction() }()
+
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		lit.Body = p.parseBlockStatement()
+		return lit
+	}
+
+	p.nextToken()
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression == nil {
+		return nil
+	}
+
+	lit.Body = &ast.BlockStatement{Token: stmt.Token, Statements: []ast.Statement{stmt}}
+
+	return lit
+}
+
+func isDefaultParameterLiteral(exp ast.Expression) bool {
+	switch exp.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	default:
+		return false
+	}
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
 	exp := &ast.IfExpression{Token: p.curToken}
 
@@ -512,17 +951,91 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Defaults, lit.Parameters = p.parseFunctionParameters()
+	lit.Defaults, lit.Parameters, lit.ParameterTypes = p.parseFunctionParameters()
+	if lit.Parameters == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		if lit.ReturnType = p.parseTypeAnnotation(); lit.ReturnType == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
+	p.enterFunction()
 	lit.Body = p.parseBlockStatement()
+	lit.Generator = p.leaveFunction()
 
 	return lit
 }
 
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	lit := &ast.MacroLiteral{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	defaults, parameters, _ := p.parseFunctionParameters()
+	if parameters == nil {
+		return nil
+	}
+	if len(defaults) > 0 {
+		p.addError(diagnostics.InvalidParameter, lit.Token, "macro parameters can not have default values")
+		return nil
+	}
+	lit.Parameters = parameters
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	lit.Body = p.parseBlockStatement()
+
+	return lit
+}
+
+func (p *Parser) parseYieldExpression() ast.Expression {
+	expression := &ast.YieldExpression{Token: p.curToken}
+
+	if len(p.functionYields) == 0 {
+		p.addError(diagnostics.MisplacedYield, p.curToken, "yield is only allowed inside functions")
+		return nil
+	}
+
+	p.functionYields[len(p.functionYields)-1] = true
+
+	// A bare `yield` produces null
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) {
+		return expression
+	}
+
+	p.nextToken()
+	expression.Value = p.parseExpression(LOWEST)
+
+	return expression
+}
+
+func (p *Parser) parseSpawnExpression() ast.Expression {
+	expression := &ast.SpawnExpression{Token: p.curToken}
+
+	p.nextToken()
+	call, ok := p.parseExpression(PREFIX).(*ast.CallExpression)
+	if !ok {
+		p.addError(diagnostics.InvalidSpawn, expression.Token, "spawn expects a function call")
+		return nil
+	}
+
+	expression.Call = call
+
+	return expression
+}
+
 func (p *Parser) parseWhileLoopExpression() ast.Expression {
 	expression := &ast.WhileLoopExpression{Token: p.curToken}
 
@@ -546,58 +1059,81 @@ func (p *Parser) parseWhileLoopExpression() ast.Expression {
 	return expression
 }
 
-func (p *Parser) parseFunctionParameters() (map[string]ast.Expression, []*ast.Identifier) {
+func (p *Parser) parseFunctionParameters() (map[string]ast.Expression, []*ast.Identifier, map[string]*ast.TypeAnnotation) {
 	identifiers := []*ast.Identifier{}
 	defaults := make(map[string]ast.Expression)
+	types := make(map[string]*ast.TypeAnnotation)
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return defaults, identifiers
+		return defaults, identifiers, types
 	}
 
-	p.nextToken()
-
-	// Keep going until we find a ")"
-	for !p.curTokenIs(token.RPAREN) {
-		if p.curTokenIs(token.EOF) {
-			msg := fmt.Sprintf("SyntaxError: [%d:%d] unterminated function parameters", p.curToken.Position.Line, p.curToken.Position.Column)
-			p.errors = append(p.errors, msg)
-			return nil, nil
-		}
-
+	for {
 		// Get the identifier.
+		if !p.expectPeek(token.IDENT) {
+			return nil, nil, nil
+		}
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
-		p.nextToken()
 
-		// If we encounter an = we have default parameters
-		if p.curTokenIs(token.ASSIGN) {
+		// An optional type annotation, like `a: int`
+		if p.peekTokenIs(token.COLON) {
 			p.nextToken()
+			t := p.parseTypeAnnotation()
+			if t == nil {
+				return nil, nil, nil
+			}
+			types[ident.Value] = t
+		}
 
+		// If we encounter an = we have default parameters
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
 
 			if p.curTokenOneOf([]token.TokenType{token.RPAREN, token.RBRACE, token.LPAREN, token.LBRACE}) {
-				msg := fmt.Sprintf("SyntaxError: [%d:%d] Unexpected token '%s'", p.curToken.Position.Line, p.curToken.Position.Column, p.curToken.Literal)
-				p.errors = append(p.errors, msg)
-				return nil, nil
+				p.addError(diagnostics.InvalidParameter, p.curToken, "Unexpected token '%s'", p.curToken.Literal)
+				return nil, nil, nil
 			}
 
 			if !p.curTokenOneOf([]token.TokenType{token.TRUE, token.FALSE, token.INT, token.STRING}) {
-				msg := fmt.Sprintf("SyntaxError: [%d:%d] Unsupported token %s for default parameter", p.curToken.Position.Line, p.curToken.Position.Column, p.curToken.Type)
-				p.errors = append(p.errors, msg)
-				return nil, nil
+				p.addError(diagnostics.InvalidParameter, p.curToken, "Unsupported token %s for default parameter", p.curToken.Type)
+				return nil, nil, nil
 			}
 
-			defaults[ident.Value] = p.parseExpressionStatement().Expression
-			p.nextToken()
+			defaults[ident.Value] = p.parseExpression(LOWEST)
 		}
 
-		if p.curTokenIs(token.COMMA) {
-			p.nextToken()
+		// A parameter is followed by a comma or the closing parenthesis. Any
+		// other token, like the '{' of the body, means the list is not closed.
+		if !p.peekTokenIs(token.COMMA) {
+			break
 		}
+		p.nextToken()
+
+		if p.peekTokenIs(token.RPAREN) {
+			break
+		}
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil, nil, nil
 	}
-	
 
-	return defaults, identifiers
+	return defaults, identifiers, types
+}
+
+// parseTypeAnnotation parses the type after the ':' that is the current token.
+// Types are plain names. `function` is a keyword, so it is accepted as well.
+func (p *Parser) parseTypeAnnotation() *ast.TypeAnnotation {
+	if !p.peekTokenIs(token.IDENT) && !p.peekTokenIs(token.FUNCTION) {
+		p.addError(diagnostics.UnexpectedToken, p.peekToken, "Unexpected %s, expected a type", describeToken(p.peekToken))
+		return nil
+	}
+
+	p.nextToken()
+	return &ast.TypeAnnotation{Token: p.curToken, Name: p.curToken.Literal}
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
@@ -627,8 +1163,7 @@ func (p *Parser) parseAssignExpression(name ast.Expression) ast.Expression {
 	if n, ok := name.(*ast.Identifier); ok {
 		stmt.Name = n
 	} else {
-		msg := fmt.Sprintf("SyntaxError: [%d:%d] Expected assign token to be IDENT, got '%s' instead.", p.curToken.Position.Line, p.curToken.Position.Column, name.TokenLiteral())
-		p.errors = append(p.errors, msg)
+		p.addError(diagnostics.InvalidAssignment, p.curToken, "Expected assign token to be IDENT, got '%s' instead.", name.TokenLiteral())
 	}
 
 	oper := p.curToken
@@ -650,6 +1185,25 @@ func (p *Parser) parseAssignExpression(name ast.Expression) ast.Expression {
 	return stmt
 }
 
+// parsePipelineExpression desugars `left |> f(args)` into `f(left, args)` and
+// `left |> f` into `f(left)`, so the engines only ever see plain calls.
+func (p *Parser) parsePipelineExpression(left ast.Expression) ast.Expression {
+	pipe := p.curToken
+
+	p.nextToken()
+	right := p.parseExpression(PIPE)
+	if right == nil {
+		return nil
+	}
+
+	if call, ok := right.(*ast.CallExpression); ok {
+		arguments := append([]ast.Expression{left}, call.Arguments...)
+		return &ast.CallExpression{Token: call.Token, Function: call.Function, Arguments: arguments, Pipeline: true}
+	}
+
+	return &ast.CallExpression{Token: pipe, Function: right, Arguments: []ast.Expression{left}, Pipeline: true}
+}
+
 /*
 ** Helper Functions
 ** ===========================================
@@ -666,10 +1220,37 @@ func (p *Parser) registerPostfix(tokenType token.TokenType, fn postfixParseFn) {
 	p.postfixParseFns[tokenType] = fn
 }
 
+func (p *Parser) enterFunction() {
+	p.functionYields = append(p.functionYields, false)
+}
+
+// leaveFunction returns true if the function body contained a yield.
+func (p *Parser) leaveFunction() bool {
+	yields := p.functionYields[len(p.functionYields)-1]
+	p.functionYields = p.functionYields[:len(p.functionYields)-1]
+
+	return yields
+}
+
 func (p *Parser) nextToken() {
 	p.prevToken = p.curToken
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	// An error of the lexer is an error of the statement it is found in.
+	if n := len(p.l.Diagnostics()); n > p.lexerErrors {
+		p.lexerErrors = n
+		p.panicking = true
+	}
+
+	switch p.curToken.Type {
+	case token.LBRACE, token.LSET:
+		p.depth++
+	case token.RBRACE:
+		if p.depth > 0 {
+			p.depth--
+		}
+	}
 }
 
 func (p *Parser) curTokenOneOf(t []token.TokenType) bool {
@@ -700,6 +1281,23 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	return false
 }
 
+// expectSemicolon advances to the semicolon that ends a statement. The error
+// points right behind the last token of the statement.
+func (p *Parser) expectSemicolon() {
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+		return
+	}
+
+	position := diagnostics.TokenSpan(p.curToken).End
+	p.report(
+		diagnostics.MissingSemicolon,
+		diagnostics.Span{Start: position, End: position},
+		"add ';' at the end of the statement",
+		"Expected token ';', found %s", describeToken(p.peekToken),
+	)
+}
+
 func (p *Parser) curPrecedence() int {
 	if p, ok := precedences[p.curToken.Type]; ok {
 		return p
@@ -717,11 +1315,22 @@ func (p *Parser) peekPrecedence() int {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("SyntaxError: [%d:%d] Unexpected token '%s', expected %s", p.peekToken.Position.Line, p.peekToken.Position.Column, p.peekToken.Literal, t)
-	p.errors = append(p.errors, msg)
+	p.addError(diagnostics.UnexpectedToken, p.peekToken, "Unexpected %s, expected %s", describeToken(p.peekToken), t)
 }
 
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+func (p *Parser) noPrefixParseFnError() {
+	if p.curTokenIs(token.ILLEGAL) {
+		p.addError(diagnostics.IllegalCharacter, p.curToken, "Illegal character '%s'", p.curToken.Literal)
+		return
+	}
+
+	p.addError(diagnostics.ExpectedExpression, p.curToken, "Unexpected %s, expected an expression", describeToken(p.curToken))
+}
+
+func describeToken(tok token.Token) string {
+	if tok.Type == token.EOF {
+		return "end of input"
+	}
+
+	return fmt.Sprintf("token '%s'", tok.Literal)
 }
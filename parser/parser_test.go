@@ -2,22 +2,30 @@ package parser
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/diagnostics"
 	"github.com/rhwilr/lemur/lexer"
 )
 
 func TestIncompleteLetConstStatement(t *testing.T) {
-	input := []string{"let", "const", "let x;", "const x;"}
+	input := []string{"let", "const", "let x;", "const x;", "let = 1;", "let y: = 3;"}
 
 	for _, str := range input {
 		l := lexer.New(str)
 		p := New(l)
-		_ = p.ParseProgram()
+		program := p.ParseProgram()
 
-		errors := p.errors
+		for _, stmt := range program.Statements {
+			if stmt == nil || reflect.ValueOf(stmt).IsNil() {
+				t.Errorf("input %q: program contains a nil statement", str)
+			}
+		}
+
+		errors := p.Errors()
 		if len(errors) < 1 {
 			t.Errorf("Unexpected error-count!")
 		}
@@ -71,6 +79,8 @@ func TestConstStatements(t *testing.T) {
 		{"const x = 5;", "x", 5},
 		{"const y = true;", "y", true},
 		{"const foobar = y;", "foobar", "y"},
+		{"const freeze = 5;", "freeze", 5},
+		{"const freeze z = 5;", "z", 5},
 	}
 
 	for _, tt := range tests {
@@ -96,6 +106,110 @@ func TestConstStatements(t *testing.T) {
 	}
 }
 
+func TestErrorRecovery(t *testing.T) {
+	input := `let = 1; if (x) { let y = ; y } let z = 3;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 2 {
+		t.Fatalf("expected 2 errors, got=%d: %v", len(p.Errors()), p.Errors())
+	}
+
+	last := program.Statements[len(program.Statements)-1]
+	if !testLetStatement(t, last, "z") {
+		return
+	}
+}
+
+func TestSyntaxErrorPositions(t *testing.T) {
+	l := lexer.NewWithFile("let x = 1;\nlet = 2;", "main.lem")
+	p := New(l)
+	p.ParseProgram()
+
+	found := p.Diagnostics()
+	if len(found) != 1 {
+		t.Fatalf("expected 1 diagnostic, got=%d", len(found))
+	}
+
+	if found[0].Pos().String() != "main.lem:2:5" {
+		t.Errorf("wrong position. got=%q", found[0].Pos())
+	}
+
+	if found[0].Severity != diagnostics.Error {
+		t.Errorf("wrong severity. got=%s", found[0].Severity)
+	}
+
+	expected := "SyntaxError: [main.lem:2:5] Unexpected token '=', expected IDENT"
+	if p.Errors()[0] != expected {
+		t.Errorf("wrong message. want=%q, got=%q", expected, p.Errors()[0])
+	}
+}
+
+func TestDiagnosticCodes(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedCode diagnostics.Code
+		expectedSpan string
+	}{
+		{`let 1 = 2;`, diagnostics.UnexpectedToken, "1:5-1:6"},
+		{`let a = ;`, diagnostics.ExpectedExpression, "1:9-1:10"},
+		{`let a = 1`, diagnostics.MissingSemicolon, "1:10-1:10"},
+		{`let a = @;`, diagnostics.IllegalCharacter, "1:9-1:10"},
+		{`let a = 0b12;`, diagnostics.InvalidLiteral, "1:9-1:13"},
+		{`yield 1;`, diagnostics.MisplacedYield, "1:1-1:6"},
+		{`let a = "abc`, diagnostics.UnterminatedString, "1:9-1:13"},
+		{`let a = 'ab`, diagnostics.UnterminatedChar, "1:9-1:12"},
+		{"let a = 'a\nlet b = 1;", diagnostics.UnterminatedChar, "1:9-1:11"},
+		{`let a = '\`, diagnostics.UnterminatedChar, "1:9-1:11"},
+		{`let a = 1; /* comment`, diagnostics.UnterminatedComment, "1:12-1:22"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		found := p.Diagnostics()
+		if len(found) != 1 {
+			t.Errorf("input %q: expected 1 diagnostic, got=%d: %v", tt.input, len(found), found)
+			continue
+		}
+
+		if found[0].Code != tt.expectedCode {
+			t.Errorf("input %q: wrong code. want=%s, got=%s", tt.input, tt.expectedCode, found[0].Code)
+		}
+
+		span := fmt.Sprintf("%s-%s", found[0].Span.Start, found[0].Span.End)
+		if span != tt.expectedSpan {
+			t.Errorf("input %q: wrong span. want=%s, got=%s", tt.input, tt.expectedSpan, span)
+		}
+	}
+}
+
+func TestFrozenConstStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"const x = [1];", false},
+		{"const freeze = [1];", false},
+		{"const freeze x = [1];", true},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ConstStatement)
+		if stmt.Freeze != tt.expected {
+			t.Errorf("stmt.Freeze wrong for %q. want=%t, got=%t", tt.input, tt.expected, stmt.Freeze)
+		}
+	}
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input         string
@@ -194,6 +308,63 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	input := "100000000000000000000;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	literal, ok := stmt.Expression.(*ast.BigIntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.BigIntegerLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Value.String() != "100000000000000000000" {
+		t.Errorf("literal.Value not %s. got=%s", "100000000000000000000", literal.Value)
+	}
+}
+
+func TestIntegerLiteralBases(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0x1F", 31},
+		{"0XFF", 255},
+		{"0o755", 493},
+		{"0b1010", 10},
+		{"1_000_000", 1000000},
+		{"0x_FF_FF", 65535},
+		{"0", 0},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %d. got=%d", tt.expected, literal.Value)
+		}
+		if literal.String() != tt.input {
+			t.Errorf("literal.String() not %s. got=%s", tt.input, literal.String())
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -304,6 +475,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"1..n + 1",
+			"(1 .. (n + 1))",
+		},
+		{
+			"0..<n == r",
+			"((0 ..< n) == r)",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -783,6 +962,215 @@ func TestFunctionDefaulltParameterParsing(t *testing.T) {
 	}
 }
 
+func TestTypeAnnotationParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let name: string = x;", "let name: string = x;"},
+		{"const limit: int = 10;", "const limit: int = 10;"},
+		{"let f = function(a: int, b) { a };", "let f = function<f>(a: int, b)a;"},
+		{"function add(a: int, b: int = 1): int { a + b }", "function<add>(a: int, b: int): int(a + b)"},
+		{"let f = function(g: function): function { g };", "let f = function<f>(g: function): functiong;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program. want=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	program := New(lexer.New("function add(a: int = 1): int { a }")).ParseProgram()
+	function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+
+	if function.ParameterTypes["a"].Name != "int" || function.ReturnType.Name != "int" {
+		t.Errorf("wrong annotations. got=%v, %v", function.ParameterTypes, function.ReturnType)
+	}
+	if function.Defaults["a"].String() != "1" {
+		t.Errorf("wrong default. got=%s", function.Defaults["a"])
+	}
+}
+
+func TestArrowFunctionParsing(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedParams []string
+		defaults       map[string]int64
+		expectedBody   string
+	}{
+		{input: "x => x * 2", expectedParams: []string{"x"}, expectedBody: "(x * 2)"},
+		{input: "(x) => x * 2", expectedParams: []string{"x"}, expectedBody: "(x * 2)"},
+		{input: "() => 1", expectedParams: []string{}, expectedBody: "1"},
+		{input: "(a, b) => { a + b; }", expectedParams: []string{"a", "b"}, expectedBody: "(a + b)"},
+		{input: "(a, b = 2) => a + b", expectedParams: []string{"a", "b"}, defaults: map[string]int64{"b": 2}, expectedBody: "(a + b)"},
+		{input: "x => y => x + y", expectedParams: []string{"x"}, expectedBody: "function(y)(x + y)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function, ok := stmt.Expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.FunctionLiteral. got=%T", stmt.Expression)
+		}
+
+		if len(function.Parameters) != len(tt.expectedParams) {
+			t.Fatalf("length parameters wrong. want %d, got=%d", len(tt.expectedParams), len(function.Parameters))
+		}
+
+		for i, ident := range tt.expectedParams {
+			testLiteralExpression(t, function.Parameters[i], ident)
+		}
+
+		if len(function.Defaults) != len(tt.defaults) {
+			t.Fatalf("length defaults wrong. want %d, got=%d", len(tt.defaults), len(function.Defaults))
+		}
+
+		for name, value := range tt.defaults {
+			testIntegerLiteral(t, function.Defaults[name], value)
+		}
+
+		if function.Body.String() != tt.expectedBody {
+			t.Errorf("body wrong. want=%q, got=%q", tt.expectedBody, function.Body.String())
+		}
+	}
+}
+
+func TestPipelineParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"xs |> f", "f(xs)"},
+		{"xs |> f()", "f(xs)"},
+		{"xs |> f(1, 2)", "f(xs, 1, 2)"},
+		{"xs |> filter(isEven) |> map(double)", "map(filter(xs, isEven), double)"},
+		{"1 + 2 |> f", "f((1 + 2))"},
+		{"a |> f == b", "(f(a) == b)"},
+		{"1..n |> f", "f((1 .. n))"},
+		{"xs |> (x => x)", "function(x)x(xs)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestGeneratorParsing(t *testing.T) {
+	tests := []struct {
+		input        string
+		generator    bool
+		expectedBody string
+	}{
+		{"function() { yield 1; }", true, "yield 1"},
+		{"function() { yield; }", true, "yield"},
+		{"function() { yield }", true, "yield"},
+		{"function() { yield a + b; }", true, "yield (a + b)"},
+		{"function() { 1; }", false, "1"},
+		{"x => yield x", true, "yield x"},
+		{"function() { function() { yield 1; } }", false, "function()yield 1"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function, ok := stmt.Expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.FunctionLiteral. got=%T", stmt.Expression)
+		}
+
+		if function.Generator != tt.generator {
+			t.Errorf("function.Generator wrong. want=%t, got=%t", tt.generator, function.Generator)
+		}
+
+		if function.Body.String() != tt.expectedBody {
+			t.Errorf("body wrong. want=%q, got=%q", tt.expectedBody, function.Body.String())
+		}
+	}
+}
+
+func TestSpawnParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"spawn f()", "spawn f()"},
+		{"spawn f(1, 2 * 3)", "spawn f(1, (2 * 3))"},
+		{"spawn f(1)(2)", "spawn f(1)(2)"},
+		{"spawn f(1) + 2", "(spawn f(1) + 2)"},
+		{"wait(spawn f())", "wait(spawn f())"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T",
+			stmt.Expression)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d\n",
+			len(macro.Parameters))
+	}
+
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+
+	if macro.Body.String() != "(x + y)" {
+		t.Errorf("body wrong. want=%q, got=%q", "(x + y)", macro.Body.String())
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -936,6 +1324,93 @@ func TestParsingArrayLiterals(t *testing.T) {
 	testInfixExpression(t, array.Elements[2], 3, "+", 3)
 }
 
+func TestParsingSetLiterals(t *testing.T) {
+	input := "#{1, 2 * 2}"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	set, ok := stmt.Expression.(*ast.SetLiteral)
+	if !ok {
+		t.Fatalf("exp not ast.SetLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(set.Elements) != 2 {
+		t.Fatalf("len(set.Elements) not 2. got=%d", len(set.Elements))
+	}
+
+	testIntegerLiteral(t, set.Elements[0], 1)
+	testInfixExpression(t, set.Elements[1], 2, "*", 2)
+
+	if set.String() != "#{1, (2 * 2)}" {
+		t.Errorf("set.String() wrong. got=%s", set.String())
+	}
+}
+
+func TestParsingArrayComprehensions(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  string
+		variables []string
+	}{
+		{"[x * 2 for x in xs]", "[(x * 2) for x in xs]", []string{"x"}},
+		{"[x for x in 1..10 if x > 2]", "[x for x in (1 .. 10) if (x > 2)]", []string{"x"}},
+		{"[i + v for i, v in xs]", "[(i + v) for i, v in xs]", []string{"i", "v"}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		comprehension, ok := stmt.Expression.(*ast.ArrayComprehension)
+		if !ok {
+			t.Fatalf("exp not *ast.ArrayComprehension. got=%T", stmt.Expression)
+		}
+
+		if comprehension.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, comprehension.String())
+		}
+
+		if len(comprehension.Variables) != len(tt.variables) {
+			t.Fatalf("wrong number of variables. want=%d, got=%d", len(tt.variables), len(comprehension.Variables))
+		}
+
+		for i, name := range tt.variables {
+			testIdentifier(t, comprehension.Variables[i], name)
+		}
+	}
+}
+
+func TestParsingHashComprehensions(t *testing.T) {
+	input := `{k: v * 2 for k, v in h if v != 0}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	comprehension, ok := stmt.Expression.(*ast.HashComprehension)
+	if !ok {
+		t.Fatalf("exp not *ast.HashComprehension. got=%T", stmt.Expression)
+	}
+
+	testIdentifier(t, comprehension.Key, "k")
+	testInfixExpression(t, comprehension.Value, "v", "*", 2)
+	testIdentifier(t, comprehension.Iterable, "h")
+	testInfixExpression(t, comprehension.Condition, "v", "!=", 0)
+
+	if len(comprehension.Variables) != 2 {
+		t.Fatalf("wrong number of variables. want=2, got=%d", len(comprehension.Variables))
+	}
+}
+
 func TestParsingIndexExpressions(t *testing.T) {
 	input := "myArray[1 + 1]"
 
@@ -983,7 +1458,8 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -1018,7 +1494,8 @@ func TestParsingHashLiteralsBooleanKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		boolean, ok := key.(*ast.Boolean)
 		if !ok {
 			t.Errorf("key is not ast.BooleanLiteral. got=%T", key)
@@ -1054,7 +1531,8 @@ func TestParsingHashLiteralsIntegerKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		integer, ok := key.(*ast.IntegerLiteral)
 		if !ok {
 			t.Errorf("key is not ast.IntegerLiteral. got=%T", key)
@@ -1097,7 +1575,8 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		},
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -1153,13 +1632,31 @@ func TestSyntaxErrors(t *testing.T) {
 			minusOne();
 			`,
 			expectedErrors: []string{
-				"SyntaxError: [2:29] Expected token ';'",
+				"SyntaxError: [2:29] Expected token ';', found token 'minusOne'",
 			},
 		},
 		{
 			input: `let ff = function(n) { if (n == 0) {return a} 1} ff(5, 1);`,
 			expectedErrors: []string{
-				"SyntaxError: [1:49] Expected token ';'",
+				"SyntaxError: [1:49] Expected token ';', found token 'ff'",
+			},
+		},
+		{
+			input: "let f = function(a { a };\nlet x = 1;",
+			expectedErrors: []string{
+				"SyntaxError: [1:20] Unexpected token '{', expected )",
+			},
+		},
+		{
+			input: "let f = function(a, { a };\nlet x = 1;",
+			expectedErrors: []string{
+				"SyntaxError: [1:21] Unexpected token '{', expected IDENT",
+			},
+		},
+		{
+			input: `function(a b) { a };`,
+			expectedErrors: []string{
+				"SyntaxError: [1:12] Unexpected token 'b', expected )",
 			},
 		},
 		{
@@ -1187,6 +1684,159 @@ func TestSyntaxErrors(t *testing.T) {
 				"SyntaxError: [1:14] Unsupported token FUNCTION for default parameter",
 			},
 		},
+		{
+			input: `[x for a, b, c in xs]`,
+			expectedErrors: []string{
+				"SyntaxError: [1:14] Expected at most 2 comprehension variables, got 3",
+			},
+		},
+		{
+			input: `(a, 1) => a`,
+			expectedErrors: []string{
+				"SyntaxError: [1:1] Invalid arrow function parameter '1'",
+			},
+		},
+		{
+			input: `(a, b = c) => a`,
+			expectedErrors: []string{
+				"SyntaxError: [1:1] Invalid arrow function parameter 'b=c'",
+			},
+		},
+		{
+			input: `(a, b)`,
+			expectedErrors: []string{
+				"SyntaxError: [1:7] Unexpected end of input, expected =>",
+			},
+		},
+		{
+			input: `[x for x of xs]`,
+			expectedErrors: []string{
+				"SyntaxError: [1:10] Unexpected token 'of', expected IN",
+			},
+		},
+		{
+			input: `let a = 0b102;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] invalid digit '2' in binary literal '0b102'",
+			},
+		},
+		{
+			input: `let a = 0o78;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] invalid digit '8' in octal literal '0o78'",
+			},
+		},
+		{
+			input: `let a = 0xFG;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] invalid digit 'G' in hexadecimal literal '0xFG'",
+			},
+		},
+		{
+			input: `let a = 0x;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] hexadecimal literal '0x' has no digits",
+			},
+		},
+		{
+			input: `let a = 1__000;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] '_' must separate successive digits in '1__000'",
+			},
+		},
+		{
+			input: `let a = 1000_;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] '_' must separate successive digits in '1000_'",
+			},
+		},
+		{
+			input: `let a = 0755;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] leading zeros are not allowed in '0755', remove the leading zero or write '0o755' for an octal number",
+			},
+		},
+		{
+			input: `let a = 0_1;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] leading zeros are not allowed in '0_1', remove the leading zero or write '0o1' for an octal number",
+			},
+		},
+		{
+			input: `let a = 0_089;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] leading zeros are not allowed in '0_089', remove the leading zero",
+			},
+		},
+		{
+			input: `let a = 12abc;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] invalid digit 'a' in decimal literal '12abc'",
+			},
+		},
+		{
+			input: `let c = 'ab';`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] invalid character literal 'ab'",
+			},
+		},
+		{
+			input: `let c = '';`,
+			expectedErrors: []string{
+				"SyntaxError: [1:9] invalid character literal ''",
+			},
+		},
+		{
+			input: `spawn f;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:1] spawn expects a function call",
+			},
+		},
+		{
+			input: `macro(x = 1) { x };`,
+			expectedErrors: []string{
+				"SyntaxError: [1:1] macro parameters can not have default values",
+			},
+		},
+		{
+			input: `yield 1;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:1] yield is only allowed inside functions",
+			},
+		},
+		{
+			input: `let a: = 5;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:8] Unexpected token '=', expected a type",
+			},
+		},
+		{
+			input: `let = 5; let b = ; let c = 1;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:5] Unexpected token '=', expected IDENT",
+				"SyntaxError: [1:18] Unexpected token ';', expected an expression",
+			},
+		},
+		{
+			input: `let a = 1 let b = 2;`,
+			expectedErrors: []string{
+				"SyntaxError: [1:10] Expected token ';', found token 'let'",
+			},
+		},
+		{
+			input: `if (x) { let = 1; } let y = );`,
+			expectedErrors: []string{
+				"SyntaxError: [1:14] Unexpected token '=', expected IDENT",
+				"SyntaxError: [1:29] Unexpected token ')', expected an expression",
+			},
+		},
+		{
+			input: `function() { let a = } let b = 1`,
+			expectedErrors: []string{
+				"SyntaxError: [1:22] Unexpected token '}', expected an expression",
+				"SyntaxError: [1:33] Expected token ';', found end of input",
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -1196,8 +1846,8 @@ func TestSyntaxErrors(t *testing.T) {
 		p := New(l)
 		_ = p.ParseProgram()
 
-		errors := p.errors
-		if len(errors) < len(test.expectedErrors) {
+		errors := p.Errors()
+		if len(errors) != len(test.expectedErrors) {
 			t.Fatalf("Unexpected error-count. got=%d, want=%d", len(errors), len(test.expectedErrors))
 		}
 
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/evaluator"
 	"github.com/rhwilr/lemur/lexer"
 	"github.com/rhwilr/lemur/object"
 	"github.com/rhwilr/lemur/parser"
@@ -20,6 +21,7 @@ func Start(in io.Reader, out io.Writer) {
 	// Init lemur parser and vm
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
+	macroEnv := object.NewEnvironment()
 
 	symbolTable := compiler.NewSymbolTable()
 	for i, v := range object.Builtins {
@@ -46,7 +48,7 @@ func Start(in io.Reader, out io.Writer) {
 		if (err != nil && strings.Contains(err.Error(), "control-c break")) || len(line) == 0 {
 			line, err = term.ReadLine()
 		} else {
-			out := evaluateLine(line, symbolTable, constants, globals)
+			out := evaluateLine(line, symbolTable, constants, globals, macroEnv)
 
 			term.Write([]byte(out + "\r\n"))
 			line, err = term.ReadLine()
@@ -54,7 +56,7 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func evaluateLine(line string, symbolTable *compiler.SymbolTable, constants []object.Object, globals []object.Object) string {
+func evaluateLine(line string, symbolTable *compiler.SymbolTable, constants []object.Object, globals []object.Object, macroEnv *object.Environment) string {
 	l := lexer.New(line)
 	p := parser.New(l)
 
@@ -64,8 +66,14 @@ func evaluateLine(line string, symbolTable *compiler.SymbolTable, constants []ob
 		return ""
 	}
 
+	program, err := evaluator.ExpandProgram(program, macroEnv)
+	if err != nil {
+		fmt.Printf("Woops! Macro expansion failed:\n %s\n", err)
+		return ""
+	}
+
 	comp := compiler.NewWithState(symbolTable, constants)
-	err := comp.Compile(program)
+	err = comp.Compile(program)
 	if err != nil {
 		fmt.Printf("Woops! Compilation failed:\n %s\n", err)
 		return ""
@@ -1,11 +1,33 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type TokenPosition struct {
-	Line int
+	File   string
+	Line   int
 	Column int
 }
+
+// String returns the position as `file:line:col`. The file is left out if it
+// is not known.
+func (p TokenPosition) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Comment is a comment in the source. Comments are not tokens, the lexer
+// collects them on the side so tools like the formatter can keep them.
+type Comment struct {
+	Text     string // including the '//' or '/* */'
+	Position TokenPosition
+	Trailing bool // the comment follows a token on the same line
+}
+
 type Token struct {
 	Type    TokenType
 	Literal string
@@ -21,6 +43,7 @@ const (
 	IDENT  = "IDENT" // Identifier like variable names
 	INT    = "INT"   // Integers 1, 2,3, 42...
 	STRING = "STRING"
+	CHAR   = "CHAR"
 
 	// Assignments
 	ASSIGN          = "="
@@ -50,6 +73,14 @@ const (
 	AND = "&&"
 	OR  = "||"
 
+	// Functions
+	ARROW = "=>"
+	PIPE  = "|>"
+
+	// Ranges
+	RANGE           = ".."
+	RANGE_EXCLUSIVE = "..<"
+
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -58,6 +89,7 @@ const (
 	LPAREN   = "("
 	RPAREN   = ")"
 	LBRACE   = "{"
+	LSET     = "#{"
 	RBRACE   = "}"
 	LBRACKET = "["
 	RBRACKET = "]"
@@ -72,6 +104,11 @@ const (
 	ELSE     = "ELSE"
 	WHILE    = "WHILE"
 	RETURN   = "RETURN"
+	FOR      = "FOR"
+	IN       = "IN"
+	YIELD    = "YIELD"
+	SPAWN    = "SPAWN"
+	MACRO    = "MACRO"
 )
 
 var keywords = map[string]TokenType{
@@ -84,6 +121,11 @@ var keywords = map[string]TokenType{
 	"else":     ELSE,
 	"while":    WHILE,
 	"return":   RETURN,
+	"for":      FOR,
+	"in":       IN,
+	"yield":    YIELD,
+	"spawn":    SPAWN,
+	"macro":    MACRO,
 }
 
 // LookupIdent checks, if the passed identifiers is reserved words. If that is
@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMessages(t *testing.T) {
+	var buf bytes.Buffer
+	WriteMessage(&buf, map[string]string{"a": "ö"})
+	WriteMessage(&buf, []int{1, 2})
+
+	if !strings.HasPrefix(buf.String(), "Content-Length: 10\r\n\r\n{\"a\":\"ö\"}") {
+		t.Fatalf("wrong message: %q", buf.String())
+	}
+
+	r := bufio.NewReader(&buf)
+	for _, expected := range []string{`{"a":"ö"}`, `[1,2]`} {
+		content, err := ReadMessage(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(content) != expected {
+			t.Errorf("wrong content. want=%q, got=%q", expected, content)
+		}
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"Content-Length: x\r\n\r\n{}", `invalid Content-Length "x"`},
+		{"Content-Type: json\r\n\r\n{}", `invalid Content-Length ""`},
+		{"Content-Length: 10\r\n\r\n{}", "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		_, err := ReadMessage(bufio.NewReader(strings.NewReader(tt.input)))
+		if err == nil || err.Error() != tt.expectedMessage {
+			t.Errorf("input %q: wrong error. want=%q, got=%v", tt.input, tt.expectedMessage, err)
+		}
+	}
+}
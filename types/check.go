@@ -0,0 +1,503 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/diagnostics"
+)
+
+// binding is the type of a name. Annotated names keep their type, values
+// assigned to them are checked against it.
+type binding struct {
+	typ       Type
+	annotated bool
+	signature *Signature
+}
+
+type environment struct {
+	store map[string]*binding
+	outer *environment
+}
+
+func (e *environment) get(name string) (*binding, bool) {
+	for env := e; env != nil; env = env.outer {
+		if b, ok := env.store[name]; ok {
+			return b, true
+		}
+	}
+
+	return nil, false
+}
+
+// function is the function whose body is checked.
+type function struct {
+	name    string
+	result  Type // the annotated result, or Any
+	returns []Type
+}
+
+type checker struct {
+	env         *environment
+	functions   []*function
+	diagnostics []diagnostics.Diagnostic
+	info        *Info
+}
+
+// Info holds the types inferred for the declarations of a program.
+type Info struct {
+	Types      map[*ast.Identifier]Type // the names of let, const and parameters
+	Signatures map[*ast.FunctionLiteral]*Signature
+}
+
+// Check infers the types of the program and returns the mismatches it finds.
+func Check(program *ast.Program) []diagnostics.Diagnostic {
+	c := check(program)
+
+	diagnostics.Sort(c.diagnostics)
+	return c.diagnostics
+}
+
+// Infer returns the types inferred for the names declared in the program.
+func Infer(program *ast.Program) *Info {
+	return check(program).info
+}
+
+func check(program *ast.Program) *checker {
+	c := &checker{
+		env: &environment{store: map[string]*binding{}},
+		info: &Info{
+			Types:      map[*ast.Identifier]Type{},
+			Signatures: map[*ast.FunctionLiteral]*Signature{},
+		},
+	}
+
+	c.checkStatements(program.Statements)
+
+	return c
+}
+
+// checkStatements returns the type of the last statement.
+func (c *checker) checkStatements(statements []ast.Statement) Type {
+	result := Null
+	for _, stmt := range statements {
+		result = c.checkStatement(stmt)
+	}
+
+	return result
+}
+
+func (c *checker) checkStatement(node ast.Statement) Type {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		c.declare(node.Name, node.Type, node.Value)
+
+	case *ast.ConstStatement:
+		c.declare(node.Name, node.Type, node.Value)
+
+	case *ast.ReturnStatement:
+		t := c.infer(node.ReturnValue)
+		if len(c.functions) > 0 {
+			fn := c.functions[len(c.functions)-1]
+			fn.returns = append(fn.returns, t)
+			c.checkResult(node.ReturnValue, fn, t)
+		}
+
+	case *ast.ExpressionStatement:
+		return c.infer(node.Expression)
+
+	case *ast.BlockStatement:
+		if node != nil {
+			return c.checkStatements(node.Statements)
+		}
+	}
+
+	return Any
+}
+
+// declare binds name to the type of value, which has to match the annotation.
+func (c *checker) declare(name *ast.Identifier, annotation *ast.TypeAnnotation, value ast.Expression) {
+	b := &binding{typ: Any}
+	if annotation != nil {
+		b.typ = c.lookup(annotation)
+		b.annotated = true
+	}
+
+	c.env.store[name.Value] = b
+
+	var t Type
+	if fn, ok := value.(*ast.FunctionLiteral); ok {
+		// The name is bound first, so the function can call itself.
+		b.signature = c.signature(fn)
+		c.checkFunction(fn, b.signature)
+		t = Function
+	} else {
+		t = c.infer(value)
+	}
+
+	if !Assignable(t, b.typ) {
+		c.report(value, diagnostics.TypeMismatch, "cannot use %s as %s in the declaration of %s", t, b.typ, name.Value)
+	}
+
+	if !b.annotated {
+		b.typ = t
+	}
+
+	c.info.Types[name] = b.typ
+}
+
+func (c *checker) infer(node ast.Expression) Type {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral, *ast.BigIntegerLiteral:
+		return Int
+	case *ast.StringLiteral:
+		return String
+	case *ast.CharLiteral:
+		return Char
+	case *ast.Boolean:
+		return Bool
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			c.infer(el)
+		}
+		return Array
+
+	case *ast.SetLiteral:
+		for _, el := range node.Elements {
+			c.infer(el)
+		}
+		return Set
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			c.infer(pair.Key)
+			c.infer(pair.Value)
+		}
+		return Hash
+
+	case *ast.Identifier:
+		if b, ok := c.env.get(node.Value); ok {
+			return b.typ
+		}
+		if _, ok := Builtins[node.Value]; ok {
+			return Function
+		}
+		return Any
+
+	case *ast.PrefixExpression:
+		return c.inferPrefix(node)
+
+	case *ast.InfixExpression:
+		return c.inferInfix(node)
+
+	case *ast.IfExpression:
+		c.infer(node.Condition)
+		consequence := c.checkStatement(node.Consequence)
+		if node.Alternative == nil {
+			return Any
+		}
+		return join(consequence, c.checkStatement(node.Alternative))
+
+	case *ast.WhileLoopExpression:
+		c.infer(node.Condition)
+		c.checkStatement(node.Consequence)
+		return Any
+
+	case *ast.AssignStatement:
+		return c.inferAssignment(node)
+
+	case *ast.PostfixExpression:
+		if b, ok := c.env.get(node.Name.Value); ok && !Assignable(b.typ, Int) {
+			c.report(node, diagnostics.InvalidOperands, "unsupported type for %s: %s", node.Operator, b.typ)
+		}
+		return Int
+
+	case *ast.IndexExpression:
+		left := c.infer(node.Left)
+		c.infer(node.Index)
+		// Indexing and slicing a string returns a string
+		if left == String {
+			return String
+		}
+		return Any
+
+	case *ast.ArrayComprehension:
+		c.enterScope()
+		c.infer(node.Iterable)
+		c.defineAny(node.Variables)
+		c.infer(node.Condition)
+		c.infer(node.Element)
+		c.leaveScope()
+		return Array
+
+	case *ast.HashComprehension:
+		c.enterScope()
+		c.infer(node.Iterable)
+		c.defineAny(node.Variables)
+		c.infer(node.Condition)
+		c.infer(node.Key)
+		c.infer(node.Value)
+		c.leaveScope()
+		return Hash
+
+	case *ast.FunctionLiteral:
+		c.checkFunction(node, c.signature(node))
+		return Function
+
+	case *ast.CallExpression:
+		return c.inferCall(node)
+
+	case *ast.YieldExpression:
+		c.infer(node.Value)
+		return Any
+
+	case *ast.SpawnExpression:
+		if node.Call != nil {
+			c.infer(node.Call)
+		}
+		return Any
+	}
+
+	return Any
+}
+
+func (c *checker) inferPrefix(node *ast.PrefixExpression) Type {
+	right := c.infer(node.Right)
+
+	switch node.Operator {
+	case "!":
+		return Bool
+	case "-":
+		if !Assignable(right, Int) {
+			c.report(node, diagnostics.InvalidOperands, "unsupported type for negation: %s", right)
+		}
+		return Int
+	}
+
+	return Any
+}
+
+func (c *checker) inferInfix(node *ast.InfixExpression) Type {
+	left := c.infer(node.Left)
+	right := c.infer(node.Right)
+
+	result, ok := binary(node.Operator, left, right)
+	if !ok {
+		c.report(node, diagnostics.InvalidOperands, "unsupported operand types for %s: %s and %s", node.Operator, left, right)
+	}
+
+	return result
+}
+
+// binary returns the result type of a binary operation. It returns false if
+// the VM would fail on operands of these types.
+func binary(operator string, left, right Type) (Type, bool) {
+	switch operator {
+	case "+":
+		if left == Any || right == Any {
+			return Any, isText(left) || isText(right) || Assignable(left, Int) && Assignable(right, Int)
+		}
+		if left == Int && right == Int {
+			return Int, true
+		}
+		return String, isText(left) && isText(right)
+
+	case "-", "*", "/":
+		return Int, Assignable(left, Int) && Assignable(right, Int)
+
+	case "<", ">", "<=", ">=":
+		if left == Any || right == Any {
+			return Bool, true
+		}
+		return Bool, left == right && (left == Int || left == String || left == Char)
+
+	case "==", "!=":
+		return Bool, true
+
+	case "&&", "||":
+		return join(left, right), true
+
+	case "..", "..<":
+		return Range, Assignable(left, Int) && Assignable(right, Int)
+	}
+
+	return Any, true
+}
+
+func (c *checker) inferAssignment(node *ast.AssignStatement) Type {
+	value := c.infer(node.Value)
+	if node.Name == nil {
+		return Any
+	}
+
+	b, ok := c.env.get(node.Name.Value)
+	if !ok {
+		return Any
+	}
+
+	if node.Operator != "=" {
+		operator := node.Operator[:1]
+		if _, ok := binary(operator, b.typ, value); !ok {
+			c.report(node, diagnostics.InvalidOperands, "unsupported operand types for %s: %s and %s", node.Operator, b.typ, value)
+		}
+		return Any
+	}
+
+	if b.annotated {
+		if !Assignable(value, b.typ) {
+			c.report(node.Value, diagnostics.TypeMismatch, "cannot assign %s to %s of type %s", value, node.Name.Value, b.typ)
+		}
+		return Any
+	}
+
+	// Without an annotation the name may hold values of different types.
+	b.typ = join(b.typ, value)
+	b.signature = nil
+
+	return Any
+}
+
+func (c *checker) inferCall(node *ast.CallExpression) Type {
+	c.infer(node.Function)
+
+	var signature *Signature
+	name := "function"
+
+	if ident, ok := node.Function.(*ast.Identifier); ok {
+		name = ident.Value
+		if b, ok := c.env.get(ident.Value); ok {
+			signature = b.signature
+		} else {
+			signature = Builtins[ident.Value]
+		}
+	}
+
+	for i, arg := range node.Arguments {
+		t := c.infer(arg)
+		if signature == nil {
+			continue
+		}
+
+		if expected := signature.parameter(i); !Assignable(t, expected) {
+			c.report(arg, diagnostics.TypeMismatch, "argument %d of %s must be %s, got %s", i+1, name, expected, t)
+		}
+	}
+
+	if signature == nil {
+		return Any
+	}
+
+	return signature.Return
+}
+
+// signature returns the signature described by the annotations of fn.
+func (c *checker) signature(fn *ast.FunctionLiteral) *Signature {
+	s := &Signature{Parameters: make([]Type, len(fn.Parameters)), Return: Any}
+
+	for i, p := range fn.Parameters {
+		s.Parameters[i] = Any
+		if annotation, ok := fn.ParameterTypes[p.Value]; ok {
+			s.Parameters[i] = c.lookup(annotation)
+		}
+	}
+
+	if fn.ReturnType != nil {
+		s.Return = c.lookup(fn.ReturnType)
+	}
+
+	return s
+}
+
+// checkFunction checks the body of fn. Without a result annotation the
+// result is inferred and stored in the signature.
+func (c *checker) checkFunction(node *ast.FunctionLiteral, signature *Signature) {
+	if node.Define {
+		c.env.store[node.Name] = &binding{typ: Function, signature: signature}
+	}
+
+	c.info.Signatures[node] = signature
+	c.enterScope()
+
+	for i, p := range node.Parameters {
+		c.env.store[p.Value] = &binding{typ: signature.Parameters[i], annotated: true}
+		c.info.Types[p] = signature.Parameters[i]
+
+		if value, ok := node.Defaults[p.Value]; ok {
+			if t := c.infer(value); !Assignable(t, signature.Parameters[i]) {
+				c.report(value, diagnostics.TypeMismatch, "cannot use %s as %s in the default of %s", t, signature.Parameters[i], p.Value)
+			}
+		}
+	}
+
+	fn := &function{name: node.Name, result: signature.Return}
+	c.functions = append(c.functions, fn)
+
+	last := c.checkStatement(node.Body)
+	if len(node.Body.Statements) > 0 && !node.Generator {
+		if stmt, ok := node.Body.Statements[len(node.Body.Statements)-1].(*ast.ExpressionStatement); ok {
+			fn.returns = append(fn.returns, last)
+			c.checkResult(stmt.Expression, fn, last)
+		}
+	}
+
+	c.functions = c.functions[:len(c.functions)-1]
+	c.leaveScope()
+
+	if node.ReturnType == nil && len(fn.returns) > 0 && !node.Generator {
+		result := fn.returns[0]
+		for _, t := range fn.returns[1:] {
+			result = join(result, t)
+		}
+		signature.Return = result
+	}
+}
+
+func (c *checker) checkResult(node ast.Node, fn *function, t Type) {
+	if Assignable(t, fn.result) {
+		return
+	}
+
+	name := fn.name
+	if name == "" {
+		name = "function"
+	}
+	c.report(node, diagnostics.TypeMismatch, "%s must return %s, got %s", name, fn.result, t)
+}
+
+func (c *checker) lookup(annotation *ast.TypeAnnotation) Type {
+	t, ok := Lookup(annotation.Name)
+	if !ok {
+		c.report(annotation, diagnostics.UnknownType, "unknown type %s", annotation.Name)
+		return Any
+	}
+
+	return t
+}
+
+func (c *checker) enterScope() {
+	c.env = &environment{store: map[string]*binding{}, outer: c.env}
+}
+
+func (c *checker) leaveScope() {
+	c.env = c.env.outer
+}
+
+func (c *checker) defineAny(names []*ast.Identifier) {
+	for _, name := range names {
+		c.env.store[name.Value] = &binding{typ: Any}
+	}
+}
+
+func (c *checker) report(node ast.Node, code diagnostics.Code, format string, a ...interface{}) {
+	c.diagnostics = append(c.diagnostics, diagnostics.Diagnostic{
+		Code:     code,
+		Severity: diagnostics.Error,
+		Span:     diagnostics.Span{Start: node.Pos(), End: node.Pos()},
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+func isText(t Type) bool {
+	return t == String || t == Char
+}
@@ -0,0 +1,105 @@
+// Package types checks the optional type annotations of a program before it
+// runs. Types are inferred for literals, operators, builtins and functions.
+// Values without a known type are of type Any, which is compatible with every
+// other type, so programs without annotations are accepted as they are.
+package types
+
+// Type is the static type of a value, named like in an annotation.
+type Type string
+
+const (
+	Any      Type = "any"
+	Int      Type = "int"
+	String   Type = "string"
+	Char     Type = "char"
+	Bool     Type = "bool"
+	Null     Type = "null"
+	Array    Type = "array"
+	Hash     Type = "hash"
+	Set      Type = "set"
+	Range    Type = "range"
+	Function Type = "function"
+	Error    Type = "error"
+)
+
+var names = map[string]Type{}
+
+func init() {
+	for _, t := range []Type{Any, Int, String, Char, Bool, Null, Array, Hash, Set, Range, Function, Error} {
+		names[string(t)] = t
+	}
+}
+
+// Lookup returns the type written as name in an annotation.
+func Lookup(name string) (Type, bool) {
+	t, ok := names[name]
+	return t, ok
+}
+
+// Assignable reports whether a value of type from can be used where a value of
+// type to is expected.
+func Assignable(from, to Type) bool {
+	return from == Any || to == Any || from == to
+}
+
+// join returns the type of a value that is either a or b.
+func join(a, b Type) Type {
+	if a == b {
+		return a
+	}
+
+	return Any
+}
+
+// Signature describes the parameters and the result of a function.
+type Signature struct {
+	Parameters []Type
+	Return     Type
+
+	// Variadic functions take any number of arguments of the last parameter
+	// type.
+	Variadic bool
+}
+
+// parameter returns the expected type of the i-th argument.
+func (s *Signature) parameter(i int) Type {
+	if i < len(s.Parameters) {
+		return s.Parameters[i]
+	}
+
+	if s.Variadic && len(s.Parameters) > 0 {
+		return s.Parameters[len(s.Parameters)-1]
+	}
+
+	return Any
+}
+
+// Builtins holds the signatures of the builtin functions. Builtins that are
+// missing accept and return values of any type.
+var Builtins = map[string]*Signature{
+	"len":          {Parameters: []Type{Any}, Return: Int},
+	"push":         {Parameters: []Type{Array, Any}, Return: Array},
+	"print":        {Parameters: []Type{Any}, Return: Null, Variadic: true},
+	"println":      {Parameters: []Type{Any}, Return: Null, Variadic: true},
+	"ord":          {Parameters: []Type{Any}, Return: Int},
+	"chr":          {Parameters: []Type{Int}, Return: Char},
+	"keys":         {Parameters: []Type{Hash}, Return: Array},
+	"add":          {Parameters: []Type{Set, Any}, Return: Set},
+	"remove":       {Parameters: []Type{Set, Any}, Return: Set},
+	"has":          {Parameters: []Type{Set, Any}, Return: Bool},
+	"union":        {Parameters: []Type{Set, Set}, Return: Set},
+	"intersection": {Parameters: []Type{Set, Set}, Return: Set},
+	"difference":   {Parameters: []Type{Set, Set}, Return: Set},
+	"frozen":       {Parameters: []Type{Any}, Return: Bool},
+	"type":         {Parameters: []Type{Any}, Return: String},
+	"arity":        {Parameters: []Type{Function}, Return: Int},
+	"name":         {Parameters: []Type{Function}, Return: String},
+	"params":       {Parameters: []Type{Function}, Return: Array},
+	"error":        {Parameters: []Type{String, Any}, Return: Error},
+	"isError":      {Parameters: []Type{Any}, Return: Bool},
+	"errorMessage": {Parameters: []Type{Error}, Return: String},
+	"errorData":    {Parameters: []Type{Error}, Return: Any},
+	"wrap":         {Parameters: []Type{Error, String}, Return: Error},
+	"unwrap":       {Parameters: []Type{Error}, Return: Any},
+	"sleep":        {Parameters: []Type{Int}, Return: Null},
+}
@@ -0,0 +1,151 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/rhwilr/lemur/ast"
+	"github.com/rhwilr/lemur/lexer"
+	"github.com/rhwilr/lemur/parser"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`let a = 1; let b = a + 2; "x" + 'y';`, []string{}},
+		{`let a = b; a + "x"; a - 1;`, []string{}},
+		{
+			`"a" - 1;`,
+			[]string{"1:5: error[T003]: unsupported operand types for -: string and int"},
+		},
+		{
+			`-"a"; 1 < "a"; true + 1;`,
+			[]string{
+				"1:1: error[T003]: unsupported type for negation: string",
+				"1:9: error[T003]: unsupported operand types for <: int and string",
+				"1:21: error[T003]: unsupported operand types for +: bool and int",
+			},
+		},
+		{
+			`let name: string = 5;`,
+			[]string{"1:20: error[T001]: cannot use int as string in the declaration of name"},
+		},
+		{
+			`let count: int = 1; count = "a";`,
+			[]string{"1:29: error[T001]: cannot assign string to count of type int"},
+		},
+		{
+			`let x = 1; x = "a"; x - 1;`,
+			[]string{},
+		},
+		{
+			`let n: number = 1;`,
+			[]string{"1:8: error[T002]: unknown type number"},
+		},
+		{
+			`function add(a: int, b: int): int { a + b } add(1, "2");`,
+			[]string{"1:52: error[T001]: argument 2 of add must be int, got string"},
+		},
+		{
+			`let f = function(a: string): int { return a; };`,
+			[]string{"1:43: error[T001]: f must return int, got string"},
+		},
+		{
+			`let double = function(x) { x * 2 }; let s: string = double(2);`,
+			[]string{"1:53: error[T001]: cannot use int as string in the declaration of s"},
+		},
+		{
+			`let f = function(a: int) { a }; f("a");`,
+			[]string{"1:35: error[T001]: argument 1 of f must be int, got string"},
+		},
+		{
+			`let s: string = len([1]); chr("a");`,
+			[]string{
+				"1:17: error[T001]: cannot use int as string in the declaration of s",
+				"1:31: error[T001]: argument 1 of chr must be int, got string",
+			},
+		},
+		{
+			`let c: string = "abc"[0]; let d: char = "abc"[1];`,
+			[]string{"1:46: error[T001]: cannot use string as char in the declaration of d"},
+		},
+		{
+			`let s = "a"; s += "b"; s -= 1;`,
+			[]string{"1:26: error[T003]: unsupported operand types for -=: string and int"},
+		},
+		{
+			`let f = function(a: int = "x") { a };`,
+			[]string{"1:27: error[T001]: cannot use string as int in the default of a"},
+		},
+	}
+
+	for _, tt := range tests {
+		found := Check(parse(t, tt.input))
+
+		if len(found) != len(tt.expected) {
+			t.Errorf("input %q: wrong number of diagnostics. want=%d, got=%d: %v", tt.input, len(tt.expected), len(found), found)
+			continue
+		}
+
+		for i, expected := range tt.expected {
+			if found[i].String() != expected {
+				t.Errorf("input %q: wrong diagnostic. want=%q, got=%q", tt.input, expected, found[i].String())
+			}
+		}
+	}
+}
+
+func TestAssignable(t *testing.T) {
+	tests := []struct {
+		from, to Type
+		expected bool
+	}{
+		{Int, Int, true},
+		{Any, Int, true},
+		{String, Any, true},
+		{String, Int, false},
+		{Char, String, false},
+	}
+
+	for _, tt := range tests {
+		if got := Assignable(tt.from, tt.to); got != tt.expected {
+			t.Errorf("Assignable(%s, %s) = %t, want %t", tt.from, tt.to, got, tt.expected)
+		}
+	}
+}
+
+func TestInfer(t *testing.T) {
+	program := parse(t, `let a = 1; let f = function(x: string, y) { x + "!" }; let b = f("a", 2);`)
+	info := Infer(program)
+
+	expected := map[string]Type{"a": Int, "f": Function, "x": String, "y": Any, "b": String}
+	for ident, typ := range info.Types {
+		if expected[ident.Value] != typ {
+			t.Errorf("wrong type for %s. want=%s, got=%s", ident.Value, expected[ident.Value], typ)
+		}
+		delete(expected, ident.Value)
+	}
+
+	if len(expected) > 0 {
+		t.Errorf("missing types for %v", expected)
+	}
+
+	fn := program.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if s := info.Signatures[fn]; s == nil || s.Return != String {
+		t.Errorf("wrong signature for f: %+v", s)
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	parsed := p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) > 0 {
+		t.Fatalf("parse error: %s", errors)
+	}
+
+	return parsed
+}
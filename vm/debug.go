@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"github.com/rhwilr/lemur/object"
+)
+
+// Hook is called before each instruction of the main program is executed.
+// Returning an error stops the program with that error.
+type Hook func(vm *VM) error
+
+// Variable is a named value of a running program.
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// SetHook attaches a debugger to the VM. Passing nil detaches it. Generators
+// and spawned tasks run on their own VMs and are not stepped through.
+func (vm *VM) SetHook(hook Hook) {
+	vm.hook = hook
+}
+
+// Depth returns the number of calls on the stack, 1 while the main program
+// runs.
+func (vm *VM) Depth() int {
+	return vm.framesIndex
+}
+
+// Position returns the innermost call with the position of the instruction
+// that is executed next.
+func (vm *VM) Position() StackFrame {
+	return vm.stackFrame(vm.currentFrame())
+}
+
+// Locals returns the parameters, locals and free variables of a call. The
+// calls are counted from the innermost one, like in StackTrace. Locals that
+// have not been set are left out.
+func (vm *VM) Locals(call int) []Variable {
+	if call < 0 || call >= vm.framesIndex {
+		return nil
+	}
+
+	frame := vm.frames[vm.framesIndex-1-call]
+	variables := []Variable{}
+
+	for i, name := range frame.cl.Fn.LocalNames {
+		if value := vm.stack[frame.basePointer+i]; value != nil {
+			variables = append(variables, Variable{Name: name, Value: value})
+		}
+	}
+
+	for i, name := range frame.cl.Fn.FreeNames {
+		if i < len(frame.cl.Free) {
+			variables = append(variables, Variable{Name: name, Value: frame.cl.Free[i]})
+		}
+	}
+
+	return variables
+}
+
+// Globals returns the global variables that have been set.
+func (vm *VM) Globals() []Variable {
+	vm.globalsLock.RLock()
+	defer vm.globalsLock.RUnlock()
+
+	variables := []Variable{}
+	for i, name := range vm.globalNames {
+		if i < len(vm.globals) && vm.globals[i] != nil {
+			variables = append(variables, Variable{Name: name, Value: vm.globals[i]})
+		}
+	}
+
+	return variables
+}
@@ -0,0 +1,29 @@
+package vm
+
+import (
+	"github.com/rhwilr/lemur/object"
+)
+
+// newGenerator creates the generator returned by calling a generator function.
+// The function body runs on a separate VM that shares the constants and globals
+// with the calling VM. Its stack and frames hold the suspended function between
+// two calls to Next.
+func (vm *VM) newGenerator(cl *object.Closure, args []object.Object) *object.Generator {
+	return object.NewGenerator(vm.newFunctionVM(cl, args).resume)
+}
+
+// resume runs the generator until it yields the next value or returns.
+func (vm *VM) resume() (object.Object, bool, error) {
+	vm.yielded = nil
+
+	err := vm.run()
+	if err != nil {
+		return nil, false, vm.runtimeError(err)
+	}
+
+	if vm.yielded == nil {
+		return nil, false, nil
+	}
+
+	return vm.yielded, true, nil
+}
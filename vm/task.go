@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/rhwilr/lemur/object"
+)
+
+// executeSpawn calls the function on the stack concurrently and replaces the
+// function and its arguments with the task running it.
+func (vm *VM) executeSpawn(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+	vm.sp -= numArgs + 1
+
+	var task *object.Task
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		err := checkArguments(callee, numArgs)
+		if err != nil {
+			return err
+		}
+
+		child := vm.newFunctionVM(callee, args)
+		if callee.Fn.Generator {
+			task = object.NewTask(func() (object.Object, error) {
+				return object.NewGenerator(child.resume), nil
+			})
+			break
+		}
+
+		task = object.NewTask(child.runTask)
+
+	case *object.Builtin:
+		task = object.NewTask(func() (object.Object, error) {
+			result := callee.Fn(args...)
+			if result == nil {
+				return Null, nil
+			}
+
+			return result, nil
+		})
+
+	default:
+		return fmt.Errorf("calling non-function and non-built-in")
+	}
+
+	return vm.push(task)
+}
+
+// newFunctionVM creates a VM that runs the closure as its bottom frame. The new
+// VM has its own stack and frames, but shares the constants and globals with
+// the calling VM.
+func (vm *VM) newFunctionVM(cl *object.Closure, args []object.Object) *VM {
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = NewFrame(cl, 0, defaultParametersOffset(cl, len(args)))
+
+	child := &VM{
+		constants: vm.constants,
+
+		stack: make([]object.Object, StackSize),
+		sp:    cl.Fn.NumLocals,
+
+		globals:     vm.globals,
+		globalsLock: vm.globalsLock,
+
+		builtins: vm.builtins,
+
+		frames:      frames,
+		framesIndex: 1,
+
+		file: vm.file,
+	}
+	copy(child.stack, args)
+
+	return child
+}
+
+// runTask runs the function until it returns and produces its return value.
+func (vm *VM) runTask() (object.Object, error) {
+	err := vm.run()
+	if err != nil {
+		return nil, vm.runtimeError(err)
+	}
+
+	return vm.LastPoppedStackElem(), nil
+}
@@ -0,0 +1,44 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/rhwilr/lemur/object"
+)
+
+// runCallback runs the callback of a timer once the main program is done. The
+// callback replaces the finished bottom frame, so its return value becomes the
+// last popped element.
+func (vm *VM) runCallback(callback object.Object) error {
+	switch callback := callback.(type) {
+	case *object.Closure:
+		err := checkArguments(callback, 0)
+		if err != nil {
+			return err
+		}
+
+		// Calling a generator function only creates the generator
+		if callback.Fn.Generator {
+			return nil
+		}
+
+		vm.frames[0] = NewFrame(callback, 0, defaultParametersOffset(callback, 0))
+		vm.framesIndex = 1
+		vm.sp = callback.Fn.NumLocals
+
+		return vm.run()
+
+	case *object.Builtin:
+		vm.sp = 0
+		if result := callback.Fn(); result != nil {
+			vm.stack[vm.sp] = result
+		} else {
+			vm.stack[vm.sp] = Null
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("calling non-function and non-built-in")
+	}
+}
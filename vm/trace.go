@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StackFrame is one call in the stack trace of a runtime error.
+type StackFrame struct {
+	Function string
+	File     string
+	Line     int // 0 if the bytecode has no line table
+	Column   int
+}
+
+func (f StackFrame) String() string {
+	if f.Line == 0 {
+		return fmt.Sprintf("at %s", f.Function)
+	}
+
+	if f.File == "" {
+		return fmt.Sprintf("at %s (%d:%d)", f.Function, f.Line, f.Column)
+	}
+
+	return fmt.Sprintf("at %s (%s:%d:%d)", f.Function, f.File, f.Line, f.Column)
+}
+
+// RuntimeError is returned when a program fails. The trace starts with the
+// innermost call.
+type RuntimeError struct {
+	Message string
+	Trace   []StackFrame
+}
+
+func (e *RuntimeError) Error() string { return e.Message }
+
+// StackTrace returns the trace with one call per line.
+func (e *RuntimeError) StackTrace() string {
+	lines := make([]string, len(e.Trace))
+	for i, frame := range e.Trace {
+		lines[i] = "    " + frame.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// runtimeError adds the stack trace of the failing VM to err.
+func (vm *VM) runtimeError(err error) error {
+	if _, ok := err.(*RuntimeError); ok {
+		return err
+	}
+
+	return &RuntimeError{Message: err.Error(), Trace: vm.StackTrace()}
+}
+
+// StackTrace returns the calls of the running program, starting with the
+// innermost call.
+func (vm *VM) StackTrace() []StackFrame {
+	trace := make([]StackFrame, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		trace = append(trace, vm.stackFrame(vm.frames[i]))
+	}
+
+	return trace
+}
+
+func (vm *VM) stackFrame(frame *Frame) StackFrame {
+	stackFrame := StackFrame{Function: frame.cl.Fn.Name, File: vm.file}
+	if stackFrame.Function == "" {
+		stackFrame.Function = "<anonymous>"
+	}
+
+	if entry, ok := frame.cl.Fn.Lines.Lookup(frame.ip); ok {
+		stackFrame.Line = entry.Line
+		stackFrame.Column = entry.Column
+	}
+
+	return stackFrame
+}
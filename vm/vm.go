@@ -2,9 +2,12 @@ package vm
 
 import (
 	"fmt"
+	"io"
+	"sync"
 
 	"github.com/rhwilr/lemur/code"
 	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/eventloop"
 	"github.com/rhwilr/lemur/object"
 )
 
@@ -22,30 +25,75 @@ type VM struct {
 	framesIndex int
 
 	globals []object.Object
+	// globalsLock guards the globals, which are shared with spawned tasks.
+	globalsLock *sync.RWMutex
+
+	builtins []*object.Builtin
+
+	// loop holds the timers of the program. Only the VM running the main
+	// program has a loop, generators and tasks schedule their timers on it
+	// through the builtins.
+	loop *eventloop.Loop
+
+	// yielded holds the value passed to the last yield when the VM runs a
+	// generator. It is nil if the generator returned instead.
+	yielded object.Object
+
+	// file is the name of the source file, used in stack traces
+	file string
+
+	// globalNames holds the names of the globals for debuggers
+	globalNames []string
+
+	// hook is called before each instruction while a debugger is attached
+	hook Hook
 }
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	return NewWithClock(bytecode, nil)
+}
+
+// NewWithClock creates a VM whose timers use the given clock. Passing an
+// eventloop.FakeClock runs programs with timers without waiting.
+func NewWithClock(bytecode *compiler.Bytecode, clock eventloop.Clock) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Name: "main", Lines: bytecode.Lines}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0, -1)
 
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
 
+	loop := eventloop.New(clock)
+
+	builtins := make([]*object.Builtin, len(object.Builtins))
+	for i, definition := range object.Builtins {
+		builtins[i] = definition.Builtin
+		if bound := object.EventLoopBuiltin(definition.Name, loop); bound != nil {
+			builtins[i] = bound
+		}
+	}
+
 	return &VM{
 		constants: bytecode.Constants,
 
 		stack: make([]object.Object, StackSize),
 		sp:    0,
 
-		globals: make([]object.Object, GlobalsSize),
+		globals:     make([]object.Object, GlobalsSize),
+		globalsLock: &sync.RWMutex{},
+
+		builtins: builtins,
+		loop:     loop,
 
 		frames:      frames,
 		framesIndex: 1,
+
+		file:        bytecode.File,
+		globalNames: bytecode.Globals,
 	}
 }
 
@@ -56,7 +104,38 @@ func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	return vm
 }
 
+// SetIO makes read use in instead of stdin and print and println write to out
+// instead of stdout.
+func (vm *VM) SetIO(in io.Reader, out io.Writer) {
+	for i, definition := range object.Builtins {
+		if bound := object.IOBuiltin(definition.Name, in, out); bound != nil {
+			vm.builtins[i] = bound
+		}
+	}
+}
+
+// Run executes the program. Afterwards, the callbacks of all timers are run
+// until no timers are left.
 func (vm *VM) Run() error {
+	err := vm.run()
+	if err != nil {
+		return vm.runtimeError(err)
+	}
+
+	if vm.loop == nil {
+		return nil
+	}
+
+	return vm.loop.Run(func(callback object.Object) error {
+		if err := vm.runCallback(callback); err != nil {
+			return vm.runtimeError(err)
+		}
+
+		return nil
+	})
+}
+
+func (vm *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
@@ -68,6 +147,12 @@ func (vm *VM) Run() error {
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
 
+		if vm.hook != nil {
+			if err := vm.hook(vm); err != nil {
+				return err
+			}
+		}
+
 		switch op {
 		case code.OpConstant:
 			constIndex := code.ReadUint16(ins[ip+1:])
@@ -146,19 +231,19 @@ func (vm *VM) Run() error {
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			vm.globals[globalIndex] = vm.pop()
+			vm.setGlobal(globalIndex, vm.pop())
 
 		case code.OpAssignGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			vm.globals[globalIndex] = vm.stack[vm.sp-1]
+			vm.setGlobal(globalIndex, vm.stack[vm.sp-1])
 
 		case code.OpGetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			err := vm.push(vm.getGlobal(globalIndex))
 			if err != nil {
 				return err
 			}
@@ -202,6 +287,21 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpSet:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			set, err := object.NewSet(vm.stack[vm.sp-numElements : vm.sp]...)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			err = vm.push(set)
+			if err != nil {
+				return err
+			}
+
 		case code.OpHash:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -239,6 +339,11 @@ func (vm *VM) Run() error {
 		case code.OpReturn:
 			returnValue := vm.pop()
 
+			// Returning from the bottom frame ends the program or generator.
+			if vm.framesIndex == 1 {
+				return nil
+			}
+
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
 
@@ -247,13 +352,26 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpYield:
+			vm.yielded = vm.pop()
+
+			// The yield expression itself evaluates to null once resumed
+			return vm.push(Null)
+
+		case code.OpSpawn:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeSpawn(int(numArgs))
+			if err != nil {
+				return err
+			}
+
 		case code.OpGetBuiltin:
 			builtinIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 
-			definition := object.Builtins[builtinIndex]
-
-			err := vm.push(definition.Builtin)
+			err := vm.push(vm.builtins[builtinIndex])
 			if err != nil {
 				return err
 			}
@@ -286,6 +404,59 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpRange:
+			inclusive := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeRangeOperator(inclusive == 1)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIterator:
+			iterable := vm.pop()
+
+			iterator, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("object is not iterable: %s", iterable.Type())
+			}
+
+			err := vm.push(iterator)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			numValues := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.executeIterNext(pos, int(numValues))
+			if err != nil {
+				return err
+			}
+
+		case code.OpAppend:
+			value := vm.pop()
+			array := vm.pop()
+
+			arrayObject, ok := array.(*object.Array)
+			if !ok {
+				return fmt.Errorf("append not supported: %s", array.Type())
+			}
+
+			arrayObject.Elements = append(arrayObject.Elements, value)
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+
 		case code.OpNop:
 		}
 	}
@@ -293,6 +464,24 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// getGlobal reads a global. Spawned tasks share the globals with the VM that
+// started them, so reads hold the read lock.
+func (vm *VM) getGlobal(index uint16) object.Object {
+	vm.globalsLock.RLock()
+	defer vm.globalsLock.RUnlock()
+
+	return vm.globals[index]
+}
+
+// setGlobal writes a global while holding the write lock, so tasks running
+// at the same time never see a partly updated slot.
+func (vm *VM) setGlobal(index uint16, value object.Object) {
+	vm.globalsLock.Lock()
+	defer vm.globalsLock.Unlock()
+
+	vm.globals[index] = value
+}
+
 // This is a test only method
 func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
@@ -322,9 +511,9 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	rightType := right.Type()
 
 	switch {
-	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
+	case object.IsInteger(left) && object.IsInteger(right):
 		return vm.executeBinaryIntegerOperation(op, left, right)
-	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
+	case isText(left) && isText(right):
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
 		return fmt.Errorf("unsupported types for binary operation: %s %s",
@@ -333,25 +522,27 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 }
 
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
-
-	var result int64
+	var operator string
 
 	switch op {
 	case code.OpAdd:
-		result = leftValue + rightValue
+		operator = "+"
 	case code.OpSub:
-		result = leftValue - rightValue
+		operator = "-"
 	case code.OpMul:
-		result = leftValue * rightValue
+		operator = "*"
 	case code.OpDiv:
-		result = leftValue / rightValue
+		operator = "/"
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
 
-	return vm.push(&object.Integer{Value: result})
+	result, err := object.IntegerOperation(operator, left, right)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(result)
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
@@ -359,30 +550,48 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 		return fmt.Errorf("unknown string operator: %d", op)
 	}
 
-	leftValue := left.(*object.String).Value
-	rightValue := right.(*object.String).Value
-
-	return vm.push(&object.String{Value: leftValue + rightValue})
+	return vm.push(&object.String{Value: left.Inspect() + right.Inspect()})
 }
 
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ || right.Type() == object.INTEGER_OBJ {
+	if object.IsInteger(left) && object.IsInteger(right) {
 		return vm.executeIntegerComparison(op, left, right)
 	}
 
-	if left.Type() == object.STRING_OBJ || right.Type() == object.STRING_OBJ {
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
 		return vm.executeStringComparison(op, left, right)
 	}
 
+	if left.Type() == object.CHAR_OBJ && right.Type() == object.CHAR_OBJ {
+		return vm.executeCharComparison(op, left, right)
+	}
+
 	return vm.executeBooleanComparison(op, left, right)
 }
 
 func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+	cmp := object.CompareIntegers(left, right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(cmp == 0))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(cmp != 0))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(cmp > 0))
+	case code.OpGreaterOrEqual:
+		return vm.push(nativeBoolToBooleanObject(cmp >= 0))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
 
 	switch op {
 	case code.OpEqual:
@@ -398,9 +607,9 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 	}
 }
 
-func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.String).Value
-	rightValue := right.(*object.String).Value
+func (vm *VM) executeCharComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.Char).Value
+	rightValue := right.(*object.Char).Value
 
 	switch op {
 	case code.OpEqual:
@@ -419,14 +628,19 @@ func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object)
 func (vm *VM) executeBooleanComparison(op code.Opcode, left, right object.Object) error {
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
+		return vm.push(nativeBoolToBooleanObject(object.Equals(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
+		return vm.push(nativeBoolToBooleanObject(!object.Equals(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
 	}
 }
 
+// isText reports whether obj is a String or a Char, which can be concatenated.
+func isText(obj object.Object) bool {
+	return obj.Type() == object.STRING_OBJ || obj.Type() == object.CHAR_OBJ
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return True
@@ -449,6 +663,10 @@ func (vm *VM) executeBangOperator() error {
 			return vm.push(True)
 		}
 
+		if isEmptySet(operand) {
+			return vm.push(True)
+		}
+
 		return vm.push(False)
 	}
 }
@@ -467,6 +685,10 @@ func (vm *VM) executeCastToBoolOperator() error {
 			return vm.push(False)
 		}
 
+		if isEmptySet(operand) {
+			return vm.push(False)
+		}
+
 		return vm.push(True)
 	}
 }
@@ -474,12 +696,75 @@ func (vm *VM) executeCastToBoolOperator() error {
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
-	if operand.Type() != object.INTEGER_OBJ {
+	if !object.IsInteger(operand) {
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
 
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return vm.push(object.NegateInteger(operand))
+}
+
+func (vm *VM) executeRangeOperator(inclusive bool) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	if left.Type() != object.INTEGER_OBJ || right.Type() != object.INTEGER_OBJ {
+		return fmt.Errorf("unsupported types for range: %s %s", left.Type(), right.Type())
+	}
+
+	start := left.(*object.Integer).Value
+	end := right.(*object.Integer).Value
+
+	return vm.push(&object.Range{Start: start, End: end, Inclusive: inclusive})
+}
+
+// executeIterNext advances the iterator on top of the stack and pushes the
+// next values. Once the iterator is exhausted, it is removed from the stack and
+// execution continues at pos.
+func (vm *VM) executeIterNext(pos int, numValues int) error {
+	iterator := vm.stack[vm.sp-1].(*object.Iterator)
+
+	if numValues == 2 {
+		key, value, ok := iterator.Next()
+		if !ok {
+			vm.pop()
+			vm.currentFrame().ip = pos - 1
+			return iterator.Err()
+		}
+
+		err := vm.push(key)
+		if err != nil {
+			return err
+		}
+
+		return vm.push(value)
+	}
+
+	element, ok := iterator.NextElement()
+	if !ok {
+		vm.pop()
+		vm.currentFrame().ip = pos - 1
+		return iterator.Err()
+	}
+
+	return vm.push(element)
+}
+
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Hash:
+		return left.Set(index, value)
+
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok || i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index out of range: %s", index.Inspect())
+		}
+
+		return left.Set(i.Value, value)
+
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
 }
 
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
@@ -493,22 +778,16 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
 	for i := startIndex; i < endIndex; i += 2 {
-		key := vm.stack[i]
-		value := vm.stack[i+1]
-		pair := object.HashPair{Key: key, Value: value}
-
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		err := hash.Set(vm.stack[i], vm.stack[i+1])
+		if err != nil {
+			return nil, err
 		}
-
-		hashedPairs[hashKey.HashKey()] = pair
 	}
 
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
@@ -522,6 +801,9 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	case left.Type() == object.STRING_OBJ:
 		return vm.executeStringIndex(left, index)
 
+	case left.Type() == object.RANGE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeRangeIndex(left, index)
+
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
@@ -542,32 +824,36 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	value, ok, err := hashObject.Get(index)
+	if err != nil {
+		return err
 	}
-
-	pair, ok := hashObject.Pairs[key.HashKey()]
 	if !ok {
 		return vm.push(Null)
 	}
 
-	return vm.push(pair.Value)
+	return vm.push(value)
 }
 
 func (vm *VM) executeStringIndex(stringObj, index object.Object) error {
-	str := stringObj.(*object.String).Value
+	str := stringObj.(*object.String)
 	idx := index.(*object.Integer).Value
-	max := int64(len(str) - 1)
 
-	if idx < 0 || idx > max {
+	ch, ok := str.At(idx)
+	if !ok {
 		return vm.push(Null)
 	}
 
-	chars := []rune(str)
-	ret := chars[idx]
+	return vm.push(&object.String{Value: string(ch)})
+}
+
+func (vm *VM) executeRangeIndex(rangeObj, index object.Object) error {
+	value, ok := rangeObj.(*object.Range).At(index.(*object.Integer).Value)
+	if !ok {
+		return vm.push(Null)
+	}
 
-	return vm.push(&object.String{Value: string(ret)})
+	return vm.push(value)
 }
 
 func (vm *VM) executeCall(numArgs int) error {
@@ -586,13 +872,19 @@ func (vm *VM) executeCall(numArgs int) error {
 }
 
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
-	numRequiredArgs := cl.Fn.NumParameters - cl.Fn.NumDefaults
-	if numArgs < numRequiredArgs || numArgs > cl.Fn.NumParameters {
-		if cl.Fn.NumDefaults > 0 {
-			return fmt.Errorf("wrong number of arguments: want=%d-%d, got=%d", numRequiredArgs, cl.Fn.NumParameters, numArgs)
-		}
+	err := checkArguments(cl, numArgs)
+	if err != nil {
+		return err
+	}
 
-		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	// Calling a generator function does not run its body, it only creates a
+	// generator that runs the body on demand.
+	if cl.Fn.Generator {
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp -= numArgs + 1
+
+		return vm.push(vm.newGenerator(cl, args))
 	}
 
 	// Optimize tail calls and avoid creating a new frame
@@ -608,22 +900,45 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 		}
 	}
 
-	// Default parameters are inserted in the beginning of the function. When the
-	// function is called, we need to calculate how many default parameteres are
-	// left undefined and skip those that have been assigned. For us to be able to
-	// skipp parameters, each parameter has to take up a constant amount of
-	// instructions.
-	skipedDefaultArgs := cl.Fn.NumDefaults - (cl.Fn.NumParameters - numArgs)
-	ipOffset := (skipedDefaultArgs * (code.OptionalParameterInstructions + 2)) - 1
-
-	frame := NewFrame(cl, vm.sp-numArgs, ipOffset)
+	frame := NewFrame(cl, vm.sp-numArgs, defaultParametersOffset(cl, numArgs))
 	vm.pushFrame(frame)
 
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
+	// The stack still holds old values where the locals are stored. They are
+	// cleared so that debuggers only show the locals that have been set.
+	if vm.hook != nil {
+		for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+			vm.stack[i] = nil
+		}
+	}
+
+	return nil
+}
+
+func checkArguments(cl *object.Closure, numArgs int) error {
+	numRequiredArgs := cl.Fn.NumParameters - cl.Fn.NumDefaults
+	if numArgs < numRequiredArgs || numArgs > cl.Fn.NumParameters {
+		if cl.Fn.NumDefaults > 0 {
+			return fmt.Errorf("wrong number of arguments: want=%d-%d, got=%d", numRequiredArgs, cl.Fn.NumParameters, numArgs)
+		}
+
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
 	return nil
 }
 
+// Default parameters are inserted in the beginning of the function. When the
+// function is called, we need to calculate how many default parameteres are
+// left undefined and skip those that have been assigned. For us to be able to
+// skipp parameters, each parameter has to take up a constant amount of
+// instructions.
+func defaultParametersOffset(cl *object.Closure, numArgs int) int {
+	skipedDefaultArgs := cl.Fn.NumDefaults - (cl.Fn.NumParameters - numArgs)
+	return (skipedDefaultArgs * (code.OptionalParameterInstructions + 2)) - 1
+}
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
@@ -666,11 +981,21 @@ func isTruthy(obj object.Object) bool {
 	case *object.Null:
 		return false
 
+	case *object.Set:
+		return obj.Len() != 0
+
 	default:
 		return true
 	}
 }
 
+// isEmptySet reports whether obj is a set without elements. Empty sets are
+// falsy in both engines.
+func isEmptySet(obj object.Object) bool {
+	set, ok := obj.(*object.Set)
+	return ok && set.Len() == 0
+}
+
 /*
 ** Frames
  */
@@ -1,11 +1,15 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/rhwilr/lemur/ast"
 	"github.com/rhwilr/lemur/compiler"
+	"github.com/rhwilr/lemur/evaluator"
+	"github.com/rhwilr/lemur/eventloop"
 	"github.com/rhwilr/lemur/lexer"
 	"github.com/rhwilr/lemur/object"
 	"github.com/rhwilr/lemur/optimizer"
@@ -26,6 +30,7 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"1 * 2", 2},
 		{"4 / 2", 2},
 		{"50 / 2 * 2 + 10 - 5", 55},
+		{"0xFF + 0o10 + 0b1 + 1_000", 1264},
 		{"5 + 5 + 5 + 5 - 10", 10},
 		{"2 * 2 * 2 * 2 * 2", 32},
 		{"5 * 2 + 10", 20},
@@ -40,6 +45,58 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"let a = 9223372036854775807; a + 1", "9223372036854775808"},
+		{"let a = -9223372036854775807; a - 2", "-9223372036854775809"},
+		{"let a = -9223372036854775807 - 1; -a", "9223372036854775808"},
+		{"let a = 4294967296; a * a", "18446744073709551616"},
+		{"let a = 100000000000000000000; a / 10000000000", "10000000000"},
+		{"let a = 9223372036854775807; a++; a", "9223372036854775808"},
+		{"let a = 100000000000000000000; a > 9223372036854775807", "true"},
+		{"let a = 100000000000000000000; a == 100000000000000000000", "true"},
+		{"let a = 100000000000000000000; a != 100000000000000000001", "true"},
+		{`let a = 100000000000000000000; {a: "big"}[100000000000000000000]`, "big"},
+		{`'ö'`, "ö"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		// Round trip through the binary format to cover the big integer constants
+		bytecode, err := compiler.Read(comp.Bytecode().Write())
+		if err != nil {
+			t.Fatalf("bytecode error: %s", err)
+		}
+
+		vm := New(bytecode)
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		result := vm.LastPoppedStackElem()
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s (%T)",
+				tt.input, tt.expected, result.Inspect(), result)
+		}
+	}
+
+	runVmTests(t, []vmTestCase{
+		{"let a = 100000000000000000000; a - 99999999999999999999", 1},
+	})
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -113,6 +170,289 @@ func TestStringExpressions(t *testing.T) {
 		{`"lemur"`, "lemur"},
 		{`"le" + "mur"`, "lemur"},
 		{`"le" + "mur" + "banana"`, "lemurbanana"},
+		{`"héllo wörld"[7]`, "ö"},
+		{`"日本語"[2]`, "語"},
+		{`"日本語"[3]`, Null},
+		{`len("日本語")`, 3},
+		{`"ab" + 'c'`, "abc"},
+		{`'a' + "bc"`, "abc"},
+		{`let 名前 = "lemur"; 名前`, "lemur"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] != [1, 2]`, false},
+		{`[1, [2, "a"]] == [1, [2, "a"]]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`{"a": [1]} == {"a": [1]}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`let f = function() {}; f == f`, true},
+		{`function() {} == function() {}`, false},
+		{`{[1, 2]: "a"}[[1, 2]]`, "a"},
+		{`{{"x": 1}: "a"}[{"x": 1}]`, "a"},
+		{`let cache = {[0, 0]: "origin"}; cache[[0, 0]]`, "origin"},
+		{`{[x, x]: x for x in 1..3}[[2, 2]]`, 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, "c": 3}`, `{b: 1, a: 2, c: 3}`},
+		{`keys({3: 1, 1: 2, 2: 3})`, `[3, 1, 2]`},
+		{`[k for k, v in {"z": 1, "y": 2}]`, `[z, y]`},
+		{`{x: x for x in [3, 1, 3, 2]}`, `{3: 3, 1: 1, 2: 2}`},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		result := vm.LastPoppedStackElem()
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`error("failed")`, "ERROR: failed"},
+		{`isError(error("failed"))`, "true"},
+		{`isError(1)`, "false"},
+		{`isError(len(1))`, "true"},
+		{`let e = len(1); errorMessage(e)`, "argument to `len` not supported, got INTEGER"},
+		{`errorMessage(error("failed"))`, "failed"},
+		{`errorData(error("failed", {"code": 404}))`, "{code: 404}"},
+		{`errorData(error("failed"))`, "null"},
+		{`let e = error("not found"); errorMessage(wrap(e, "reading config"))`, "reading config: not found"},
+		{`wrap(wrap(error("a"), "b"), "c")`, "ERROR: c: b: a"},
+		{`let e = error("a"); unwrap(wrap(e, "b")) == e`, "true"},
+		{`unwrap(error("a"))`, "null"},
+		{`let f = function() { return error("failed"); 1 }; let r = f(); if (isError(r)) { "recovered" } else { r }`, "recovered"},
+		{`let f = function() { error("failed"); 1 }; f()`, "1"},
+		{`[error("a"), 1][1]`, "1"},
+		{`let i = 0; while (i < 3) { error("x"); i++ }; i`, "3"},
+		{`if (error("a")) { "truthy" }`, "truthy"},
+		{`error(1)`, "ERROR: first argument to `error` must be STRING, got INTEGER"},
+		{`errorMessage("a")`, "ERROR: first argument to `errorMessage` must be ERROR, got STRING"},
+		{`wrap(error("a"), 1)`, "ERROR: second argument to `wrap` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		result := vm.LastPoppedStackElem()
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestReflection(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`type(1)`, "INTEGER"},
+		{`type(100000000000000000000)`, "INTEGER"},
+		{`type("a")`, "STRING"},
+		{`type('a')`, "CHAR"},
+		{`type(true)`, "BOOLEAN"},
+		{`type(if (false) { 1 })`, "NULL"},
+		{`type([])`, "ARRAY"},
+		{`type({})`, "HASH"},
+		{`type(#{})`, "SET"},
+		{`type(1..2)`, "RANGE"},
+		{`type(function() {})`, "FUNCTION"},
+		{`let x = 1; type(function() { x })`, "FUNCTION"},
+		{`let fibonacci = function(x) { x }; fibonacci`, "function fibonacci(x)"},
+		{`function add(a, b = 1) { a + b }; add`, "function add(a, b)"},
+		{`function() {}`, "function()"},
+		{`let f = function(a, b) { a }; name(f)`, "f"},
+		{`name(function() {})`, "null"},
+		{`name(len)`, "len"},
+		{`let f = function(a, b) { a }; arity(f)`, "2"},
+		{`arity(function() {})`, "0"},
+		{`let x = 1; arity(function(a) { a + x })`, "1"},
+		{`params(function(a, b = 2) { a })`, "[a, b]"},
+		{`let name = "shadowed"; name`, "shadowed"},
+		{`type(len)`, "BUILTIN"},
+		{`name(println)`, "println"},
+		{`arity(len)`, "ERROR: argument to `arity` must be FUNCTION, got BUILTIN"},
+		{`params(len)`, "ERROR: argument to `params` must be FUNCTION, got BUILTIN"},
+		{`[f(arity) for f in [type, name]]`, "[BUILTIN, arity]"},
+		{`params(1)`, "ERROR: argument to `params` must be FUNCTION, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		// Round trip through the binary format to cover the function names
+		bytecode, err := compiler.Read(comp.Bytecode().Write())
+		if err != nil {
+			t.Fatalf("bytecode error: %s", err)
+		}
+
+		vm := New(bytecode)
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		result := vm.LastPoppedStackElem()
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`frozen([1])`, "false"},
+		{`frozen(freeze([1]))`, "true"},
+		{`frozen(1)`, "false"},
+		{`freeze({"a": [1]})`, "{a: [1]}"},
+		{`let a = [1]; freeze([a]); frozen(a)`, "true"},
+		{`let h = {"a": #{1}}; freeze(h); frozen(h["a"])`, "true"},
+		{`const c = {"a": [1]}; frozen(c)`, "false"},
+		{`const freeze c = {"a": [1]}; frozen(c)`, "true"},
+		{`const freeze c = {"a": [1]}; frozen(c["a"])`, "true"},
+		{`const freeze c = #{1}; add(c, 2)`, "#{1, 2}"},
+		{`frozen(push(freeze([1]), 2))`, "false"},
+		{`let freeze = function(x) { x }; const freeze c = [1]; frozen(c)`, "true"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		result := vm.LastPoppedStackElem()
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestSets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`#{1, 2, 2, 3}`, "#{1, 2, 3}"},
+		{`#{}`, "#{}"},
+		{`#{"b", "a"}`, "#{b, a}"},
+		{`len(#{1, 1, [1], [1]})`, "2"},
+		{`has(#{1, 2}, 2)`, "true"},
+		{`has(#{1, 2}, 3)`, "false"},
+		{`add(#{1}, 2)`, "#{1, 2}"},
+		{`let s = #{1}; add(s, 2); s`, "#{1}"},
+		{`remove(#{1, 2, 3}, 2)`, "#{1, 3}"},
+		{`union(#{1, 2}, #{2, 3})`, "#{1, 2, 3}"},
+		{`intersection(#{1, 2, 3}, #{3, 2, 4})`, "#{2, 3}"},
+		{`difference(#{1, 2, 3}, #{2})`, "#{1, 3}"},
+		{`#{1, 2} == #{2, 1}`, "true"},
+		{`#{1, 2} == #{1}`, "false"},
+		{`#{} || 5`, "true"},
+		{`#{1} && true`, "true"},
+		{`#{} || false`, "false"},
+		{`!#{}`, "true"},
+		{`!#{1}`, "false"},
+		{`if (#{}) { 1 } else { 2 }`, "2"},
+		{`[x * 2 for x in #{3, 1, 3}]`, "[6, 2]"},
+		{`{#{1, 2}: "a"}[#{2, 1}]`, "a"},
+		{`let add = function(a, b) { a + b }; add(1, 2)`, "3"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		result := vm.LastPoppedStackElem()
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestCharacters(t *testing.T) {
+	tests := []vmTestCase{
+		{`'a'`, 'a'},
+		{`'\t'`, '\t'},
+		{`chr(97)`, 'a'},
+		{`ord('a')`, 97},
+		{`ord("語")`, 35486},
+		{`'a' == 'a'`, true},
+		{`'a' != 'a'`, false},
+		{`'a' < 'b'`, true},
+		{`'b' >= 'c'`, false},
+		{`{'a': 1}['a']`, 1},
+		{`chr(1114112)`, &object.Error{Message: "argument to `chr` is not a valid code point, got 1114112"}},
 	}
 
 	runVmTests(t, tests)
@@ -519,6 +859,17 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	}
 }
 
+func TestShadowingBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`let len = function(x) { 42 }; len([1])`, 42},
+		{`let add = function(a, b) { a + b }; add(1, 2)`, 3},
+		{`let f = function() { let len = 1; len }; f() + len([1, 2])`, 3},
+		{`let f = function(len) { len }; f(5)`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{`len("")`, 0},
@@ -733,6 +1084,30 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestSetIndex(t *testing.T) {
+	vm := New(&compiler.Bytecode{})
+
+	array := &object.Array{Elements: []object.Object{&object.Integer{Value: 1}}}
+	if err := vm.executeSetIndex(array, &object.Integer{Value: 0}, &object.Integer{Value: 2}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if array.Inspect() != "[2]" {
+		t.Errorf("wrong array. got=%s", array.Inspect())
+	}
+
+	if err := vm.executeSetIndex(array, &object.Integer{Value: 1}, &object.Integer{Value: 3}); err == nil || err.Error() != "index out of range: 1" {
+		t.Errorf("expected index error, got=%v", err)
+	}
+
+	object.Freeze(array)
+	if err := vm.executeSetIndex(array, &object.Integer{Value: 0}, &object.Integer{Value: 3}); err == nil || err.Error() != "cannot modify frozen ARRAY" {
+		t.Errorf("expected error for frozen array, got=%v", err)
+	}
+	if array.Inspect() != "[2]" {
+		t.Errorf("frozen array was modified. got=%s", array.Inspect())
+	}
+}
+
 func TestWhileLoopExpression(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -819,6 +1194,84 @@ func TestTailCalls(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestRanges(t *testing.T) {
+	tests := []vmTestCase{
+		{"len(1..10)", 10},
+		{"len(0..<10)", 10},
+		{"len(5..1)", 0},
+		{"let n = 3; len(1..n + 1)", 4},
+		{"(1..10)[0]", 1},
+		{"(1..10)[9]", 10},
+		{"(0..<10)[10]", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestComprehensions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[x * 2 for x in [1, 2, 3]]", []int{2, 4, 6}},
+		{"[x for x in [1, -2, 3] if x > 0]", []int{1, 3}},
+		{"[x for x in 1..5 if x != 3]", []int{1, 2, 4, 5}},
+		{"[i * v for i, v in [4, 5, 6]]", []int{0, 5, 12}},
+		{"[x for x in []]", []int{}},
+		{"let k = 10; [x + k for x in 0..<2]", []int{10, 11}},
+		{"function f(k) { [x + k for x in 0..<2] }; f(20)", []int{20, 21}},
+		{"let x = 5; [x for x in 1..2]; x", 5},
+		{`len([c for c in "abc"])`, 3},
+		{"len([[y for y in 0..<x] for x in 1..3][2])", 3},
+		{
+			`{k: v * 2 for k, v in {"a": 1, "b": 2}}`,
+			map[object.HashKey]int64{
+				(&object.String{Value: "a"}).HashKey(): 2,
+				(&object.String{Value: "b"}).HashKey(): 4,
+			},
+		},
+		{
+			`{v: i for i, v in [7, 8]}`,
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 7}).HashKey(): 0,
+				(&object.Integer{Value: 8}).HashKey(): 1,
+			},
+		},
+		{
+			// the loop must not grow the stack
+			"len([x for x in 0..<10000])",
+			10000,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+func TestArrowFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let double = x => x * 2; double(5);", 10},
+		{"let add = (a, b) => a + b; add(5, 5);", 10},
+		{"let add = (a, b = 3) => { a + b }; add(5);", 8},
+		{"(() => 7)()", 7},
+		{"let adder = x => y => x + y; adder(2)(3);", 5},
+		{"let fib = n => if (n < 2) { n } else { fib(n - 1) + fib(n - 2) }; fib(10);", 55},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestPipelineOperator(t *testing.T) {
+	tests := []vmTestCase{
+		{"let double = x => x * 2; 5 |> double", 10},
+		{"let add = (a, b) => a + b; 5 |> add(3)", 8},
+		{
+			`let filter = (xs, f) => [x for x in xs if f(x)];
+			let map = (xs, f) => [f(x) for x in xs];
+			let isEven = x => x / 2 * 2 == x;
+			1..6 |> filter(isEven) |> map(x => x * 10)`,
+			[]int{20, 40, 60},
+		},
+		{`[1, 2, 3] |> len`, 3},
+	}
+
+	runVmTests(t, tests)
+}
 
 /*
 ** Helpers
@@ -858,7 +1311,8 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 		// fmt.Printf("\n\n Instructions:\n")
 		// fmt.Printf(comp.Bytecode().Instructions.String())
 
-		vm := New(comp.Bytecode())
+		// The fake clock runs timers without waiting for them
+		vm := NewWithClock(comp.Bytecode(), eventloop.NewFakeClock())
 		err = vm.Run()
 		if err != nil {
 			t.Fatalf("vm error: %s", err)
@@ -879,7 +1333,12 @@ func parse(t *testing.T, input string) *ast.Program {
 		t.Fatalf("parse error: %s", errors)
 	}
 
-	return parsed
+	expanded, err := evaluator.ExpandProgram(parsed, object.NewEnvironment())
+	if err != nil {
+		t.Fatalf("macro error: %s", err)
+	}
+
+	return expanded
 }
 
 func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
@@ -892,6 +1351,17 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
 
+	case rune:
+		char, ok := actual.(*object.Char)
+		if !ok {
+			t.Errorf("object is not Char: %T (%+v)", actual, actual)
+			return
+		}
+
+		if char.Value != expected {
+			t.Errorf("wrong char. want=%q, got=%q", expected, char.Value)
+		}
+
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -935,15 +1405,17 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
-			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), len(hash.Pairs))
+		if hash.Len() != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), hash.Len())
 			return
 		}
 
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+		for _, pair := range hash.Pairs() {
+			hashKey, _ := object.HashKeyOf(pair.Key)
+			expectedValue, ok := expected[hashKey]
 			if !ok {
-				t.Errorf("no pair for given key in Pairs")
+				t.Errorf("unexpected key %s in Pairs", pair.Key.Inspect())
+				continue
 			}
 
 			err := testIntegerObject(expectedValue, pair.Value)
@@ -1003,3 +1475,284 @@ func testStringObject(expected string, actual object.Object) error {
 
 	return nil
 }
+
+func TestGenerators(t *testing.T) {
+	tests := []vmTestCase{
+		{"let g = function() { yield 1; yield 2; }(); next(g)", 1},
+		{"let g = function() { yield 1; yield 2; }(); next(g); next(g)", 2},
+		{"let g = function() { yield 1; }(); next(g); next(g)", Null},
+		{"let g = function() { yield 1; }(); next(g); next(g); next(g)", Null},
+		{"let g = function() { yield; }(); next(g)", Null},
+		{"let g = function() { yield 1; return 5; yield 2; }(); next(g); next(g)", Null},
+		{
+			`let count = function(n) { let i = 0; while (i < n) { yield i; i = i + 1; } };
+			[x * 10 for x in count(4)]`,
+			[]int{0, 10, 20, 30},
+		},
+		{"let f = function(a, b = 10) { yield a; yield b; }; [x for x in f(1)]", []int{1, 10}},
+		{"let f = function(a, b = 10) { yield a; yield b; }; [x for x in f(1, 2)]", []int{1, 2}},
+		{"[i for i, x in function() { yield 7; yield 8; }()]", []int{0, 1}},
+		{
+			// the body only runs when the generator is resumed
+			"let n = 0; let g = function() { n = n + 1; yield n; }(); let a = n; next(g); a + n * 10",
+			10,
+		},
+		{
+			`let naturals = function() { let i = 1; while (true) { yield i; i++; } };
+			let take = function(g, n) { [next(g) for x in 1..n] };
+			take(naturals(), 3)`,
+			[]int{1, 2, 3},
+		},
+		{
+			// iterating continues where next left off
+			"let g = function() { yield 1; yield 2; }(); next(g); [x for x in g]",
+			[]int{2},
+		},
+		{"let g = (x => yield x * 2)(4); next(g)", 8},
+		{"function() { return 5; }(); return 3; 4", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGeneratorErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    `[x for x in function() { yield 1; 1 + true; }()]`,
+			expected: `unsupported types for binary operation: INTEGER BOOLEAN`,
+		},
+		{
+			input:    `let a = 0; 1 / a`,
+			expected: `division by zero`,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input: "let add = function(a, b) {\n  a + b\n};\nlet twice = function(x) {\n  add(x, 2) * 2\n};\ntwice(1);\ntwice(\"a\");",
+			expected: "    at add (main.lem:2:5)\n" +
+				"    at twice (main.lem:5:3)\n" +
+				"    at main (main.lem:8:1)",
+		},
+		{
+			input:    "let f = function() { [1, 2] - 1 };\n\nf()",
+			expected: "    at f (main.lem:1:29)\n    at main (main.lem:3:1)",
+		},
+		{
+			input:    "function() {\n  -\"a\"\n}()",
+			expected: "    at <anonymous> (main.lem:2:3)\n    at main (main.lem:1:1)",
+		},
+		{
+			input:    "let tick = function() {\n  1 + true\n};\nsetTimeout(tick, 10);",
+			expected: "    at tick (main.lem:2:5)",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithFile(tt.input, "main.lem")
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("parse error: %s", p.Errors())
+		}
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		// The line tables must survive the binary format
+		bytecode, err := compiler.Read(comp.Bytecode().Write())
+		if err != nil {
+			t.Fatalf("bytecode error: %s", err)
+		}
+
+		vm := NewWithClock(bytecode, eventloop.NewFakeClock())
+		err = vm.Run()
+
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("expected RuntimeError, got=%T (%v)", err, err)
+		}
+
+		if runtimeErr.StackTrace() != tt.expected {
+			t.Errorf("wrong stack trace for %q.\nwant=\n%s\ngot=\n%s", tt.input, tt.expected, runtimeErr.StackTrace())
+		}
+	}
+}
+
+func TestSetIO(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`print("a", 1); print('b');`, "a1b"},
+		{`println(); println([1, 2], "x");`, "\n[1, 2]\nx\n"},
+		{`wait(spawn function() { println("task") }());`, "task\n"},
+		{`println(read()); print(read());`, "first\nsecond"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(t, tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		var out bytes.Buffer
+		vm := New(comp.Bytecode())
+		vm.SetIO(strings.NewReader("first\nsecond\n"), &out)
+
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		if out.String() != tt.expected {
+			t.Errorf("wrong output for %q. want=%q, got=%q", tt.input, tt.expected, out.String())
+		}
+	}
+}
+
+func TestSpawnAndChannels(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let fib = function(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+			[wait(t) for t in [spawn fib(n) for n in 10..12]]`,
+			[]int{55, 89, 144},
+		},
+		{"wait(spawn function(a, b = 2) { a * b }(21))", 42},
+		{"wait(spawn len([1, 2]))", 2},
+		{
+			`let ch = channel();
+			spawn function() { [send(ch, x) for x in 1..3]; close(ch); }();
+			[x * 2 for x in ch]`,
+			[]int{2, 4, 6},
+		},
+		{"let ch = channel(2); send(ch, 1); send(ch, 2); recv(ch) + recv(ch)", 3},
+		{"let ch = channel(); close(ch); recv(ch)", Null},
+		{"let a = channel(1); let b = channel(1); send(b, 5); select([a, b])", []int{1, 5}},
+		{"let ch = channel(); close(ch); select([ch])[1]", Null},
+		{
+			`let counter = 0;
+			let inc = function() { counter += 1; };
+			[wait(spawn inc()) for x in 1..50];
+			counter`,
+			50,
+		},
+		{"let g = wait(spawn function() { yield 1; }()); next(g)", 1},
+		{
+			"let ch = channel(); close(ch); close(ch)",
+			&object.Error{Message: "close of closed channel"},
+		},
+		{
+			"let ch = channel(); close(ch); send(ch, 1)",
+			&object.Error{Message: "send on closed channel"},
+		},
+		{
+			"wait(spawn function() { 1 + true }())",
+			&object.Error{Message: "unsupported types for binary operation: INTEGER BOOLEAN"},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTimers(t *testing.T) {
+	tests := []vmTestCase{
+		{"setTimeout(() => 5, 10); 1", 5},
+		{"let x = 1; setTimeout(() => { x = 2 }, 10); setTimeout(() => x, 20)", 2},
+		{"setTimeout(() => 1, 20); setTimeout(() => 2, 10)", 1},
+		{"setTimeout(() => 1, 10); setTimeout(() => 2, 10)", 2},
+		{"setTimeout(len, 10)", &object.Error{Message: "wrong number of arguments. got=0, want=1"}},
+		{
+			`let ticks = 0;
+			let t = setInterval(() => { ticks++; if (ticks == 3) { clearInterval(t); } }, 100);
+			setTimeout(() => ticks, 1000)`,
+			3,
+		},
+		{"let t = setTimeout(() => 1, 10); clearTimeout(t); 5", 5},
+		{
+			`let log = [];
+			setTimeout(() => { log = push(log, 2) }, 50);
+			sleep(100);
+			setTimeout(() => { log = push(log, 1) }, 10);
+			setTimeout(() => log, 500)`,
+			[]int{2, 1},
+		},
+		{
+			// timers set by callbacks and tasks keep the program alive
+			`let x = 0;
+			setTimeout(() => { setTimeout(() => { x = 1 }, 10) }, 10);
+			wait(spawn function() { setTimeout(() => { x = x + 10 }, 30) }());
+			setTimeout(() => x, 100)`,
+			11,
+		},
+		{
+			"setTimeout(1, 10)",
+			&object.Error{Message: "first argument to `setTimeout` must be FUNCTION, got INTEGER"},
+		},
+		{
+			"sleep(-1)",
+			&object.Error{Message: "delay of `sleep` must not be negative, got -1"},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMacros(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let twice = macro(x) { quote(unquote(x) + unquote(x)) };
+			twice(21)`,
+			42,
+		},
+		{
+			`let twice = macro(x) { quote(unquote(x) + unquote(x)) };
+			twice(1) + twice(2)`,
+			6,
+		},
+		{
+			`let unless = macro(condition, consequence, alternative) {
+				quote(if (!(unquote(condition))) { unquote(consequence); } else { unquote(alternative); });
+			};
+			unless(10 > 5, "not greater", "greater");`,
+			"greater",
+		},
+		{
+			// the tmp of the macro does not capture the tmp of the caller
+			`let withTmp = macro(body) { quote(function() { let tmp = 100; unquote(body) }()) };
+			let tmp = 1;
+			withTmp(tmp + 1)`,
+			2,
+		},
+	}
+
+	runVmTests(t, tests)
+}